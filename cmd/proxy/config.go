@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nagamocha3000/go-memcached/pkg/client"
+)
+
+type config struct {
+	addr           string
+	backends       []client.Node
+	prefixRoutes   prefixRoutes
+	failover       bool
+	timeout        time.Duration
+	healthInterval time.Duration
+	discover       []string
+	backendToken   string
+	authFile       string
+	tlsCert        string
+	tlsKey         string
+	tlsClientCA    string
+	maxConns       int
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	idleTimeout    time.Duration
+	maxHeaderBytes int
+}
+
+// prefixRoutes collects the repeated -route flag
+type prefixRoutes []prefixRoute
+
+type prefixRoute struct {
+	prefix  string
+	backend string
+}
+
+func (p *prefixRoutes) String() string {
+	routes := make([]string, len(*p))
+	for i, route := range *p {
+		routes[i] = route.prefix + "=" + route.backend
+	}
+	return strings.Join(routes, ",")
+}
+
+func (p *prefixRoutes) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 || i == len(s)-1 {
+		return fmt.Errorf("invalid route %q, want prefix=backend", s)
+	}
+	*p = append(*p, prefixRoute{prefix: s[:i], backend: s[i+1:]})
+	return nil
+}
+
+// parseBackends parses a comma separated list of addr or addr=weight
+func parseBackends(s string) ([]client.Node, error) {
+	var nodes []client.Node
+	for _, b := range strings.Split(s, ",") {
+		b = strings.TrimSpace(b)
+		if b == "" {
+			continue
+		}
+		node := client.Node{Addr: b, Weight: 1}
+		if i := strings.LastIndex(b, "="); i > 0 {
+			weight, err := strconv.Atoi(b[i+1:])
+			if err != nil || weight < 1 {
+				return nil, fmt.Errorf("invalid weight in backend %q", b)
+			}
+			node = client.Node{Addr: b[:i], Weight: weight}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func getConfig() (*config, error) {
+	cfg := config{}
+	var backends string
+	flag.StringVar(&cfg.addr, "addr", ":5000", "http network address")
+	flag.StringVar(&backends, "backends", "localhost:4000",
+		"comma separated backend servers, each addr or addr=weight")
+	flag.Var(&cfg.prefixRoutes, "route",
+		"route keys with a prefix to a backend instead of hashing them, as prefix=addr. Can be repeated")
+	flag.BoolVar(&cfg.failover, "failover", true, "send keys of unhealthy backends to the next healthy one")
+	flag.DurationVar(&cfg.timeout, "timeout", 2*time.Second, "timeout of requests to backends")
+	flag.DurationVar(&cfg.healthInterval, "healthInterval", 5*time.Second, "interval between backend health checks")
+	var discover string
+	flag.StringVar(&discover, "discover", "",
+		"comma separated servers running in cluster mode to fetch the members to hash across from")
+	flag.StringVar(&cfg.backendToken, "backendToken", "",
+		"bearer token presented to backends requiring authentication when discovering members, and on callers' behalf to the "+
+			"discovered ones. Callers' requests to -backends and -route backends carry their own credentials")
+	flag.StringVar(&cfg.authFile, "authFile", "",
+		"JSON file of the users allowed to make requests, as the server's. No authentication if empty, "+
+			"which leaves discovered backends open to anyone through the proxy if -backendToken is set")
+	flag.StringVar(&cfg.tlsCert, "tlsCert", "",
+		"PEM certificate to serve TLS with, reloaded when changed, and presented to backends. Plain http if empty")
+	flag.StringVar(&cfg.tlsKey, "tlsKey", "", "PEM private key of -tlsCert")
+	flag.StringVar(&cfg.tlsClientCA, "tlsClientCA", "",
+		"PEM CAs client certificates are verified against, and backends' too. Clients must then present one")
+	flag.IntVar(&cfg.maxConns, "maxConns", 0,
+		"connections served at once, new ones waiting to be accepted past it. Zero for no limit")
+	flag.DurationVar(&cfg.readTimeout, "readTimeout", 10*time.Second, "how long a client has to send a request. Zero for no limit")
+	flag.DurationVar(&cfg.writeTimeout, "writeTimeout", 0,
+		"how long a response may take from the end of its request, backend calls included. Zero for no limit")
+	flag.DurationVar(&cfg.idleTimeout, "idleTimeout", 2*time.Minute,
+		"how long an idle connection is kept open between requests. Zero to use -readTimeout")
+	flag.IntVar(&cfg.maxHeaderBytes, "maxHeaderBytes", http.DefaultMaxHeaderBytes, "largest request line and headers read")
+	flag.Parse()
+	var err error
+	if cfg.backends, err = parseBackends(backends); err != nil {
+		return nil, err
+	}
+	for _, addr := range strings.Split(discover, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			cfg.discover = append(cfg.discover, addr)
+		}
+	}
+	if len(cfg.backends) == 0 && len(cfg.discover) == 0 {
+		return nil, fmt.Errorf("no backends given")
+	}
+	return &cfg, nil
+}
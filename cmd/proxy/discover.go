@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// discoverMembers keeps the ring in step with the cluster's member list,
+// fetched every interval from the first seed that answers. Live members
+// are added to the ring, and those dead or forgotten removed
+func (api *proxyAPI) discoverMembers(seeds []string, interval time.Duration) {
+	for {
+		for _, seed := range seeds {
+			if err := api.syncMembers(seed, interval); err != nil {
+				api.errorLog.Printf("fetching members from %s: %s", seed, err)
+				continue
+			}
+			break
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (api *proxyAPI) syncMembers(seed string, timeout time.Duration) error {
+	c, err := api.router.client(seed)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	members, err := c.Members(ctx)
+	if err != nil {
+		return err
+	}
+	live := make(map[string]bool)
+	for _, m := range members {
+		if m.APIAddr != "" && m.IsLive() {
+			live[m.APIAddr] = true
+		}
+	}
+	added, removed := api.router.setDiscovered(live)
+	for _, addr := range added {
+		api.infoLog.Printf("adding member at %s", addr)
+	}
+	for _, addr := range removed {
+		api.infoLog.Printf("removing member at %s, dead or gone", addr)
+	}
+	return nil
+}
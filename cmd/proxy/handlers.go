@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+)
+
+type stdReply struct {
+	Reply string `json:"reply"`
+}
+
+type getMultiReply struct {
+	Reply string            `json:"reply"`
+	Vals  map[string]string `json:"vals"`
+	// Replies has the STALE or REFRESH reply of the values past their
+	// TTL, the others being fresh
+	Replies map[string]string `json:"replies,omitempty"`
+}
+
+type statsReply struct {
+	Reply string            `json:"reply"`
+	Stats map[string]string `json:"stats"`
+}
+
+func (api *proxyAPI) home(w http.ResponseWriter, r *http.Request) {
+	reply := "Hello go-memcached proxy"
+	jsonString, _ := json.Marshal(
+		stdReply{reply})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonString)
+}
+
+// fetch sends a GET for the path plus query to the backend, returning
+// the body and status as they are
+func (api *proxyAPI) fetch(ctx context.Context, addr, requestURI string) ([]byte, int, error) {
+	c, err := api.router.client(addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequest(http.MethodGet, c.Addr()+requestURI, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := api.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return body, resp.StatusCode, nil
+}
+
+// backendFailure is why a backend couldn't serve its part of a command,
+// the error reaching it or else the reply it gave
+type backendFailure struct {
+	addr   string
+	err    error
+	status int
+	body   []byte
+}
+
+// fetchReply fetches from the backend and decodes its reply into v,
+// returning why it failed unless it replied with a 200
+func (api *proxyAPI) fetchReply(ctx context.Context, addr, requestURI string, v interface{}) *backendFailure {
+	body, status, err := api.fetch(ctx, addr, requestURI)
+	if err != nil {
+		return &backendFailure{addr: addr, err: err}
+	}
+	if status != http.StatusOK {
+		return &backendFailure{addr: addr, status: status, body: body}
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return &backendFailure{addr: addr, err: err}
+	}
+	return nil
+}
+
+func (f *backendFailure) Error() string {
+	if f.err != nil {
+		return f.err.Error()
+	}
+	return fmt.Sprintf("replied %d %s", f.status, bytes.TrimSpace(f.body))
+}
+
+// passFailure replies with the backend's reply, or with an ERROR if the
+// backend couldn't be reached
+func (api *proxyAPI) passFailure(w http.ResponseWriter, f *backendFailure) {
+	if f.err != nil {
+		api.backendError(w, fmt.Errorf("backend %s: %w", f.addr, f.err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(f.status)
+	w.Write(f.body)
+}
+
+// backendURI returns the request's path and query without the
+// parameters pat adds for the route's variables
+func backendURI(r *http.Request) string {
+	query := r.URL.Query()
+	for name := range query {
+		if strings.HasPrefix(name, ":") {
+			delete(query, name)
+		}
+	}
+	if len(query) == 0 {
+		return r.URL.EscapedPath()
+	}
+	return r.URL.EscapedPath() + "?" + query.Encode()
+}
+
+// handleForward passes a single key command to the key's backend
+func (api *proxyAPI) handleForward(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get(":key")
+	addr, err := api.router.pick(key)
+	if err != nil {
+		api.backendError(w, err)
+		return
+	}
+	body, status, err := api.fetch(r.Context(), addr, backendURI(r))
+	if err != nil {
+		api.backendError(w, fmt.Errorf("backend %s: %w", addr, err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// handleGetMulti fetches the keys from their backends concurrently. A
+// backend failing fails the whole command, its reply being passed on if
+// it gave one, so that callers don't take refused or failed keys for
+// misses
+func (api *proxyAPI) handleGetMulti(w http.ResponseWriter, r *http.Request) {
+	byBackend := make(map[string][]string)
+	for _, key := range r.URL.Query()["key"] {
+		addr, err := api.router.pick(key)
+		if err != nil {
+			api.backendError(w, err)
+			return
+		}
+		byBackend[addr] = append(byBackend[addr], key)
+	}
+	// the command goes to the same namespace on every backend
+	path := r.URL.EscapedPath()
+	vals := make(map[string]string)
+	var replies map[string]string
+	var failure *backendFailure
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for addr, keys := range byBackend {
+		wg.Add(1)
+		go func(addr string, keys []string) {
+			defer wg.Done()
+			var reply getMultiReply
+			f := api.fetchReply(r.Context(), addr, path+"?"+url.Values{"key": keys}.Encode(), &reply)
+			if f == nil && cache.Reply(reply.Reply) != cache.ValueReply {
+				// e.g. a CLIENT_ERROR for an invalid key
+				body, _ := json.Marshal(stdReply{reply.Reply})
+				f = &backendFailure{addr: addr, status: http.StatusOK, body: body}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if f != nil {
+				if failure == nil {
+					failure = f
+				}
+				return
+			}
+			for k, v := range reply.Vals {
+				vals[k] = v
+			}
+			for k, v := range reply.Replies {
+				if replies == nil {
+					replies = make(map[string]string)
+				}
+				replies[k] = v
+			}
+		}(addr, keys)
+	}
+	wg.Wait()
+	if failure != nil {
+		api.passFailure(w, failure)
+		return
+	}
+	jsonString, _ := json.Marshal(
+		getMultiReply{string(cache.ValueReply), vals, replies})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonString)
+}
+
+// eachBackend calls fn concurrently for every live backend
+func (api *proxyAPI) eachBackend(fn func(addr string)) {
+	var wg sync.WaitGroup
+	for _, addr := range api.router.backends() {
+		if api.router.isDead(addr) {
+			continue
+		}
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			fn(addr)
+		}(addr)
+	}
+	wg.Wait()
+}
+
+// handleBroadcast passes the command to every live backend, replying
+// with the first reply that isn't OK, if any
+func (api *proxyAPI) handleBroadcast(w http.ResponseWriter, r *http.Request) {
+	var reply cache.Reply = cache.OkReply
+	var mu sync.Mutex
+	api.eachBackend(func(addr string) {
+		body, _, err := api.fetch(r.Context(), addr, backendURI(r))
+		var backendReply stdReply
+		if err == nil {
+			err = json.Unmarshal(body, &backendReply)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			api.errorLog.Printf("%s on backend %s: %s", r.URL.Path, addr, err)
+			reply = cache.ErrReply
+		} else if cache.Reply(backendReply.Reply) != cache.OkReply && reply == cache.OkReply {
+			reply = cache.Reply(backendReply.Reply)
+		}
+	})
+	jsonString, _ := json.Marshal(
+		stdReply{string(reply)})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonString)
+}
+
+func (api *proxyAPI) handleClear(w http.ResponseWriter, r *http.Request) {
+	api.handleBroadcast(w, r)
+}
+
+// summed are the stats that add up across backends besides counters,
+// see isCounter
+var summed = map[string]bool{
+	"curr_items":        true,
+	"bytes":             true,
+	"curr_connections":  true,
+	"total_connections": true,
+	"lease_held":        true,
+	"store_queue_depth": true,
+	"namespace_bytes":   true,
+}
+
+// counterSuffixes end the names of the stats counting events
+var counterSuffixes = []string{"_hits", "_misses", "_evictions", "_served", "_refreshes",
+	"_issued", "_errors", "_writes", "_retries", "_batches", "_rejected", "_rejected_sets",
+	"_failures", "_successes", "_waits", "_reloads", "_bootstraps", "_badval"}
+
+// isCounter reports whether the stat sums up across backends, as
+// counters and the items and bytes held do, unlike limits such as
+// capacity or sequence numbers
+func isCounter(name string) bool {
+	if summed[name] || strings.HasPrefix(name, "cmd_") || strings.HasPrefix(name, "acl_denials") {
+		return true
+	}
+	// a namespace's, e.g. ns_sessions_get_hits
+	if strings.HasPrefix(name, "ns_") && (strings.HasSuffix(name, "_curr_items") || strings.HasSuffix(name, "_bytes")) {
+		return true
+	}
+	for _, suffix := range counterSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleStats merges the stats of all live backends, of the namespace
+// if one is given. Counters are summed, other numeric stats such as
+// limits are reported per backend as addr=value pairs, and the rest are
+// listed with their distinct values. A backend refusing the caller fails
+// the command, others failing are reported as down
+func (api *proxyAPI) handleStats(w http.ResponseWriter, r *http.Request) {
+	sums := make(map[string]int64)
+	perBackend := make(map[string][]string)
+	others := make(map[string]map[string]bool)
+	var mu sync.Mutex
+	var failed []string
+	var refused *backendFailure
+	api.eachBackend(func(addr string) {
+		var reply statsReply
+		f := api.fetchReply(r.Context(), addr, r.URL.EscapedPath(), &reply)
+		mu.Lock()
+		defer mu.Unlock()
+		if f != nil && (f.status == http.StatusUnauthorized || f.status == http.StatusForbidden) {
+			if refused == nil {
+				refused = f
+			}
+			return
+		}
+		if f != nil {
+			api.errorLog.Printf("stats from backend %s: %s", addr, f)
+			failed = append(failed, addr)
+			return
+		}
+		for name, val := range reply.Stats {
+			n, err := strconv.ParseInt(val, 10, 64)
+			switch {
+			case err == nil && isCounter(name):
+				sums[name] += n
+			case err == nil:
+				perBackend[name] = append(perBackend[name], addr+"="+val)
+			default:
+				if others[name] == nil {
+					others[name] = make(map[string]bool)
+				}
+				others[name][val] = true
+			}
+		}
+	})
+	if refused != nil {
+		api.passFailure(w, refused)
+		return
+	}
+	stats := make(map[string]string, len(sums)+len(perBackend)+len(others)+3)
+	for name, n := range sums {
+		stats[name] = strconv.FormatInt(n, 10)
+	}
+	for name, vals := range perBackend {
+		sort.Strings(vals)
+		stats[name] = strings.Join(vals, ",")
+	}
+	for name, vals := range others {
+		distinct := make([]string, 0, len(vals))
+		for val := range vals {
+			distinct = append(distinct, val)
+		}
+		sort.Strings(distinct)
+		stats[name] = strings.Join(distinct, ",")
+	}
+	var down []string
+	for _, addr := range api.router.backends() {
+		if api.router.isDead(addr) {
+			down = append(down, addr)
+		}
+	}
+	down = append(down, failed...)
+	sort.Strings(down)
+	stats["backends"] = strconv.Itoa(len(api.router.backends()))
+	stats["backends_down"] = strconv.Itoa(len(down))
+	stats["backends_down_addrs"] = strings.Join(down, ",")
+	jsonString, _ := json.Marshal(
+		statsReply{string(cache.OkReply), stats})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonString)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/pat"
+	"github.com/nagamocha3000/go-memcached/pkg/client"
+)
+
+// fakeBackend serves mget and stats from fixed values, or fails them
+// all with status if it's set
+type fakeBackend struct {
+	vals    map[string]string
+	replies map[string]string
+	stats   map[string]string
+	status  int
+
+	mu    sync.Mutex
+	paths []string
+}
+
+func (b *fakeBackend) handler() http.Handler {
+	mux := pat.New()
+	mget := func(w http.ResponseWriter, r *http.Request) {
+		if b.fail(w, r) {
+			return
+		}
+		vals := make(map[string]string)
+		for _, key := range r.URL.Query()["key"] {
+			if val, ok := b.vals[key]; ok {
+				vals[key] = val
+			}
+		}
+		json.NewEncoder(w).Encode(getMultiReply{"VALUE", vals, b.replies})
+	}
+	stats := func(w http.ResponseWriter, r *http.Request) {
+		if !b.fail(w, r) {
+			json.NewEncoder(w).Encode(statsReply{"OK", b.stats})
+		}
+	}
+	for _, prefix := range []string{"", "/ns/:ns"} {
+		mux.Get(prefix+"/mget", http.HandlerFunc(mget))
+		mux.Get(prefix+"/stats", http.HandlerFunc(stats))
+	}
+	return mux
+}
+
+// fail records the request and replies with the backend's status, if
+// it has one
+func (b *fakeBackend) fail(w http.ResponseWriter, r *http.Request) bool {
+	b.mu.Lock()
+	b.paths = append(b.paths, r.URL.Path)
+	b.mu.Unlock()
+	if b.status == 0 {
+		return false
+	}
+	w.WriteHeader(b.status)
+	json.NewEncoder(w).Encode(stdReply{http.StatusText(b.status)})
+	return true
+}
+
+// testProxy is a proxy in front of fake backends
+type testProxy struct {
+	*httptest.Server
+	backends []*httptest.Server
+}
+
+func (p *testProxy) close() {
+	p.Close()
+	for _, b := range p.backends {
+		b.Close()
+	}
+}
+
+// newTestProxy starts a proxy in front of the backends, routing keys
+// prefixed a: to the first and b: to the second
+func newTestProxy(backends ...*fakeBackend) *testProxy {
+	cfg := &config{timeout: time.Second}
+	p := &testProxy{}
+	for i, b := range backends {
+		srv := httptest.NewServer(b.handler())
+		p.backends = append(p.backends, srv)
+		cfg.backends = append(cfg.backends, client.Node{Addr: srv.URL, Weight: 1})
+		cfg.prefixRoutes = append(cfg.prefixRoutes, prefixRoute{prefix: string(rune('a'+i)) + ":", backend: srv.URL})
+	}
+	discard := log.New(ioutil.Discard, "", 0)
+	api := &proxyAPI{
+		errorLog:   discard,
+		infoLog:    discard,
+		router:     newRouter(cfg, http.DefaultTransport),
+		httpClient: &http.Client{Timeout: time.Second},
+	}
+	p.Server = httptest.NewServer(api.routes())
+	return p
+}
+
+func get(t *testing.T, url string, v interface{}) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestGetMulti(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		status     int
+		wantStatus int
+		wantReply  string
+	}{
+		{"all served", "/mget", 0, http.StatusOK, "VALUE"},
+		{"namespace", "/ns/sessions/mget", 0, http.StatusOK, "VALUE"},
+		{"forbidden", "/mget", http.StatusForbidden, http.StatusForbidden, "Forbidden"},
+		{"unauthorized", "/ns/sessions/mget", http.StatusUnauthorized, http.StatusUnauthorized, "Unauthorized"},
+		{"server error", "/mget", http.StatusInternalServerError, http.StatusInternalServerError, "Internal Server Error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &fakeBackend{vals: map[string]string{"a:1": "x"}, replies: map[string]string{"a:1": "REFRESH"}}
+			b := &fakeBackend{vals: map[string]string{"b:1": "y"}, status: tt.status}
+			proxy := newTestProxy(a, b)
+			defer proxy.close()
+			var reply getMultiReply
+			status := get(t, proxy.URL+tt.path+"?key=a:1&key=b:1&key=b:2", &reply)
+			if status != tt.wantStatus || reply.Reply != tt.wantReply {
+				t.Fatalf("got %d %q, want %d %q", status, reply.Reply, tt.wantStatus, tt.wantReply)
+			}
+			if tt.status == 0 && (len(reply.Vals) != 2 || reply.Vals["a:1"] != "x" || reply.Vals["b:1"] != "y" ||
+				len(reply.Replies) != 1 || reply.Replies["a:1"] != "REFRESH") {
+				t.Errorf("got vals %v, replies %v", reply.Vals, reply.Replies)
+			}
+			for _, backend := range []*fakeBackend{a, b} {
+				if len(backend.paths) != 1 || backend.paths[0] != tt.path {
+					t.Errorf("backend got %v, want %s", backend.paths, tt.path)
+				}
+			}
+		})
+	}
+}
+
+func TestGetMultiBackendDown(t *testing.T) {
+	proxy := newTestProxy(&fakeBackend{}, &fakeBackend{})
+	defer proxy.close()
+	proxy.backends[1].Close()
+	var reply stdReply
+	if status := get(t, proxy.URL+"/mget?key=a:1&key=b:1", &reply); status != http.StatusBadGateway || reply.Reply != "ERROR" {
+		t.Errorf("got %d %q, want %d ERROR", status, reply.Reply, http.StatusBadGateway)
+	}
+}
+
+func TestStats(t *testing.T) {
+	a := &fakeBackend{stats: map[string]string{
+		"cache_type": "lru", "capacity": "100", "max_item_size": "1048576",
+		"get_hits": "3", "curr_items": "2", "ns_s_get_hits": "1", "repl_seq": "7",
+	}}
+	b := &fakeBackend{stats: map[string]string{
+		"cache_type": "lfu", "capacity": "200", "max_item_size": "1048576",
+		"get_hits": "4", "curr_items": "5", "ns_s_get_hits": "2", "repl_seq": "9",
+	}}
+	proxy := newTestProxy(a, b)
+	defer proxy.close()
+	addrs := []string{proxy.backends[0].URL, proxy.backends[1].URL}
+	for _, path := range []string{"/stats", "/ns/s/stats"} {
+		var reply statsReply
+		if status := get(t, proxy.URL+path, &reply); status != http.StatusOK {
+			t.Fatalf("%s: got %d", path, status)
+		}
+		perBackend := func(a, b string) string {
+			vals := []string{addrs[0] + "=" + a, addrs[1] + "=" + b}
+			sort.Strings(vals)
+			return strings.Join(vals, ",")
+		}
+		want := map[string]string{
+			"get_hits":      "7",
+			"curr_items":    "7",
+			"ns_s_get_hits": "3",
+			"capacity":      perBackend("100", "200"),
+			"max_item_size": perBackend("1048576", "1048576"),
+			"repl_seq":      perBackend("7", "9"),
+			"cache_type":    "lfu,lru",
+			"backends":      "2",
+			"backends_down": "0",
+		}
+		for name, val := range want {
+			if reply.Stats[name] != val {
+				t.Errorf("%s: got %s=%q, want %q", path, name, reply.Stats[name], val)
+			}
+		}
+		if a.paths[len(a.paths)-1] != path {
+			t.Errorf("backend got %v, want %s", a.paths, path)
+		}
+	}
+
+	b.status = http.StatusInternalServerError
+	var reply statsReply
+	if status := get(t, proxy.URL+"/stats", &reply); status != http.StatusOK ||
+		reply.Stats["backends_down"] != "1" || reply.Stats["capacity"] != addrs[0]+"=100" {
+		t.Errorf("with a backend failing: got %d %v", status, reply.Stats)
+	}
+	b.status = http.StatusForbidden
+	if status := get(t, proxy.URL+"/stats", &reply); status != http.StatusForbidden {
+		t.Errorf("with a backend refusing: got %d, want 403", status)
+	}
+}
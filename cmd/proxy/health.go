@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// checkHealth pings every backend each interval, marking those that
+// don't answer as dead so their keys fail over, and alive again once
+// they do
+func (api *proxyAPI) checkHealth(interval time.Duration) {
+	for {
+		for _, addr := range api.router.backends() {
+			err := api.ping(addr, interval)
+			wasDead := api.router.isDead(addr)
+			if err != nil && !wasDead {
+				api.errorLog.Printf("backend %s is down: %s", addr, err)
+			} else if err == nil && wasDead {
+				api.infoLog.Printf("backend %s is back up", addr)
+			}
+			api.router.setDead(addr, err != nil)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (api *proxyAPI) ping(addr string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, status, err := api.fetch(ctx, addr, "/")
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("health check: %s", http.StatusText(status))
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+)
+
+func (api *proxyAPI) serverError(w http.ResponseWriter, err error) {
+	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
+	api.errorLog.Output(2, trace)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// backendError replies with an ERROR when no backend could serve the request
+func (api *proxyAPI) backendError(w http.ResponseWriter, err error) {
+	api.errorLog.Output(2, err.Error())
+	jsonString, _ := json.Marshal(
+		stdReply{string(cache.ErrReply)})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadGateway)
+	w.Write(jsonString)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nagamocha3000/go-memcached/pkg/auth"
+	"github.com/nagamocha3000/go-memcached/pkg/certs"
+	"github.com/nagamocha3000/go-memcached/pkg/conns"
+)
+
+type proxyAPI struct {
+	errorLog   *log.Logger
+	infoLog    *log.Logger
+	router     *router
+	httpClient *http.Client
+	auth       *auth.Authenticator
+}
+
+func main() {
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	cfg, err := getConfig()
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	var reloader *certs.Reloader
+	if cfg.tlsCert != "" {
+		reloader, err = certs.New(certs.Options{
+			CertFile:     cfg.tlsCert,
+			KeyFile:      cfg.tlsKey,
+			ClientCAFile: cfg.tlsClientCA,
+			ErrorLog:     errorLog,
+		})
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+	// backends serving TLS are given as https URLs, and get the proxy's
+	// certificate if they ask for one
+	transport := &http.Transport{
+		MaxIdleConnsPerHost: 64,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if reloader != nil {
+		transport.TLSClientConfig = reloader.ClientConfig()
+	}
+
+	rt := newRouter(cfg, transport)
+	api := &proxyAPI{
+		errorLog: errorLog,
+		infoLog:  infoLog,
+		router:   rt,
+		httpClient: &http.Client{
+			Timeout: cfg.timeout,
+			// callers' credentials only go to the backends given on the
+			// command line, discovered ones getting the proxy's
+			Transport: &auth.Transport{
+				Authorization: auth.Bearer(cfg.backendToken),
+				Base:          transport,
+				ForwardTo:     rt.isStatic,
+			},
+		},
+	}
+	if cfg.authFile != "" {
+		if api.auth, err = auth.LoadFile(cfg.authFile); err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Printf("authenticating requests with the credentials in %s", cfg.authFile)
+	} else if len(cfg.discover) > 0 && cfg.backendToken != "" {
+		errorLog.Printf("discovering backends without -authFile, callers get the privileges of -backendToken on them")
+	}
+	go api.checkHealth(cfg.healthInterval)
+	if len(cfg.discover) > 0 {
+		go api.discoverMembers(cfg.discover, cfg.healthInterval)
+	}
+
+	srv := &http.Server{
+		Addr:           cfg.addr,
+		ErrorLog:       errorLog,
+		Handler:        api.routes(),
+		ReadTimeout:    cfg.readTimeout,
+		WriteTimeout:   cfg.writeTimeout,
+		IdleTimeout:    cfg.idleTimeout,
+		MaxHeaderBytes: cfg.maxHeaderBytes,
+	}
+	ln, err := net.Listen("tcp", cfg.addr)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	ln = conns.New(cfg.maxConns).Listener(ln)
+	infoLog.Printf("starting proxy on %s for %s", cfg.addr, api.router.backends())
+	if reloader != nil {
+		srv.TLSConfig = reloader.ServerConfig()
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
+	errorLog.Fatal(err)
+}
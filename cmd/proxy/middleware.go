@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/justinas/alice"
+	"github.com/nagamocha3000/go-memcached/pkg/auth"
+)
+
+func secureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-XSS-Protection", "1;mode=block")
+		w.Header().Set("X-Frame-Options", "deny")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (api *proxyAPI) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (api *proxyAPI) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				w.Header().Set("Connection", "close")
+				api.serverError(w, fmt.Errorf("%s", err))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate rejects requests without valid credentials, if the proxy
+// has any
+func (api *proxyAPI) authenticate(next http.Handler) http.Handler {
+	if api.auth == nil {
+		return next
+	}
+	return api.auth.Middleware(next)
+}
+
+// authorize rejects requests the user's ACL doesn't allow, if the proxy
+// authenticates requests
+func (api *proxyAPI) authorize(class auth.Class) alice.Constructor {
+	if api.auth == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return api.auth.Require(class)
+}
+
+// forwardCredentials has the requests made to backends given on the
+// command line on the caller's behalf carry the caller's credentials
+// rather than the proxy's own, see router.isStatic
+func forwardCredentials(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(auth.Forward(r.Context(), r)))
+	})
+}
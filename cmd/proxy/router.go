@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nagamocha3000/go-memcached/pkg/client"
+)
+
+// router picks the backend for a key, by the longest matching prefix
+// route if any, else by consistent hashing. Prefix routes may name
+// backends outside the ring
+type router struct {
+	ring     *client.Ring
+	prefixes prefixRoutes
+	failover bool
+	opts     client.Options
+
+	// static are the hosts of the backends given on the command line,
+	// rather than discovered
+	static map[string]bool
+
+	mu         sync.Mutex
+	clients    map[string]*client.Client
+	dead       map[string]bool
+	discovered map[string]bool
+}
+
+func newRouter(cfg *config, transport http.RoundTripper) *router {
+	prefixes := append(prefixRoutes(nil), cfg.prefixRoutes...)
+	sort.SliceStable(prefixes, func(i, j int) bool {
+		return len(prefixes[i].prefix) > len(prefixes[j].prefix)
+	})
+	static := make(map[string]bool)
+	for _, n := range cfg.backends {
+		static[backendHost(n.Addr)] = true
+	}
+	for _, p := range prefixes {
+		static[backendHost(p.backend)] = true
+	}
+	return &router{
+		ring:       client.NewRing(cfg.failover, cfg.backends...),
+		prefixes:   prefixes,
+		failover:   cfg.failover,
+		opts:       client.Options{Timeout: cfg.timeout, Transport: transport, Token: cfg.backendToken},
+		static:     static,
+		clients:    make(map[string]*client.Client),
+		dead:       make(map[string]bool),
+		discovered: make(map[string]bool),
+	}
+}
+
+// backendHost returns the host and port of a backend's address, given
+// with or without a scheme
+func backendHost(addr string) string {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return addr
+	}
+	return u.Host
+}
+
+// isStatic reports whether the request goes to a backend given on the
+// command line. Only those are trusted with callers' credentials, as
+// discovered members are whatever gossip claims they are
+func (rt *router) isStatic(r *http.Request) bool {
+	return rt.static[r.URL.Host]
+}
+
+// setDiscovered adds and removes discovered members from the ring, the
+// static backends staying as they are
+func (rt *router) setDiscovered(addrs map[string]bool) (added, removed []string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	for addr := range addrs {
+		if !rt.discovered[addr] && !rt.static[backendHost(addr)] {
+			rt.discovered[addr] = true
+			rt.ring.Add(client.Node{Addr: addr, Weight: 1})
+			added = append(added, addr)
+		}
+	}
+	for addr := range rt.discovered {
+		if !addrs[addr] {
+			delete(rt.discovered, addr)
+			rt.ring.Remove(addr)
+			removed = append(removed, addr)
+		}
+	}
+	return added, removed
+}
+
+// backends returns the address of every backend, hashed or routed
+func (rt *router) backends() []string {
+	seen := make(map[string]bool)
+	var addrs []string
+	for _, n := range rt.ring.Nodes() {
+		seen[n.Addr] = true
+		addrs = append(addrs, n.Addr)
+	}
+	for _, p := range rt.prefixes {
+		if !seen[p.backend] {
+			seen[p.backend] = true
+			addrs = append(addrs, p.backend)
+		}
+	}
+	return addrs
+}
+
+func (rt *router) pick(key string) (string, error) {
+	for _, p := range rt.prefixes {
+		if strings.HasPrefix(key, p.prefix) {
+			if !rt.isDead(p.backend) || !rt.failover {
+				return p.backend, nil
+			}
+			break
+		}
+	}
+	return rt.ring.Pick(key)
+}
+
+func (rt *router) client(addr string) (*client.Client, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if c, ok := rt.clients[addr]; ok {
+		return c, nil
+	}
+	c, err := client.New(addr, rt.opts)
+	if err != nil {
+		return nil, err
+	}
+	rt.clients[addr] = c
+	return c, nil
+}
+
+func (rt *router) setDead(addr string, dead bool) {
+	rt.ring.SetDead(addr, dead)
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.dead[addr] = dead
+}
+
+func (rt *router) isDead(addr string) bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.dead[addr]
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bmizerany/pat"
+	"github.com/justinas/alice"
+	"github.com/nagamocha3000/go-memcached/pkg/auth"
+)
+
+func (api *proxyAPI) routes() http.Handler {
+	middleware := alice.New(api.recoverPanic, api.logRequest, secureHeaders, forwardCredentials)
+	mux := pat.New()
+	mux.Get("/", http.HandlerFunc(api.home))
+	// callers are authenticated and their ACLs checked here too, as
+	// discovered backends only see the proxy's credentials
+	authed := func(class auth.Class) alice.Chain {
+		return alice.New(api.authenticate, api.authorize(class))
+	}
+	read, write := authed(auth.ClassRead), authed(auth.ClassWrite)
+	for _, cmd := range []string{"set", "add", "replace", "append", "prepend",
+		"increment", "decrement", "cas", "get", "gets", "touch", "delete", "lget", "lset"} {
+		chain := write
+		if cmd == "get" || cmd == "gets" || cmd == "lget" {
+			chain = read
+		}
+		mux.Get("/"+cmd+"/:key", chain.ThenFunc(api.handleForward))
+		// keys are placed the same whatever their namespace
+		mux.Get("/ns/:ns/"+cmd+"/:key", chain.ThenFunc(api.handleForward))
+	}
+	for _, prefix := range []string{"", "/ns/:ns"} {
+		mux.Get(prefix+"/mget", read.ThenFunc(api.handleGetMulti))
+		mux.Get(prefix+"/clear", authed(auth.ClassFlush).ThenFunc(api.handleClear))
+		mux.Get(prefix+"/stats", authed(auth.ClassStats).ThenFunc(api.handleStats))
+	}
+	mux.Get("/admin/migrate", authed(auth.ClassAdmin).ThenFunc(api.handleBroadcast))
+	return middleware.Then(mux)
+}
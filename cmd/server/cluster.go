@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	"github.com/nagamocha3000/go-memcached/pkg/certs"
+	"github.com/nagamocha3000/go-memcached/pkg/client"
+	"github.com/nagamocha3000/go-memcached/pkg/group"
+	"github.com/nagamocha3000/go-memcached/pkg/membership"
+)
+
+// advertisedAPIAddr is the address other servers and clients reach this
+// one on, as an https URL if it serves TLS
+func advertisedAPIAddr(cfg *config) string {
+	addr := cfg.apiAddr
+	if addr == "" {
+		addr = cfg.addr
+		if host, port, err := net.SplitHostPort(cfg.addr); err == nil && host == "" {
+			host, _ = os.Hostname()
+			addr = net.JoinHostPort(host, port)
+		}
+	}
+	if cfg.tlsCert != "" && !strings.Contains(addr, "://") {
+		addr = "https://" + addr
+	}
+	return addr
+}
+
+// tlsTransport returns the transport for requests to other servers,
+// presenting this server's certificate, or nil if it doesn't serve TLS
+func tlsTransport(reloader *certs.Reloader) http.RoundTripper {
+	if reloader == nil {
+		return nil
+	}
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     reloader.ClientConfig(),
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+	}
+}
+
+// gossipKey returns the key gossip is signed with, nil for none
+func gossipKey(cfg *config) []byte {
+	if cfg.gossipKey == "" {
+		return nil
+	}
+	return []byte(cfg.gossipKey)
+}
+
+// startMembership joins the cluster, advertising the address clients
+// should use to reach this server
+func startMembership(cfg *config, errorLog *log.Logger) (*membership.Memberlist, error) {
+	members, err := membership.New(membership.Config{
+		Name:          cfg.nodeName,
+		BindAddr:      cfg.gossipAddr,
+		AdvertiseAddr: cfg.advertiseAddr,
+		APIAddr:       advertisedAPIAddr(cfg),
+		ErrorLog:      errorLog,
+		SecretKey:     gossipKey(cfg),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.gossipKey == "" {
+		errorLog.Printf("gossiping without -gossipKey, anyone reaching %s can join the cluster", cfg.gossipAddr)
+	}
+	go members.Run()
+	if cfg.join != "" {
+		if err := members.Join(strings.Split(cfg.join, ",")); err != nil {
+			errorLog.Printf("joining cluster: %s", err)
+		}
+	}
+	return members, nil
+}
+
+// startGroup fills misses with load at the keys' owners, spreading keys
+// across the -peers, or the cluster's live members in cluster mode
+func startGroup(cfg *config, c *cache.Adapter, load group.Loader, members *membership.Memberlist,
+	transport http.RoundTripper) (*group.Group, error) {
+	g, err := group.New(advertisedAPIAddr(cfg), c, load, group.Options{
+		HotCapacity: cfg.hotCapacity,
+		Client: client.Options{
+			Timeout:   5 * time.Second,
+			Transport: transport,
+			Token:     cfg.authToken,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var peers []string
+	for _, addr := range strings.Split(cfg.peers, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			// peers are named as they name themselves, so they agree on
+			// who owns what
+			if cfg.tlsCert != "" && !strings.Contains(addr, "://") {
+				addr = "https://" + addr
+			}
+			peers = append(peers, addr)
+		}
+	}
+	g.SetPeers(peers...)
+	if members != nil {
+		go func() {
+			for {
+				live := append([]string(nil), peers...)
+				for _, m := range members.Members() {
+					if m.IsLive() && m.APIAddr != "" {
+						live = append(live, m.APIAddr)
+					}
+				}
+				g.SetPeers(live...)
+				time.Sleep(time.Second)
+			}
+		}()
+	}
+	return g, nil
+}
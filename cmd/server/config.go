@@ -1,19 +1,232 @@
 package main
 
-import "flag"
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nagamocha3000/go-memcached/pkg/auth"
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	"github.com/nagamocha3000/go-memcached/pkg/namespace"
+	"github.com/nagamocha3000/go-memcached/pkg/ratelimit"
+)
 
 type config struct {
 	addr          string
 	cacheType     string
 	cacheCapacity int
+	cacheOptions  string
+	replicate     bool
+	replLogSize   int
+	replicaOf     string
+	gossipAddr    string
+	advertiseAddr string
+	apiAddr       string
+	nodeName      string
+	join          string
+	gossipKey     string
+	loaders       loaderRoutes
+	peers         string
+	hotCapacity   int
+
+	storePath           string
+	storeMode           string
+	storeReadThrough    bool
+	writeBehindQueue    int
+	writeBehindBatch    int
+	writeBehindInterval time.Duration
+
+	leaseTTL      time.Duration
+	leaseStaleTTL time.Duration
+
+	grace               time.Duration
+	graceRefreshTimeout time.Duration
+
+	authFile  string
+	authToken string
+
+	tlsCert     string
+	tlsKey      string
+	tlsClientCA string
+
+	namespaces      namespaces
+	namespaceBudget int
+
+	rateLimits rateLimits
+
+	maxConns       int
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	idleTimeout    time.Duration
+	maxHeaderBytes int
+
+	maxKeyLength int
+	maxItemSize  int
+
+	logLevel  string
+	logFormat string
+	logKeys   string
+}
+
+// rateLimits collects the repeated -rateLimit flag
+type rateLimits map[auth.Class]ratelimit.Limit
+
+func (l rateLimits) String() string {
+	limits := make([]string, 0, len(l))
+	for class, limit := range l {
+		limits = append(limits, fmt.Sprintf("%s=%g:%d", class, limit.Rate, limit.Burst))
+	}
+	sort.Strings(limits)
+	return strings.Join(limits, ",")
+}
+
+func (l rateLimits) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return fmt.Errorf("invalid rate limit %q, want class=rate[:burst]", s)
+	}
+	class, err := auth.ParseClass(s[:i])
+	if err != nil {
+		return err
+	}
+	limit, err := ratelimit.ParseLimit(s[i+1:])
+	if err != nil {
+		return err
+	}
+	l[class] = limit
+	return nil
+}
+
+// namespaces collects the repeated -namespace flag
+type namespaces []namespace.Config
+
+func (n *namespaces) String() string {
+	names := make([]string, len(*n))
+	for i, ns := range *n {
+		names[i] = ns.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func (n *namespaces) Set(s string) error {
+	ns, err := namespace.Parse(s)
+	if err != nil {
+		return err
+	}
+	*n = append(*n, ns)
+	return nil
+}
+
+// loaderRoutes collects the repeated -loader flag
+type loaderRoutes []loaderRoute
+
+type loaderRoute struct {
+	prefix string
+	url    string
+}
+
+func (l *loaderRoutes) String() string {
+	routes := make([]string, len(*l))
+	for i, route := range *l {
+		routes[i] = route.prefix + "=" + route.url
+	}
+	return strings.Join(routes, ",")
+}
+
+func (l *loaderRoutes) Set(s string) error {
+	route := loaderRoute{url: s}
+	// a prefix can't contain "://", so the = of a query string isn't
+	// mistaken for one
+	if i := strings.Index(s, "="); i >= 0 && !strings.Contains(s[:i], "://") {
+		route = loaderRoute{prefix: s[:i], url: s[i+1:]}
+	}
+	if !strings.Contains(route.url, "://") {
+		return fmt.Errorf("invalid loader %q, want url or prefix=url", s)
+	}
+	*l = append(*l, route)
+	return nil
 }
 
 func getConfig() *config {
-	cfg := config{}
+	cfg := config{rateLimits: rateLimits{}}
 	//cfg := new(config)
 	flag.StringVar(&cfg.addr, "addr", ":4000", "http network address")
-	flag.StringVar(&cfg.cacheType, "cacheType", "lfu", "underlying cache type: [lfu, lru, lfu-lru-t]")
+	flag.StringVar(&cfg.cacheType, "cacheType", "lfu",
+		fmt.Sprintf("underlying cache type: [%s]", strings.Join(cache.CacheTypes(), ", ")))
 	flag.IntVar(&cfg.cacheCapacity, "cacheCapacity", 100, "cache capacity")
+	flag.StringVar(&cfg.cacheOptions, "cacheOptions", "",
+		"comma separated name=value cache policy options, e.g. maxFrequency=16,decayInterval=10000 for lfu or maxBytes=1048576 for gdsf")
+	flag.BoolVar(&cfg.replicate, "replicate", false, "serve snapshots and the mutation stream to replicas")
+	flag.IntVar(&cfg.replLogSize, "replLogSize", 100000,
+		"number of recent mutations kept for replicas to catch up from")
+	flag.StringVar(&cfg.replicaOf, "replicaOf", "",
+		"address of the primary to replicate from, e.g. http://primary:4000. Makes this server read-only")
+	flag.StringVar(&cfg.gossipAddr, "gossipAddr", "",
+		"UDP address to gossip cluster membership on, e.g. :7946. Cluster mode is off if empty")
+	flag.StringVar(&cfg.advertiseAddr, "gossipAdvertise", "", "gossip address advertised to other nodes, defaults to -gossipAddr")
+	flag.StringVar(&cfg.apiAddr, "apiAddr", "", "http address advertised to clients, defaults to -addr")
+	flag.StringVar(&cfg.nodeName, "nodeName", "", "unique name of the node in the cluster, defaults to its gossip address")
+	flag.StringVar(&cfg.join, "join", "", "comma separated gossip addresses of nodes of the cluster to join")
+	flag.StringVar(&cfg.gossipKey, "gossipKey", "",
+		"secret shared by the nodes of the cluster that gossip packets are signed with, which is how the gossip listener "+
+			"authenticates them, as it's neither covered by -authFile nor TLS. Signed packets older than 30s are dropped, so the nodes' clocks must agree within that. "+
+			"Unsigned gossip is accepted if empty")
+	flag.Var(&cfg.loaders, "loader",
+		"URL that misses on /get are loaded from, with the key appended, as url or prefix=url for keys with a prefix. Can be repeated")
+	flag.StringVar(&cfg.peers, "peers", "", "comma separated http addresses of the other servers sharing the keys loaded")
+	flag.IntVar(&cfg.hotCapacity, "hotCapacity", 0, "number of values owned by other peers kept locally")
+	flag.StringVar(&cfg.storePath, "store", "", "file of the embedded backing store writes are persisted to. No store if empty")
+	flag.StringVar(&cfg.storeMode, "storeMode", "behind",
+		"when writes are persisted: through, before they're acknowledged, or behind, queued and batched")
+	flag.BoolVar(&cfg.storeReadThrough, "storeReadThrough", false, "load misses on /get from the store")
+	flag.IntVar(&cfg.writeBehindQueue, "writeBehindQueue", 10000, "writes that may wait to be persisted before new ones are refused")
+	flag.IntVar(&cfg.writeBehindBatch, "writeBehindBatch", 100, "most writes persisted at once")
+	flag.DurationVar(&cfg.writeBehindInterval, "writeBehindInterval", 100*time.Millisecond,
+		"how long writes may wait for a batch to fill")
+	flag.DurationVar(&cfg.leaseTTL, "leaseTTL", 0, "how long a lease from /lget is held, 10s if zero")
+	flag.DurationVar(&cfg.leaseStaleTTL, "leaseStaleTTL", 0,
+		"how long deleted values are served stale from /lget, 10s if zero, negative for never")
+	flag.DurationVar(&cfg.grace, "grace", 0,
+		"how long values are served stale past their TTL while one client refreshes them. Zero to disable")
+	flag.DurationVar(&cfg.graceRefreshTimeout, "graceRefreshTimeout", 10*time.Second,
+		"how long the client told to refresh a stale value has before another one is told to")
+	flag.StringVar(&cfg.authFile, "authFile", "",
+		"JSON file of the users allowed to make requests, and their passwords and tokens. No authentication if empty. "+
+			"The gossip listener doesn't take users' credentials, see -gossipKey")
+	flag.StringVar(&cfg.authToken, "authToken", "",
+		"bearer token this server presents to the servers it talks to, its primary and peers")
+	flag.StringVar(&cfg.tlsCert, "tlsCert", "",
+		"PEM certificate to serve TLS with, reloaded when changed. Plain http if empty. Gossip isn't TLS, see -gossipKey")
+	flag.StringVar(&cfg.tlsKey, "tlsKey", "", "PEM private key of -tlsCert")
+	flag.StringVar(&cfg.tlsClientCA, "tlsClientCA", "",
+		"PEM CAs client certificates are verified against. Clients must then present one, whose common name is their identity")
+	flag.Var(&cfg.namespaces, "namespace",
+		"a cache of its own served under /ns/<name>/, as name followed by comma separated type, capacity, ttl, "+
+			"reserved and max bytes and policy options, e.g. sessions,type=lru,capacity=1000,ttl=30m,max=1048576. Can be repeated")
+	flag.IntVar(&cfg.namespaceBudget, "namespaceBudget", 0,
+		"bytes of keys and values all namespaces may hold together, evicting from those over their reservation first. Zero for no limit")
+	flag.Var(cfg.rateLimits, "rateLimit",
+		"requests per second, and optionally how many at once, each client may make of a class of commands, as class=rate[:burst], "+
+			"e.g. write=100:200. Clients are told apart by user, else address. Can be repeated")
+	flag.IntVar(&cfg.maxConns, "maxConns", 0,
+		"connections served at once, new ones waiting to be accepted past it. Zero for no limit")
+	flag.DurationVar(&cfg.readTimeout, "readTimeout", 10*time.Second, "how long a client has to send a request. Zero for no limit")
+	flag.DurationVar(&cfg.writeTimeout, "writeTimeout", 0,
+		"how long a response may take from the end of its request. Zero for no limit, which replication streams need")
+	flag.DurationVar(&cfg.idleTimeout, "idleTimeout", 2*time.Minute,
+		"how long an idle connection is kept open between requests. Zero to use -readTimeout")
+	flag.IntVar(&cfg.maxHeaderBytes, "maxHeaderBytes", http.DefaultMaxHeaderBytes, "largest request line and headers read")
+	flag.IntVar(&cfg.maxKeyLength, "maxKeyLength", cache.DefaultMaxKeyLength, "longest key in bytes. Negative for no limit")
+	flag.IntVar(&cfg.maxItemSize, "maxItemSize", cache.DefaultMaxItemSize,
+		"largest key and value together in bytes. Negative for no limit")
+	flag.StringVar(&cfg.logLevel, "logLevel", "info", "least severe entries logged: debug, info, warn or error. Can be changed at /admin/logging")
+	flag.StringVar(&cfg.logFormat, "logFormat", "logfmt", "log entries as logfmt or json lines")
+	flag.StringVar(&cfg.logKeys, "logKeys", "plain",
+		"how keys are logged: plain, hash, for a hash telling them apart logged as key_hash, or redact. "+
+			"Plain keys are needed to replay the log with cmd/simulate. Can be changed at /admin/logging")
 	flag.Parse()
 	return &cfg
 }
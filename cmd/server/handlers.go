@@ -1,8 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	"github.com/nagamocha3000/go-memcached/pkg/conns"
+	"github.com/nagamocha3000/go-memcached/pkg/loader"
+	"github.com/nagamocha3000/go-memcached/pkg/logging"
 )
 
 type stdReply struct {
@@ -14,12 +22,43 @@ type getReply struct {
 	Val   string `json:"val"`
 }
 
+type getMultiReply struct {
+	Reply string            `json:"reply"`
+	Vals  map[string]string `json:"vals"`
+	// Replies has the STALE or REFRESH reply of the values past their
+	// TTL, the others being fresh
+	Replies map[string]string `json:"replies,omitempty"`
+}
+
+type statsReply struct {
+	Reply string            `json:"reply"`
+	Stats map[string]string `json:"stats"`
+}
+
+type connsReply struct {
+	Reply string       `json:"reply"`
+	Conns []conns.Conn `json:"conns"`
+}
+
+type loggingReply struct {
+	Reply string `json:"reply"`
+	Level string `json:"level"`
+	Keys  string `json:"keys"`
+}
+
 type getReplyToken struct {
 	Reply string `json:"reply"`
 	Val   string `json:"val"`
 	Token string `json:"token"`
 }
 
+type leaseReply struct {
+	Reply string `json:"reply"`
+	Val   string `json:"val"`
+	Token string `json:"token,omitempty"`
+	Stale bool   `json:"stale,omitempty"`
+}
+
 func (api *httpAPI) home(w http.ResponseWriter, r *http.Request) {
 	reply := "Hello go-memcached"
 	jsonString, _ := json.Marshal(
@@ -38,9 +77,26 @@ func getStdParams(r *http.Request) (string, string, string) {
 	return key, val, exptimeStr
 }
 
+func getHints(r *http.Request) (*cache.Hints, bool) {
+	return cache.ParseHints(r.URL.Query().Get("cost"), r.URL.Query().Get("priority"))
+}
+
+func (api *httpAPI) clientErrorReply(w http.ResponseWriter) {
+	jsonString, _ := json.Marshal(
+		stdReply{string(cache.ClientErrorReply)})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonString)
+}
+
 func (api *httpAPI) handleSet(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
 	key, val, exptimeStr := getStdParams(r)
-	reply := api.cache.Set(key, val, exptimeStr)
+	hints, ok := getHints(r)
+	if !ok {
+		api.clientErrorReply(w)
+		return
+	}
+	reply := c.Set(key, val, exptimeStr, hints)
 	jsonString, _ := json.Marshal(
 		stdReply{string(reply)})
 	w.Header().Set("Content-Type", "application/json")
@@ -48,8 +104,14 @@ func (api *httpAPI) handleSet(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *httpAPI) handleAdd(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
 	key, val, exptimeStr := getStdParams(r)
-	reply := api.cache.Add(key, val, exptimeStr)
+	hints, ok := getHints(r)
+	if !ok {
+		api.clientErrorReply(w)
+		return
+	}
+	reply := c.Add(key, val, exptimeStr, hints)
 	//return only reply
 	jsonString, _ := json.Marshal(
 		stdReply{string(reply)})
@@ -58,8 +120,14 @@ func (api *httpAPI) handleAdd(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *httpAPI) handleReplace(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
 	key, val, exptimeStr := getStdParams(r)
-	reply := api.cache.Replace(key, val, exptimeStr)
+	hints, ok := getHints(r)
+	if !ok {
+		api.clientErrorReply(w)
+		return
+	}
+	reply := c.Replace(key, val, exptimeStr, hints)
 	//return only reply
 	jsonString, _ := json.Marshal(
 		stdReply{string(reply)})
@@ -68,8 +136,9 @@ func (api *httpAPI) handleReplace(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *httpAPI) handleAppend(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
 	key, val, exptimeStr := getStdParams(r)
-	reply := api.cache.Append(key, val, exptimeStr)
+	reply := c.Append(key, val, exptimeStr)
 	//return only reply
 	jsonString, _ := json.Marshal(
 		stdReply{string(reply)})
@@ -78,8 +147,9 @@ func (api *httpAPI) handleAppend(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *httpAPI) handlePrepend(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
 	key, val, exptimeStr := getStdParams(r)
-	reply := api.cache.Prepend(key, val, exptimeStr)
+	reply := c.Prepend(key, val, exptimeStr)
 	//return only reply
 	jsonString, _ := json.Marshal(
 		stdReply{string(reply)})
@@ -88,31 +158,35 @@ func (api *httpAPI) handlePrepend(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *httpAPI) handleIncrement(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
 	key := r.URL.Query().Get(":key")
 	numStr := r.URL.Query().Get("num")
 
-	reply := api.cache.Increment(key, numStr)
-	//return only reply
+	reply, val := c.Increment(key, numStr)
+	//return reply & new val
 	jsonString, _ := json.Marshal(
-		stdReply{string(reply)})
+		getReply{string(reply), val})
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonString)
 }
 
 func (api *httpAPI) handleDecrement(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
 	key := r.URL.Query().Get(":key")
 	numStr := r.URL.Query().Get("num")
-	reply := api.cache.Decrement(key, numStr)
-	//return only reply
+	reply, val := c.Decrement(key, numStr)
+	//return reply & new val
 	jsonString, _ := json.Marshal(
-		stdReply{string(reply)})
+		getReply{string(reply), val})
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonString)
 }
 
 func (api *httpAPI) handleCompareAndSwap(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
 	key, val, exptimeStr := getStdParams(r)
-	reply := api.cache.CompareAndSwap(key, val, exptimeStr, "")
+	token := cache.Token(r.URL.Query().Get("token"))
+	reply := c.CompareAndSwap(key, val, exptimeStr, token)
 	//return only reply
 	jsonString, _ := json.Marshal(
 		stdReply{string(reply)})
@@ -121,8 +195,20 @@ func (api *httpAPI) handleCompareAndSwap(w http.ResponseWriter, r *http.Request)
 }
 
 func (api *httpAPI) handleGet(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
 	key := r.URL.Query().Get(":key")
-	reply, val := api.cache.Get(key)
+	var reply cache.Reply
+	var val string
+	// loaders only fill the default namespace, and never invalid keys
+	if err := c.CheckKey(key); err != nil {
+		reply = cache.ClientError(err.Error())
+	} else if api.group != nil && c == api.cache {
+		reply, val = api.loadingGet(r.Context(), key, api.group.Get)
+	} else if api.readThrough != nil && c == api.cache {
+		reply, val = api.loadingGet(r.Context(), key, api.readThrough.Get)
+	} else {
+		reply, val = c.Get(key)
+	}
 	//return reply & val
 
 	jsonString, _ := json.Marshal(
@@ -131,9 +217,55 @@ func (api *httpAPI) handleGet(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonString)
 }
 
+// loadingGet replies with the value got, filling misses with a loader
+func (api *httpAPI) loadingGet(ctx context.Context, key string,
+	get func(context.Context, string) (string, error)) (cache.Reply, string) {
+	val, err := get(ctx, key)
+	switch {
+	case errors.Is(err, loader.ErrNotFound):
+		return cache.NotFoundReply, ""
+	case err != nil:
+		id, _ := logging.RequestID(ctx)
+		fields := append([]interface{}{"id", id}, api.log.KeyFields(key)...)
+		api.log.Error("loading failed", append(fields, "err", err)...)
+		return cache.ServerError("loading failed"), ""
+	}
+	return cache.ValueReply, val
+}
+
+func (api *httpAPI) handleGetMulti(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
+	keys := r.URL.Query()["key"]
+	reply, vals, stale := c.GetMulti(keys)
+	var staleReplies map[string]string
+	if len(stale) > 0 {
+		staleReplies = make(map[string]string, len(stale))
+		for key, reply := range stale {
+			staleReplies[key] = string(reply)
+		}
+	}
+	//return reply & vals of keys found
+	jsonString, _ := json.Marshal(
+		getMultiReply{string(reply), vals, staleReplies})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonString)
+}
+
+func (api *httpAPI) handleTouch(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
+	key, _, exptimeStr := getStdParams(r)
+	reply := c.Touch(key, exptimeStr)
+	//return reply
+	jsonString, _ := json.Marshal(
+		stdReply{string(reply)})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonString)
+}
+
 func (api *httpAPI) handleGetEntryPlusToken(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
 	key := r.URL.Query().Get(":key")
-	reply, val, token := api.cache.GetEntryPlusToken(key)
+	reply, val, token := c.GetEntryPlusToken(key)
 	//return reply & val & token
 	jsonString, _ := json.Marshal(
 		getReplyToken{string(reply), val, string(token)})
@@ -141,9 +273,36 @@ func (api *httpAPI) handleGetEntryPlusToken(w http.ResponseWriter, r *http.Reque
 	w.Write(jsonString)
 }
 
+func (api *httpAPI) handleGetWithLease(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
+	key := r.URL.Query().Get(":key")
+	reply, val, token, stale := c.GetWithLease(key)
+	jsonString, _ := json.Marshal(
+		leaseReply{string(reply), val, string(token), stale})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonString)
+}
+
+func (api *httpAPI) handleSetWithLease(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
+	key, val, exptimeStr := getStdParams(r)
+	token := cache.Token(r.URL.Query().Get("token"))
+	hints, ok := getHints(r)
+	if !ok {
+		api.clientErrorReply(w)
+		return
+	}
+	reply := c.SetWithLease(key, val, exptimeStr, token, hints)
+	jsonString, _ := json.Marshal(
+		stdReply{string(reply)})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonString)
+}
+
 func (api *httpAPI) handleDelete(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
 	key := r.URL.Query().Get(":key")
-	reply := api.cache.Delete(key)
+	reply := c.Delete(key)
 	//return reply
 	jsonString, _ := json.Marshal(
 		stdReply{string(reply)})
@@ -152,7 +311,8 @@ func (api *httpAPI) handleDelete(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *httpAPI) handleClear(w http.ResponseWriter, r *http.Request) {
-	reply := api.cache.Clear()
+	c := api.cacheFor(r)
+	reply := c.Clear()
 	//return reply
 	jsonString, _ := json.Marshal(
 		stdReply{string(reply)})
@@ -161,7 +321,79 @@ func (api *httpAPI) handleClear(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *httpAPI) handleStats(w http.ResponseWriter, r *http.Request) {
-	reply := api.cache.Stats()
+	c := api.cacheFor(r)
+	reply, stats := c.Stats()
+	//return reply & stats
+	jsonString, _ := json.Marshal(
+		statsReply{string(reply), stats})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonString)
+}
+
+func (api *httpAPI) handleStatsConns(w http.ResponseWriter, r *http.Request) {
+	jsonString, _ := json.Marshal(
+		connsReply{cache.OkReply, api.conns.Conns()})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonString)
+}
+
+// handleLogging reports the log level and key mode, changing them to the
+// level and keys parameters if given
+func (api *httpAPI) handleLogging(w http.ResponseWriter, r *http.Request) {
+	var reply cache.Reply = cache.OkReply
+	query := r.URL.Query()
+	level, keys := api.log.Level(), api.log.KeyMode()
+	var err error
+	if s := query.Get("level"); s != "" {
+		level, err = logging.ParseLevel(s)
+	}
+	if s := query.Get("keys"); s != "" && err == nil {
+		keys, err = logging.ParseKeyMode(s)
+	}
+	switch {
+	case err != nil:
+		reply = cache.ClientError(err.Error())
+	case level != api.log.Level() || keys != api.log.KeyMode():
+		api.log.SetLevel(level)
+		api.log.SetKeyMode(keys)
+		api.log.Warn("logging changed", "log_level", level, "log_keys", keys)
+	}
+	jsonString, _ := json.Marshal(
+		loggingReply{string(reply), api.log.Level().String(), api.log.KeyMode().String()})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonString)
+}
+
+func (api *httpAPI) handleMigrate(w http.ResponseWriter, r *http.Request) {
+	c := api.cacheFor(r)
+	cacheType := r.URL.Query().Get("type")
+	capacityStr := r.URL.Query().Get("capacity")
+	// the capacity is kept unless given, 0 being unlimited
+	capacity := cache.KeepCapacity
+	var opts cache.Options
+	var reply cache.Reply = cache.OkReply
+	if capacityStr != "" {
+		var err error
+		capacity, err = strconv.Atoi(capacityStr)
+		if err != nil || capacity < 0 {
+			reply = cache.ClientError("invalid capacity")
+		}
+	}
+	// options are kept as they are unless given
+	if _, ok := r.URL.Query()["options"]; ok {
+		var err error
+		opts, err = cache.ParseOptions(r.URL.Query().Get("options"))
+		if err != nil {
+			reply = cache.ClientError(err.Error())
+		}
+	}
+	if reply == cache.OkReply {
+		if err := c.Migrate(cacheType, capacity, opts); err != nil {
+			reply = cache.ClientError(err.Error())
+		} else {
+			api.infoLog.Printf("migrated cache to %s", cacheType)
+		}
+	}
 	//return reply
 	jsonString, _ := json.Marshal(
 		stdReply{string(reply)})
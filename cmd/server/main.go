@@ -1,38 +1,227 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 
+	"github.com/nagamocha3000/go-memcached/pkg/auth"
 	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	_ "github.com/nagamocha3000/go-memcached/pkg/cache/policies"
+	"github.com/nagamocha3000/go-memcached/pkg/certs"
+	"github.com/nagamocha3000/go-memcached/pkg/conns"
+	"github.com/nagamocha3000/go-memcached/pkg/group"
+	"github.com/nagamocha3000/go-memcached/pkg/loader"
+	"github.com/nagamocha3000/go-memcached/pkg/logging"
+	"github.com/nagamocha3000/go-memcached/pkg/membership"
+	"github.com/nagamocha3000/go-memcached/pkg/namespace"
+	"github.com/nagamocha3000/go-memcached/pkg/ratelimit"
+	"github.com/nagamocha3000/go-memcached/pkg/replication"
+	"github.com/nagamocha3000/go-memcached/pkg/store"
 )
 
 type httpAPI struct {
+	log      *logging.Logger
 	errorLog *log.Logger
 	infoLog  *log.Logger
-	cache    cache.Adapter
+	cache    *cache.Adapter
+	primary  *replication.Primary
+	replica  *replication.Replica
+	members  *membership.Memberlist
+	group    *group.Group
+	auth     *auth.Authenticator
+	certs    *certs.Reloader
+	conns    *conns.Tracker
+
+	namespaces *namespace.Namespaces
+	limiters   map[auth.Class]*ratelimit.Limiter
+
+	readThrough *loader.ReadThrough
 }
 
 func main() {
 
 	cfg := getConfig()
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	logger, err := newLogger(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	infoLog := logger.StdLogger(logging.Info, 0)
+	errorLog := logger.StdLogger(logging.Error, log.Lshortfile)
+
+	opts, err := cache.ParseOptions(cfg.cacheOptions)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	c, err := cache.NewCache(cfg.cacheType, cfg.cacheCapacity, opts)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	limits := cache.Limits{MaxKeyLength: cfg.maxKeyLength, MaxItemSize: cfg.maxItemSize}
+	c.SetLimits(limits)
+
+	if cfg.leaseTTL != 0 || cfg.leaseStaleTTL != 0 {
+		c.SetLeaseOptions(cache.LeaseOptions{TTL: cfg.leaseTTL, StaleTTL: cfg.leaseStaleTTL})
+	}
+
+	if cfg.grace > 0 {
+		c.SetGraceOptions(cache.GraceOptions{Period: cfg.grace, RefreshTimeout: cfg.graceRefreshTimeout})
+	}
 
 	api := &httpAPI{
+		log:      logger,
 		errorLog: errorLog,
 		infoLog:  infoLog,
-		cache:    cache.NewCache(cfg.cacheType, cfg.cacheCapacity),
+		cache:    c,
+		conns:    conns.New(cfg.maxConns),
+	}
+	c.AddStats(api.conns.Stats)
+
+	if len(cfg.namespaces) > 0 {
+		api.namespaces, err = namespace.New(cfg.namespaces, cfg.namespaceBudget)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		for _, name := range api.namespaces.Names() {
+			ns, _ := api.namespaces.Get(name)
+			ns.SetLimits(limits)
+		}
+		c.AddStats(api.namespaces.Stats)
+		infoLog.Printf("serving namespaces %s", cfg.namespaces.String())
+	}
+
+	if cfg.authFile != "" {
+		api.auth, err = auth.LoadFile(cfg.authFile)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		c.AddStats(api.auth.Stats)
+		infoLog.Printf("authenticating requests with the credentials in %s", cfg.authFile)
+	}
+
+	if len(cfg.rateLimits) > 0 {
+		api.limiters = make(map[auth.Class]*ratelimit.Limiter)
+		for class, limit := range cfg.rateLimits {
+			api.limiters[class] = ratelimit.New(limit)
+		}
+		c.AddStats(api.rateLimitStats)
+		infoLog.Printf("rate limiting %s", cfg.rateLimits.String())
+	}
+
+	if cfg.tlsCert != "" {
+		api.certs, err = certs.New(certs.Options{
+			CertFile:     cfg.tlsCert,
+			KeyFile:      cfg.tlsKey,
+			ClientCAFile: cfg.tlsClientCA,
+			ErrorLog:     errorLog,
+		})
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		c.AddStats(api.certs.Stats)
+	}
+	transport := tlsTransport(api.certs)
+
+	if cfg.replicate {
+		api.primary = replication.NewPrimary(c, cfg.replLogSize)
+	}
+	if cfg.replicaOf != "" {
+		api.replica = replication.NewReplica(c, cfg.replicaOf, replicaID(cfg.addr), errorLog)
+		if cfg.authToken != "" || transport != nil {
+			api.replica.SetTransport(&auth.Transport{Authorization: auth.Bearer(cfg.authToken), Base: transport})
+		}
+		go api.replica.Run(context.Background())
+		infoLog.Printf("replicating from %s", cfg.replicaOf)
+	}
+
+	if cfg.gossipAddr != "" {
+		api.members, err = startMembership(cfg, errorLog)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		c.AddStats(api.members.Stats)
+		infoLog.Printf("gossiping on %s as %s", cfg.gossipAddr, api.members.LocalNode().Name)
+	}
+	var backingStore store.Store
+	if cfg.storePath != "" {
+		backingStore, err = startStore(cfg, c, errorLog)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Printf("persisting writes to %s, write-%s", cfg.storePath, cfg.storeMode)
+	}
+
+	if len(cfg.loaders) > 0 || (backingStore != nil && cfg.storeReadThrough) {
+		api.readThrough = loader.New(c)
+		if backingStore != nil && cfg.storeReadThrough {
+			api.readThrough.Register("", store.Loader(backingStore))
+		}
+		for _, route := range cfg.loaders {
+			api.readThrough.Register(route.prefix, loader.HTTP(route.url, nil))
+		}
+		if len(cfg.loaders) > 0 {
+			infoLog.Printf("filling misses from %s", cfg.loaders.String())
+		}
+		if cfg.peers != "" || api.members != nil {
+			api.group, err = startGroup(cfg, c, api.readThrough.Load, api.members, transport)
+			if err != nil {
+				errorLog.Fatal(err)
+			}
+			infoLog.Printf("sharing loaded keys with %s", api.group.Peers())
+		}
 	}
 
 	srv := &http.Server{
-		Addr:     cfg.addr,
-		ErrorLog: errorLog,
-		Handler:  api.routes(),
+		Addr:           cfg.addr,
+		ErrorLog:       errorLog,
+		Handler:        api.routes(),
+		ReadTimeout:    cfg.readTimeout,
+		WriteTimeout:   cfg.writeTimeout,
+		IdleTimeout:    cfg.idleTimeout,
+		MaxHeaderBytes: cfg.maxHeaderBytes,
+	}
+	api.conns.Server(srv)
+	ln, err := net.Listen("tcp", cfg.addr)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	ln = api.conns.Listener(ln)
+	if api.certs != nil {
+		srv.TLSConfig = api.certs.ServerConfig()
+		infoLog.Printf("starting server on %s with TLS", cfg.addr)
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		infoLog.Printf("starting server on %s", cfg.addr)
+		err = srv.Serve(ln)
 	}
-	infoLog.Printf("starting server on %s", cfg.addr)
-	err := srv.ListenAndServe()
 	errorLog.Fatal(err)
 }
+
+// newLogger returns the logger configured by the -log flags
+func newLogger(cfg *config) (*logging.Logger, error) {
+	level, err := logging.ParseLevel(cfg.logLevel)
+	if err != nil {
+		return nil, err
+	}
+	format, err := logging.ParseFormat(cfg.logFormat)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := logging.ParseKeyMode(cfg.logKeys)
+	if err != nil {
+		return nil, err
+	}
+	logger := logging.New(os.Stdout, format, level)
+	logger.SetKeyMode(keys)
+	return logger, nil
+}
+
+// replicaID names this server in the primary's stats
+func replicaID(addr string) string {
+	host, _ := os.Hostname()
+	return host + addr
+}
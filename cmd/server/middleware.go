@@ -2,7 +2,24 @@ package main
 
 import "net/http"
 
-import "fmt"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/justinas/alice"
+	"github.com/nagamocha3000/go-memcached/pkg/auth"
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	"github.com/nagamocha3000/go-memcached/pkg/conns"
+	"github.com/nagamocha3000/go-memcached/pkg/logging"
+)
 
 func secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -12,13 +29,114 @@ func secureHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// logRequest logs each request once served, with its command, key, the
+// size of the value set if any, status, reply and duration in
+// milliseconds. Requests are identified by their X-Request-ID header,
+// given one otherwise, which is sent back and carried by their context
 func (api *httpAPI) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		api.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
-		next.ServeHTTP(w, r)
+		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 64 {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(logging.WithRequestID(r.Context(), id)))
+
+		level := logging.Info
+		switch {
+		case rec.status >= http.StatusInternalServerError || strings.HasPrefix(rec.reply, cache.ServerErrorReply):
+			level = logging.Error
+		case rec.status >= http.StatusBadRequest:
+			level = logging.Warn
+		}
+		if !api.log.Enabled(level) {
+			return
+		}
+		fields := []interface{}{"id", id, "remote", r.RemoteAddr, "method", r.Method, "cmd", conns.Command(r.URL.Path)}
+		ns, key := requestKey(r.URL.EscapedPath())
+		if ns != "" {
+			fields = append(fields, "ns", ns)
+		}
+		if key != "" {
+			fields = append(fields, api.log.KeyFields(key)...)
+		}
+		if val, ok := r.URL.Query()["val"]; ok {
+			fields = append(fields, "val_bytes", len(val[0]))
+		}
+		fields = append(fields, "status", rec.status, "reply", rec.reply,
+			"duration_ms", float64(time.Since(start))/float64(time.Millisecond))
+		api.log.Log(level, "request", fields...)
 	})
 }
 
+// requestKey returns the namespace and key of a request's escaped path,
+// e.g. sessions and k for /ns/sessions/get/k, if it has them. They're
+// unescaped as the router does
+func requestKey(path string) (ns, key string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if parts[0] == "ns" && len(parts) > 1 {
+		ns, parts = unescapeParam(parts[1]), parts[2:]
+	}
+	// admin and stats commands have subcommands rather than keys
+	if len(parts) > 1 && parts[0] != "admin" && parts[0] != "stats" {
+		key = unescapeParam(parts[1])
+	}
+	return ns, key
+}
+
+func unescapeParam(s string) string {
+	if unescaped, err := url.QueryUnescape(s); err == nil {
+		return unescaped
+	}
+	return s
+}
+
+// responseRecorder notes the status and the type of reply of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	reply       string
+	wroteHeader bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	if rec.reply == "" {
+		rec.reply = replyType(b)
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Flush lets replication streams through
+func (rec *responseRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// replyType returns the reply's first word, e.g. CLIENT_ERROR, if b
+// starts a JSON reply, as every handler's does
+func replyType(b []byte) string {
+	const prefix = `{"reply":"`
+	if !bytes.HasPrefix(b, []byte(prefix)) {
+		return ""
+	}
+	b = b[len(prefix):]
+	if i := bytes.IndexAny(b, `" `); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
 func (api *httpAPI) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -30,3 +148,121 @@ func (api *httpAPI) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (api *httpAPI) rejectOnReplica(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if api.replica != nil {
+			jsonString, _ := json.Marshal(
+				stdReply{string(cache.ClientError("read-only replica"))})
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(jsonString)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate rejects requests without valid credentials, if the server
+// has any. Without, requests with a client certificate are still known
+// by its name
+func (api *httpAPI) authenticate(next http.Handler) http.Handler {
+	if api.auth == nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if name, ok := auth.CertIdentity(r); ok {
+				r = r.WithContext(auth.WithIdentity(r.Context(), name))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	return api.auth.Middleware(next)
+}
+
+// authorize rejects requests the user's ACL doesn't allow, if the server
+// authenticates requests
+func (api *httpAPI) authorize(class auth.Class) alice.Constructor {
+	if api.auth == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return api.auth.Require(class)
+}
+
+// throttle rejects requests past the client's rate limit for the class
+// of command with a 429, if there's one. Clients are told apart by their
+// identity, else their address
+func (api *httpAPI) throttle(class auth.Class) alice.Constructor {
+	limiter, ok := api.limiters[class]
+	if !ok {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, ok := auth.Identity(r.Context())
+			if !ok {
+				client, _, _ = net.SplitHostPort(r.RemoteAddr)
+			}
+			if allowed, wait := limiter.Allow(client); !allowed {
+				jsonString, _ := json.Marshal(stdReply{cache.ThrottledReply})
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write(jsonString)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitStats reports the requests throttled and clients limited by
+// class of command
+func (api *httpAPI) rateLimitStats() map[string]string {
+	stats := make(map[string]string)
+	var total int64
+	for class, limiter := range api.limiters {
+		clients, throttled := limiter.Stats()
+		total += throttled
+		stats["ratelimit_clients_"+string(class)] = strconv.Itoa(clients)
+		stats["ratelimit_throttled_"+string(class)] = strconv.FormatInt(throttled, 10)
+	}
+	stats["ratelimit_throttled"] = strconv.FormatInt(total, 10)
+	return stats
+}
+
+type namespaceKey struct{}
+
+// inNamespace has the command run on the cache of the :ns namespace,
+// replying 404 if there's no such namespace
+func (api *httpAPI) inNamespace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := api.namespaces.Get(r.URL.Query().Get(":ns"))
+		if !ok {
+			jsonString, _ := json.Marshal(
+				stdReply{string(cache.ClientError("unknown namespace"))})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(jsonString)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), namespaceKey{}, c)))
+	})
+}
+
+// reclaimQuotas evicts entries of namespaces over their quotas after
+// writes to one
+func (api *httpAPI) reclaimQuotas(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if _, ok := r.Context().Value(namespaceKey{}).(*cache.Adapter); ok {
+			api.namespaces.Reclaim()
+		}
+	})
+}
+
+// cacheFor returns the cache the request's command runs on, that of its
+// namespace if any, else the default one
+func (api *httpAPI) cacheFor(r *http.Request) *cache.Adapter {
+	if c, ok := r.Context().Value(namespaceKey{}).(*cache.Adapter); ok {
+		return c
+	}
+	return api.cache
+}
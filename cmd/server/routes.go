@@ -5,24 +5,65 @@ import (
 
 	"github.com/bmizerany/pat"
 	"github.com/justinas/alice"
+	"github.com/nagamocha3000/go-memcached/pkg/auth"
+	"github.com/nagamocha3000/go-memcached/pkg/group"
 )
 
 func (api *httpAPI) routes() http.Handler {
-	middleware := alice.New(api.recoverPanic, api.logRequest, secureHeaders)
+	// requests are logged outermost, so that recovered panics are too
+	middleware := alice.New(api.logRequest, api.recoverPanic, secureHeaders, api.conns.Middleware)
 	mux := pat.New()
 	mux.Get("/", http.HandlerFunc(api.home))
-	mux.Get("/set/:key", http.HandlerFunc(api.handleSet))
-	mux.Get("/add/:key", http.HandlerFunc(api.handleAdd))
-	mux.Get("/replace/:key", http.HandlerFunc(api.handleReplace))
-	mux.Get("/append/:key", http.HandlerFunc(api.handleAppend))
-	mux.Get("/prepend/:key", http.HandlerFunc(api.handlePrepend))
-	mux.Get("/increment/:key", http.HandlerFunc(api.handleIncrement))
-	mux.Get("/decrement/:key", http.HandlerFunc(api.handleDecrement))
-	mux.Get("/cas/:key", http.HandlerFunc(api.handleCompareAndSwap))
-	mux.Get("/get/:key", http.HandlerFunc(api.handleGet))
-	mux.Get("/gets/:key", http.HandlerFunc(api.handleGetEntryPlusToken))
-	mux.Get("/delete/:key", http.HandlerFunc(api.handleDelete))
-	mux.Get("/clear", http.HandlerFunc(api.handleClear))
-	mux.Get("/stats", http.HandlerFunc(api.handleStats))
+	api.commandRoutes(mux, "", alice.New())
+	mux.Get("/stats/conns", alice.New(api.authenticate, api.authorize(auth.ClassStats), api.throttle(auth.ClassStats)).
+		ThenFunc(api.handleStatsConns))
+	mux.Get("/admin/logging", alice.New(api.authenticate, api.authorize(auth.ClassAdmin), api.throttle(auth.ClassAdmin)).
+		ThenFunc(api.handleLogging))
+	if api.namespaces != nil {
+		api.commandRoutes(mux, "/ns/:ns", alice.New(api.inNamespace))
+	}
+	cluster := alice.New(api.authenticate, api.authorize(auth.ClassCluster), api.throttle(auth.ClassCluster))
+	if api.primary != nil {
+		mux.Get("/repl/snapshot", cluster.ThenFunc(api.primary.HandleSnapshot))
+		mux.Get("/repl/stream", cluster.ThenFunc(api.primary.HandleStream))
+	}
+	if api.group != nil {
+		mux.Get(group.PeerGetPath, cluster.ThenFunc(api.group.HandlePeerGet))
+	}
+	if api.members != nil {
+		mux.Get("/cluster/members", cluster.ThenFunc(api.members.HandleMembers))
+	}
 	return middleware.Then(mux)
 }
+
+// commandRoutes routes the cache commands under the prefix, through the
+// ns chain once the request's allowed
+func (api *httpAPI) commandRoutes(mux *pat.PatternServeMux, prefix string, ns alice.Chain) {
+	// everything but the home page, which health checks use, needs
+	// credentials when authentication is on, and the user's ACL must
+	// allow the route's class of command, within the client's rate limit
+	authed := func(class auth.Class) alice.Chain {
+		return alice.New(api.authenticate, api.authorize(class), api.throttle(class)).Extend(ns)
+	}
+	read := authed(auth.ClassRead)
+	// writes only come from the primary on a replica
+	write := authed(auth.ClassWrite).Append(api.rejectOnReplica, api.reclaimQuotas)
+	mux.Get(prefix+"/set/:key", write.ThenFunc(api.handleSet))
+	mux.Get(prefix+"/add/:key", write.ThenFunc(api.handleAdd))
+	mux.Get(prefix+"/replace/:key", write.ThenFunc(api.handleReplace))
+	mux.Get(prefix+"/append/:key", write.ThenFunc(api.handleAppend))
+	mux.Get(prefix+"/prepend/:key", write.ThenFunc(api.handlePrepend))
+	mux.Get(prefix+"/increment/:key", write.ThenFunc(api.handleIncrement))
+	mux.Get(prefix+"/decrement/:key", write.ThenFunc(api.handleDecrement))
+	mux.Get(prefix+"/cas/:key", write.ThenFunc(api.handleCompareAndSwap))
+	mux.Get(prefix+"/get/:key", read.ThenFunc(api.handleGet))
+	mux.Get(prefix+"/gets/:key", read.ThenFunc(api.handleGetEntryPlusToken))
+	mux.Get(prefix+"/mget", read.ThenFunc(api.handleGetMulti))
+	mux.Get(prefix+"/lget/:key", read.ThenFunc(api.handleGetWithLease))
+	mux.Get(prefix+"/lset/:key", write.ThenFunc(api.handleSetWithLease))
+	mux.Get(prefix+"/touch/:key", write.ThenFunc(api.handleTouch))
+	mux.Get(prefix+"/delete/:key", write.ThenFunc(api.handleDelete))
+	mux.Get(prefix+"/clear", authed(auth.ClassFlush).Append(api.rejectOnReplica).ThenFunc(api.handleClear))
+	mux.Get(prefix+"/stats", authed(auth.ClassStats).ThenFunc(api.handleStats))
+	mux.Get(prefix+"/admin/migrate", authed(auth.ClassAdmin).Append(api.rejectOnReplica).ThenFunc(api.handleMigrate))
+}
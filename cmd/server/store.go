@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	"github.com/nagamocha3000/go-memcached/pkg/store"
+)
+
+// startStore opens the embedded store and persists the writes to c to it.
+// With write-behind, the writes still queued are persisted on SIGINT or
+// SIGTERM before exiting
+func startStore(cfg *config, c *cache.Adapter, errorLog *log.Logger) (store.Store, error) {
+	fs, err := store.OpenFile(cfg.storePath)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.storeMode {
+	case "through":
+		store.NewWriteThrough(c, fs, 0)
+	case "behind":
+		wb := store.NewWriteBehind(c, fs, store.WriteBehindOptions{
+			QueueSize:     cfg.writeBehindQueue,
+			BatchSize:     cfg.writeBehindBatch,
+			FlushInterval: cfg.writeBehindInterval,
+			ErrorLog:      errorLog,
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			wb.Run(ctx)
+			close(done)
+		}()
+		go func() {
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			<-sig
+			cancel()
+			<-done
+			fs.Close()
+			os.Exit(0)
+		}()
+	default:
+		fs.Close()
+		return nil, fmt.Errorf("invalid store mode %q, must be through or behind", cfg.storeMode)
+	}
+	return fs, nil
+}
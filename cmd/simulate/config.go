@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type config struct {
+	trace      string
+	format     string
+	cacheTypes string
+	capacities []int
+	options    string
+	blockSize  int
+	fill       bool
+	csv        string
+}
+
+func getConfig() (*config, error) {
+	cfg := config{}
+	var capacities string
+	flag.StringVar(&cfg.trace, "trace", "-", "trace file to replay, - for stdin")
+	flag.StringVar(&cfg.format, "format", "log",
+		fmt.Sprintf("trace format: [%s]", strings.Join(traceFormats(), ", ")))
+	flag.StringVar(&cfg.cacheTypes, "cacheTypes", "", "comma separated cache types to simulate, empty for all registered")
+	flag.StringVar(&capacities, "capacities", "100,1000,10000", "comma separated cache capacities to simulate")
+	flag.StringVar(&cfg.options, "cacheOptions", "", "comma separated name=value cache policy options")
+	flag.IntVar(&cfg.blockSize, "blockSize", 4096, "object size in bytes for block traces (arc, lirs)")
+	flag.BoolVar(&cfg.fill, "fill", true, "set a key after a get misses, as a read-through client would")
+	flag.StringVar(&cfg.csv, "csv", "", "also write the results as CSV to this file")
+	flag.Parse()
+	for _, s := range strings.Split(capacities, ",") {
+		capacity, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || capacity < 1 {
+			return nil, fmt.Errorf("invalid capacity %q", s)
+		}
+		cfg.capacities = append(cfg.capacities, capacity)
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+
+	_ "github.com/nagamocha3000/go-memcached/pkg/cache/policies"
+)
+
+func main() {
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime)
+
+	cfg, err := getConfig()
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	sim, err := newSimulator(cfg)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	var trace io.Reader = os.Stdin
+	if cfg.trace != "-" {
+		f, err := os.Open(cfg.trace)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		defer f.Close()
+		trace = f
+	}
+	if err := readTrace(trace, cfg, sim.replay); err != nil {
+		errorLog.Fatal(err)
+	}
+
+	if err := sim.writeTable(os.Stdout); err != nil {
+		errorLog.Fatal(err)
+	}
+	if cfg.csv != "" {
+		f, err := os.Create(cfg.csv)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		defer f.Close()
+		if err := sim.writeCSV(f); err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+}
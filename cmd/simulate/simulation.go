@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+)
+
+// simulation replays requests against one cache policy at one capacity
+type simulation struct {
+	cacheType string
+	capacity  int
+	cache     cache.Cache
+	lenCache  cache.LenCache // nil if the policy can't report its length
+	fill      bool
+
+	reads          int64
+	hits           int64
+	bytesRequested int64
+	bytesHit       int64
+	evictions      int64
+}
+
+func (s *simulation) apply(req request, size int, value string) {
+	switch req.op {
+	case opGet:
+		s.reads++
+		s.bytesRequested += int64(size)
+		if _, ok := s.cache.Get(req.key); ok {
+			s.hits++
+			s.bytesHit += int64(size)
+		} else if s.fill {
+			s.set(req.key, value)
+		}
+	case opSet:
+		s.set(req.key, value)
+	case opDelete:
+		s.cache.Delete(req.key)
+	}
+}
+
+// set stores the key, counting evictions by how much the cache shrank
+// other than by the key itself, as updates that grow an entry can evict
+// others and writes too big to hold are dropped
+func (s *simulation) set(key, value string) {
+	if s.lenCache == nil {
+		s.cache.Set(key, value, 0)
+		return
+	}
+	before := s.lenCache.Len()
+	if !s.cache.Exists(key) {
+		before++
+	}
+	s.cache.Set(key, value, 0)
+	after := s.lenCache.Len()
+	if !s.cache.Exists(key) {
+		after++
+	}
+	s.evictions += int64(before - after)
+}
+
+func (s *simulation) hitRatio() float64 {
+	if s.reads == 0 {
+		return 0
+	}
+	return float64(s.hits) / float64(s.reads)
+}
+
+func (s *simulation) byteHitRatio() float64 {
+	if s.bytesRequested == 0 {
+		return 0
+	}
+	return float64(s.bytesHit) / float64(s.bytesRequested)
+}
+
+// simulator fans every request out to all simulations
+type simulator struct {
+	simulations []*simulation
+	sizes       map[string]int // last known object size per key
+	filler      string
+}
+
+func newSimulator(cfg *config) (*simulator, error) {
+	cacheTypes := cache.CacheTypes()
+	if cfg.cacheTypes != "" {
+		cacheTypes = strings.Split(cfg.cacheTypes, ",")
+	}
+	opts, err := cache.ParseOptions(cfg.options)
+	if err != nil {
+		return nil, err
+	}
+	sim := &simulator{sizes: make(map[string]int)}
+	for _, cacheType := range cacheTypes {
+		for _, capacity := range cfg.capacities {
+			c, err := cache.NewPolicy(strings.TrimSpace(cacheType), capacity, opts)
+			if err != nil {
+				return nil, err
+			}
+			lenCache, _ := c.(cache.LenCache)
+			sim.simulations = append(sim.simulations, &simulation{
+				cacheType: strings.TrimSpace(cacheType),
+				capacity:  capacity,
+				cache:     c,
+				lenCache:  lenCache,
+				fill:      cfg.fill,
+			})
+		}
+	}
+	return sim, nil
+}
+
+// value returns a value that makes key plus value size bytes long, so
+// size-aware policies see the object's real size
+func (sim *simulator) value(key string, size int) string {
+	n := size - len(key)
+	if n < 0 {
+		n = 0
+	}
+	if n > len(sim.filler) {
+		sim.filler = strings.Repeat("x", n*2)
+	}
+	return sim.filler[:n]
+}
+
+func (sim *simulator) replay(req request) {
+	size := req.size
+	switch {
+	case req.op == opSet || (req.op == opGet && size > 0):
+		sim.sizes[req.key] = size
+	case req.op == opGet:
+		size = sim.sizes[req.key]
+		if size == 0 {
+			size = len(req.key)
+		}
+	case req.op == opDelete:
+		delete(sim.sizes, req.key)
+	}
+	value := sim.value(req.key, size)
+	for _, s := range sim.simulations {
+		s.apply(req, size, value)
+	}
+}
+
+var header = []string{"cache type", "capacity", "reads", "hits", "hit ratio", "byte hit ratio", "evictions"}
+
+func (s *simulation) record() []string {
+	evictions := "n/a"
+	if s.lenCache != nil {
+		evictions = strconv.FormatInt(s.evictions, 10)
+	}
+	return []string{
+		s.cacheType,
+		strconv.Itoa(s.capacity),
+		strconv.FormatInt(s.reads, 10),
+		strconv.FormatInt(s.hits, 10),
+		strconv.FormatFloat(s.hitRatio(), 'f', 4, 64),
+		strconv.FormatFloat(s.byteHitRatio(), 'f', 4, 64),
+		evictions,
+	}
+}
+
+func (sim *simulator) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+	for _, s := range sim.simulations {
+		fmt.Fprintln(tw, strings.Join(s.record(), "\t")+"\t")
+	}
+	return tw.Flush()
+}
+
+func (sim *simulator) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for _, s := range sim.simulations {
+		cw.Write(s.record())
+	}
+	cw.Flush()
+	return cw.Error()
+}
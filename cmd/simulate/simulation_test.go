@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSimulation(t *testing.T) {
+	get := func(key string) request { return request{op: opGet, key: key} }
+	tests := []struct {
+		name     string
+		fill     bool
+		requests []request
+		want     []string
+	}{
+		{
+			name:     "fill",
+			fill:     true,
+			requests: []request{get("a"), get("b"), get("a"), get("c"), get("b"), get("c")},
+			want:     []string{"lru", "2", "6", "2", "0.3333", "0.3333", "2"},
+		},
+		{
+			name:     "no fill",
+			requests: []request{get("a"), get("b"), get("a")},
+			want:     []string{"lru", "2", "3", "0", "0.0000", "0.0000", "0"},
+		},
+		{
+			// gets without a size take the last one set, or the key's
+			name: "sizes",
+			requests: []request{
+				{op: opSet, key: "x", size: 100}, get("x"),
+				{op: opDelete, key: "x"}, get("x"),
+				{op: opSet, key: "y", size: 20}, {op: opSet, key: "z", size: 20}, {op: opSet, key: "w", size: 60},
+			},
+			want: []string{"lru", "2", "2", "1", "0.5000", "0.9901", "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim, err := newSimulator(&config{cacheTypes: "lru", capacities: []int{2}, fill: tt.fill})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, req := range tt.requests {
+				sim.replay(req)
+			}
+			if got := sim.simulations[0].record(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimulatorValueSizes(t *testing.T) {
+	sim, err := newSimulator(&config{cacheTypes: "gdsf", capacities: []int{10}, options: "maxBytes=100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// values make up each object's size, as gdsf counts keys and values
+	// against its 100 bytes, so the third object evicts one
+	for _, req := range []request{
+		{op: opSet, key: "big", size: 60},
+		{op: opSet, key: "small", size: 30},
+		{op: opSet, key: "other", size: 30},
+	} {
+		sim.replay(req)
+		if got := len(sim.value(req.key, req.size)) + len(req.key); got != req.size {
+			t.Errorf("%s: got an object of %d bytes, want %d", req.key, got, req.size)
+		}
+	}
+	if s := sim.simulations[0]; s.evictions != 1 {
+		t.Errorf("got %d evictions, want 1", s.evictions)
+	}
+
+	var buf bytes.Buffer
+	if err := sim.writeCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(header, ",") + "\ngdsf,10,0,0,0.0000,0.0000,1\n"; buf.String() != want {
+		t.Errorf("got CSV %q, want %q", buf.String(), want)
+	}
+}
+
+func TestSimulatorEvictions(t *testing.T) {
+	set := func(key string, size int) request { return request{op: opSet, key: key, size: size} }
+	tests := []struct {
+		name     string
+		requests []request
+		want     int64
+	}{
+		{"growing value", []request{set("a", 30), set("b", 30), set("c", 30), set("a", 60)}, 1},
+		{"new key", []request{set("a", 30), set("b", 30), set("c", 30), set("d", 30)}, 1},
+		{"too big", []request{set("a", 30), set("b", 200)}, 0},
+		{"update too big", []request{set("a", 30), set("b", 30), set("a", 200)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim, err := newSimulator(&config{cacheTypes: "gdsf", capacities: []int{10}, options: "maxBytes=100"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, req := range tt.requests {
+				sim.replay(req)
+			}
+			if got := sim.simulations[0].evictions; got != tt.want {
+				t.Errorf("got %d evictions, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type opKind int
+
+const (
+	opGet opKind = iota
+	opSet
+	opDelete
+)
+
+// request is a single key access replayed against the caches. size is
+// the size of the object in bytes, zero if the trace doesn't say
+type request struct {
+	op   opKind
+	key  string
+	size int
+}
+
+// lineParser parses one line of a trace, emitting the requests in it.
+// Blank lines and comments are skipped before it's called
+type lineParser func(line string, cfg *config, emit func(request)) error
+
+var parsers = map[string]lineParser{
+	"log":     parseLogLine,
+	"arc":     parseARCLine,
+	"lirs":    parseLIRSLine,
+	"twitter": parseTwitterLine,
+}
+
+func traceFormats() []string {
+	formats := make([]string, 0, len(parsers))
+	for name := range parsers {
+		formats = append(formats, name)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// readTrace calls emit for every request in the trace
+func readTrace(r io.Reader, cfg *config, emit func(request)) error {
+	parse, ok := parsers[cfg.format]
+	if !ok {
+		return fmt.Errorf("unknown trace format %q, must be one of %s",
+			cfg.format, strings.Join(traceFormats(), ", "))
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := parse(line, cfg, emit); err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseLogLine parses the request entries logged by cmd/server, as
+// logfmt or JSON, e.g.
+//
+//	ts=2020-01-02T15:04:05Z level=info msg=request id=1a2b remote=127.0.0.1:5000 method=GET cmd=set ns=sessions key=foo val_bytes=3 status=200 reply=STORED duration_ms=0.1
+//
+// Keys of a namespace are prefixed with it. Other entries, and requests
+// that aren't cache commands, are ignored. The keys must be logged as
+// they are, -logKeys=plain, as hashed ones can't be told the size of and
+// redacted ones can't be told apart
+func parseLogLine(line string, cfg *config, emit func(request)) error {
+	fields, err := parseLogFields(line)
+	if err != nil {
+		return err
+	}
+	if fields["msg"] != "request" {
+		return nil
+	}
+	var op opKind
+	switch fields["cmd"] {
+	case "get", "gets", "lget":
+		op = opGet
+	case "set", "add", "replace", "append", "prepend", "cas", "increment", "decrement", "lset":
+		op = opSet
+	case "delete":
+		op = opDelete
+	default:
+		return nil
+	}
+	key, ok := fields["key"]
+	if !ok {
+		if _, hashed := fields["key_hash"]; hashed {
+			return errors.New("keys are hashed, the server must log them with -logKeys=plain to be replayed")
+		}
+		return errors.New("keys are redacted, the server must log them with -logKeys=plain to be replayed")
+	}
+	if ns := fields["ns"]; ns != "" {
+		key = ns + "/" + key
+	}
+	req := request{op: op, key: key}
+	if op == opSet {
+		valBytes, _ := strconv.Atoi(fields["val_bytes"])
+		req.size = len(key) + valBytes
+	}
+	emit(req)
+	return nil
+}
+
+// parseLogFields returns the fields of a logfmt or JSON log entry
+func parseLogFields(line string) (map[string]string, error) {
+	fields := make(map[string]string)
+	if strings.HasPrefix(line, "{") {
+		var entry map[string]interface{}
+		d := json.NewDecoder(strings.NewReader(line))
+		// keep sizes as written rather than as floats
+		d.UseNumber()
+		if err := d.Decode(&entry); err != nil {
+			return nil, err
+		}
+		for name, val := range entry {
+			fields[name] = fmt.Sprint(val)
+		}
+		return fields, nil
+	}
+	for line != "" {
+		i := strings.IndexByte(line, '=')
+		if i < 1 {
+			return nil, fmt.Errorf("want name=value, got %q", line)
+		}
+		name, rest := line[:i], line[i+1:]
+		val := rest
+		if strings.HasPrefix(rest, `"`) {
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(rest) {
+				return nil, fmt.Errorf("unterminated quote in %q", rest)
+			}
+			var err error
+			if val, err = strconv.Unquote(rest[:end+1]); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			rest = rest[end+1:]
+		} else if j := strings.IndexByte(rest, ' '); j >= 0 {
+			val, rest = rest[:j], rest[j:]
+		} else {
+			rest = ""
+		}
+		fields[name] = val
+		line = strings.TrimLeft(rest, " ")
+	}
+	return fields, nil
+}
+
+// parseARCLine parses the traces from the ARC paper, where each line is
+//
+//	startingBlock numberOfBlocks ignore requestNumber
+//
+// and every block in the range is read
+func parseARCLine(line string, cfg *config, emit func(request)) error {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return fmt.Errorf("want at least 2 fields, got %d", len(fields))
+	}
+	start, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	for block := start; block < start+n; block++ {
+		emit(request{op: opGet, key: strconv.FormatInt(block, 10), size: cfg.blockSize})
+	}
+	return nil
+}
+
+// parseLIRSLine parses the traces from the LIRS paper, one block number
+// per line. A line of "*" marks the end of the trace in some of them
+func parseLIRSLine(line string, cfg *config, emit func(request)) error {
+	if line == "*" {
+		return nil
+	}
+	if _, err := strconv.ParseInt(line, 10, 64); err != nil {
+		return err
+	}
+	emit(request{op: opGet, key: line, size: cfg.blockSize})
+	return nil
+}
+
+// parseTwitterLine parses the Twitter production cache traces, which are
+// CSV with the fields
+//
+//	timestamp,key,keySize,valueSize,clientID,operation,TTL
+func parseTwitterLine(line string, cfg *config, emit func(request)) error {
+	fields := strings.Split(line, ",")
+	if len(fields) < 6 {
+		return fmt.Errorf("want 7 fields, got %d", len(fields))
+	}
+	key := fields[1]
+	keySize, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return err
+	}
+	valueSize, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return err
+	}
+	size := keySize + valueSize
+	switch fields[5] {
+	case "get", "gets":
+		emit(request{op: opGet, key: key, size: size})
+	case "set", "add", "replace", "cas", "append", "prepend", "incr", "decr":
+		emit(request{op: opSet, key: key, size: size})
+	case "delete":
+		emit(request{op: opDelete, key: key})
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nagamocha3000/go-memcached/pkg/logging"
+)
+
+// logRequest logs a request the way cmd/server does
+func logRequest(l *logging.Logger, level logging.Level, cmd, ns, key string, valBytes int, reply string) {
+	fields := []interface{}{"id", logging.NewRequestID(), "remote", "127.0.0.1:5000", "method", "GET", "cmd", cmd}
+	if ns != "" {
+		fields = append(fields, "ns", ns)
+	}
+	if key != "" {
+		fields = append(fields, l.KeyFields(key)...)
+	}
+	if valBytes > 0 {
+		fields = append(fields, "val_bytes", valBytes)
+	}
+	fields = append(fields, "status", 200, "reply", reply, "duration_ms", 0.25)
+	l.Log(level, "request", fields...)
+}
+
+func logRequests(l *logging.Logger) {
+	l.Info("listening", "addr", ":4000")
+	logRequest(l, logging.Info, "set", "", "user 1", 1048576, "STORED")
+	logRequest(l, logging.Info, "get", "sessions", `a"b`, 0, "VALUE")
+	logRequest(l, logging.Warn, "delete", "", "k=v", 0, "NOT_FOUND")
+	logRequest(l, logging.Info, "stats", "", "", 0, "OK")
+}
+
+func TestReadTrace(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		trace   string
+		want    []request
+		wantErr string
+	}{
+		{
+			name:   "arc",
+			format: "arc",
+			trace:  "0 3 0 1\n\n10 1 0 2\n",
+			want: []request{
+				{op: opGet, key: "0", size: 512}, {op: opGet, key: "1", size: 512},
+				{op: opGet, key: "2", size: 512}, {op: opGet, key: "10", size: 512},
+			},
+		},
+		{name: "arc one field", format: "arc", trace: "0 1 0 1\n5\n", wantErr: "line 2: want at least 2 fields"},
+		{name: "arc bad block", format: "arc", trace: "x 1 0 1\n", wantErr: "line 1:"},
+		{
+			name:   "lirs",
+			format: "lirs",
+			trace:  "# a comment\n7\n8\n7\n*\n",
+			want:   []request{{op: opGet, key: "7", size: 512}, {op: opGet, key: "8", size: 512}, {op: opGet, key: "7", size: 512}},
+		},
+		{name: "lirs bad block", format: "lirs", trace: "7\nseven\n", wantErr: "line 2:"},
+		{
+			name:   "twitter",
+			format: "twitter",
+			trace: "0,k1,2,10,1,get,0\n1,k1,2,10,1,set,60\n2,k2,2,0,1,delete,0\n" +
+				"3,k3,2,5,1,incr,0\n4,k4,2,5,1,gets,0\n5,k5,2,5,1,flush,0\n",
+			want: []request{
+				{op: opGet, key: "k1", size: 12}, {op: opSet, key: "k1", size: 12}, {op: opDelete, key: "k2"},
+				{op: opSet, key: "k3", size: 7}, {op: opGet, key: "k4", size: 7},
+			},
+		},
+		{name: "twitter too few fields", format: "twitter", trace: "0,k1,2,10\n", wantErr: "line 1: want 7 fields, got 4"},
+		{name: "twitter bad size", format: "twitter", trace: "0,k1,2,ten,1,get,0\n", wantErr: "line 1:"},
+		{
+			name:   "log",
+			format: "log",
+			trace: "ts=2020-01-02T15:04:05Z level=info msg=request cmd=lset ns=a key=k val_bytes=3 reply=STORED\n" +
+				`{"ts":"2020-01-02T15:04:05Z","level":"info","msg":"request","cmd":"gets","key":"k2","reply":"VALUE"}` + "\n" +
+				"ts=2020-01-02T15:04:05Z level=info msg=listening addr=:4000\n",
+			want: []request{{op: opSet, key: "a/k", size: 6}, {op: opGet, key: "k2"}},
+		},
+		{name: "log unterminated quote", format: "log", trace: `msg=request key="k` + "\n", wantErr: "line 1: unterminated quote"},
+		{name: "unknown format", format: "csv", trace: "", wantErr: "unknown trace format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []request
+			cfg := &config{format: tt.format, blockSize: 512}
+			err := readTrace(strings.NewReader(tt.trace), cfg, func(req request) { got = append(got, req) })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	want := []request{
+		{op: opSet, key: "user 1", size: len("user 1") + 1048576},
+		{op: opGet, key: `sessions/a"b`},
+		{op: opDelete, key: "k=v"},
+	}
+	for _, format := range []logging.Format{logging.Logfmt, logging.JSON} {
+		var buf bytes.Buffer
+		logRequests(logging.New(&buf, format, logging.Debug))
+		var got []request
+		err := readTrace(&buf, &config{format: "log"}, func(req request) { got = append(got, req) })
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", format, got, want)
+		}
+	}
+}
+
+func TestParseLogLineNeedsPlainKeys(t *testing.T) {
+	for _, mode := range []logging.KeyMode{logging.KeysHash, logging.KeysRedact} {
+		var buf bytes.Buffer
+		l := logging.New(&buf, logging.Logfmt, logging.Info)
+		l.SetKeyMode(mode)
+		logRequests(l)
+		err := readTrace(&buf, &config{format: "log"}, func(request) {})
+		if err == nil || !strings.Contains(err.Error(), "-logKeys=plain") {
+			t.Errorf("%s keys: got %v, want an error asking for plain keys", mode, err)
+		}
+	}
+}
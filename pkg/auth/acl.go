@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Class groups the commands an ACL allows or not
+type Class string
+
+// Command classes
+const (
+	// ClassRead is get, gets, mget and lget
+	ClassRead Class = "read"
+	// ClassWrite is every command modifying a key
+	ClassWrite Class = "write"
+	// ClassFlush is clear, which removes every key whatever its prefix
+	ClassFlush Class = "flush"
+	// ClassStats is stats, which reports on every key
+	ClassStats Class = "stats"
+	// ClassAdmin is changing the server's settings, e.g. the cache policy
+	ClassAdmin Class = "admin"
+	// ClassCluster is what servers ask of each other: replication, peer
+	// fills and membership
+	ClassCluster Class = "cluster"
+)
+
+var classes = []Class{ClassRead, ClassWrite, ClassFlush, ClassStats, ClassAdmin, ClassCluster}
+
+// acl is what a user may do. A nil commands or prefixes allows all. A
+// nil namespaces allows all if prefixes is nil too, else only the
+// default namespace, as prefixes are of the default namespace's keys
+type acl struct {
+	commands   map[Class]bool
+	prefixes   []string
+	namespaces map[string]bool
+}
+
+// AllNamespaces, as a user's namespace, grants it every namespace
+const AllNamespaces = "*"
+
+func newACL(commands []Class, prefixes, namespaces []string) (acl, error) {
+	var rules acl
+	if len(commands) > 0 {
+		rules.commands = make(map[Class]bool)
+	}
+	for _, class := range commands {
+		if !isClass(class) {
+			return acl{}, fmt.Errorf("unknown command class %q", class)
+		}
+		rules.commands[class] = true
+	}
+	if len(prefixes) > 0 {
+		rules.prefixes = append([]string(nil), prefixes...)
+	}
+	if len(namespaces) > 0 {
+		rules.namespaces = make(map[string]bool)
+	}
+	for _, ns := range namespaces {
+		rules.namespaces[ns] = true
+	}
+	return rules, nil
+}
+
+// ParseClass returns the class named s
+func ParseClass(s string) (Class, error) {
+	if !isClass(Class(s)) {
+		return "", fmt.Errorf("unknown command class %q", s)
+	}
+	return Class(s), nil
+}
+
+func isClass(class Class) bool {
+	for _, c := range classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func (rules acl) allows(class Class, ns string, keys []string) bool {
+	if rules.commands != nil && !rules.commands[class] {
+		return false
+	}
+	if ns != "" && !rules.allowsNamespace(ns) {
+		return false
+	}
+	if rules.prefixes == nil {
+		return true
+	}
+	for _, key := range keys {
+		if !hasAnyPrefix(key, rules.prefixes) {
+			return false
+		}
+	}
+	return true
+}
+
+func (rules acl) allowsNamespace(ns string) bool {
+	if rules.namespaces == nil {
+		return rules.prefixes == nil
+	}
+	return rules.namespaces[AllNamespaces] || rules.namespaces[ns]
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether the user may run a command of the class on the
+// keys of the default namespace, counting denials
+func (a *Authenticator) Allowed(name string, class Class, keys ...string) bool {
+	return a.AllowedIn(name, class, "", keys...)
+}
+
+// AllowedIn reports whether the user may run a command of the class on
+// the keys of the namespace, "" being the default one, counting denials
+func (a *Authenticator) AllowedIn(name string, class Class, ns string, keys ...string) bool {
+	rules, ok := a.acls[name]
+	if ok && rules.allows(class, ns, keys) {
+		return true
+	}
+	a.denials.add(class)
+	return false
+}
+
+// Require returns middleware letting through requests of users allowed
+// to run commands of the class on the request's keys, which are its
+// :key route parameter and key query parameters, in its namespace, its
+// :ns route parameter. It must run after Middleware. The others get a 403
+func (a *Authenticator) Require(class Class) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name, _ := Identity(r.Context())
+			query := r.URL.Query()
+			keys := append(query[":key"], query["key"]...)
+			if !a.AllowedIn(name, class, query.Get(":ns"), keys...) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"reply":"CLIENT_ERROR permission denied"}`))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// denials counts the requests denied, by class
+type denials struct {
+	mu      sync.Mutex
+	byClass map[Class]int64
+}
+
+func (d *denials) add(class Class) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.byClass == nil {
+		d.byClass = make(map[Class]int64)
+	}
+	d.byClass[class]++
+}
+
+func (d *denials) addStats(stats map[string]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var total int64
+	for _, class := range classes {
+		stats["acl_denials_"+string(class)] = strconv.FormatInt(d.byClass[class], 10)
+		total += d.byClass[class]
+	}
+	stats["acl_denials"] = strconv.FormatInt(total, 10)
+}
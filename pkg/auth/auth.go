@@ -0,0 +1,212 @@
+// Package auth authenticates requests to the HTTP API with bearer tokens
+// or HTTP basic auth, against users loaded from a credentials file
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// User is an identity and the credentials it may present
+type User struct {
+	Name string `json:"name"`
+	// Password for HTTP basic auth, either as is or as "sha256:" followed
+	// by the hex encoded digest. Empty if the user can't use basic auth
+	Password string `json:"password,omitempty"`
+	// Tokens are accepted as "Authorization: Bearer <token>"
+	Tokens []string `json:"tokens,omitempty"`
+	// Commands are the classes of commands the user may run. All if empty
+	Commands []Class `json:"commands,omitempty"`
+	// Prefixes are those the keys the user reads or writes must start
+	// with. Any key if empty
+	Prefixes []string `json:"prefixes,omitempty"`
+	// Namespaces are those the user may use besides the default one, "*"
+	// for all. If empty, all unless the user has prefixes, which are then
+	// of the default namespace's keys only
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// File is the layout of a credentials file, e.g.
+//
+//	{"users": [
+//		{"name": "web", "password": "sha256:5e88...", "tokens": ["s3cr3t"],
+//			"commands": ["read", "write"], "prefixes": ["web:"], "namespaces": ["sessions"]}
+//	]}
+type File struct {
+	Users []User `json:"users"`
+}
+
+// Authenticator checks requests' credentials. It's safe for concurrent use
+type Authenticator struct {
+	passwords map[string][]byte // user name to password digest
+	tokens    map[string]string // token digest to user name
+	acls      map[string]acl    // user name to what it may do
+
+	failures  int64
+	successes int64
+	denials   denials
+}
+
+// LoadFile returns an Authenticator for the users in the credentials file
+func LoadFile(path string) (*Authenticator, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("auth: parsing %s: %w", path, err)
+	}
+	return New(f.Users)
+}
+
+// New returns an Authenticator for the users
+func New(users []User) (*Authenticator, error) {
+	a := &Authenticator{
+		passwords: make(map[string][]byte),
+		tokens:    make(map[string]string),
+		acls:      make(map[string]acl),
+	}
+	for _, u := range users {
+		if u.Name == "" {
+			return nil, errors.New("auth: user without a name")
+		}
+		if _, seen := a.acls[u.Name]; seen {
+			return nil, fmt.Errorf("auth: user %q given twice", u.Name)
+		}
+		rules, err := newACL(u.Commands, u.Prefixes, u.Namespaces)
+		if err != nil {
+			return nil, fmt.Errorf("auth: user %q: %w", u.Name, err)
+		}
+		a.acls[u.Name] = rules
+		if u.Password != "" {
+			digest, err := passwordDigest(u.Password)
+			if err != nil {
+				return nil, fmt.Errorf("auth: user %q: %w", u.Name, err)
+			}
+			a.passwords[u.Name] = digest
+		}
+		for _, token := range u.Tokens {
+			d := digest(token)
+			if other, ok := a.tokens[string(d)]; ok {
+				return nil, fmt.Errorf("auth: users %q and %q share a token", other, u.Name)
+			}
+			a.tokens[string(d)] = u.Name
+		}
+	}
+	return a, nil
+}
+
+func digest(s string) []byte {
+	d := sha256.Sum256([]byte(s))
+	return d[:]
+}
+
+func passwordDigest(password string) ([]byte, error) {
+	if !strings.HasPrefix(password, "sha256:") {
+		return digest(password), nil
+	}
+	d, err := hex.DecodeString(strings.TrimPrefix(password, "sha256:"))
+	if err != nil || len(d) != sha256.Size {
+		return nil, errors.New("invalid sha256 password digest")
+	}
+	return d, nil
+}
+
+// Authenticate returns the name of the user whose credentials the
+// request carries, or else whose name is the common name of the verified
+// client certificate it came with. ok is false if it carries none or
+// they're wrong
+func (a *Authenticator) Authenticate(r *http.Request) (name string, ok bool) {
+	name, ok = a.authenticate(r)
+	if ok {
+		atomic.AddInt64(&a.successes, 1)
+	} else {
+		atomic.AddInt64(&a.failures, 1)
+	}
+	return name, ok
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (string, bool) {
+	if user, password, ok := r.BasicAuth(); ok {
+		want, isPresent := a.passwords[user]
+		// compare anyway so unknown users take as long as wrong passwords
+		if !isPresent {
+			want = make([]byte, sha256.Size)
+		}
+		return user, subtle.ConstantTimeCompare(digest(password), want) == 1 && isPresent
+	}
+	const bearer = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) > len(bearer) && strings.EqualFold(header[:len(bearer)], bearer) {
+		// tokens are looked up by digest, so timing reveals nothing of them
+		name, ok := a.tokens[string(digest(header[len(bearer):]))]
+		return name, ok
+	}
+	if name, ok := CertIdentity(r); ok {
+		_, known := a.acls[name]
+		return name, known
+	}
+	return "", false
+}
+
+// CertIdentity returns the common name of the verified client
+// certificate the request came with, if any
+func CertIdentity(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	return name, name != ""
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the authenticated user's name
+func WithIdentity(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, identityKey{}, name)
+}
+
+// Identity returns the authenticated user's name carried by ctx
+func Identity(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(identityKey{}).(string)
+	return name, ok
+}
+
+// Middleware rejects requests without valid credentials with a 401, and
+// passes the others on with the user's identity in their context
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := a.Authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="go-memcached"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"reply":"CLIENT_ERROR authentication required"}`))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), name)))
+	})
+}
+
+// Stats reports the users known, the requests authenticated or not and
+// those denied by the users' ACLs
+func (a *Authenticator) Stats() map[string]string {
+	stats := map[string]string{
+		"auth_users":     strconv.Itoa(len(a.acls)),
+		"auth_successes": strconv.FormatInt(atomic.LoadInt64(&a.successes), 10),
+		"auth_failures":  strconv.FormatInt(atomic.LoadInt64(&a.failures), 10),
+	}
+	a.denials.addStats(stats)
+	return stats
+}
@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	hashed := sha256.Sum256([]byte("hunter2"))
+	a, err := New([]User{
+		{Name: "web", Password: "plain", Tokens: []string{"t0k3n"}},
+		{Name: "batch", Password: "sha256:" + hex.EncodeToString(hashed[:])},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		authorization string
+		want          string
+		ok            bool
+	}{
+		{"none", "", "", false},
+		{"basic", Basic("web", "plain"), "web", true},
+		{"hashed password", Basic("batch", "hunter2"), "batch", true},
+		{"wrong password", Basic("web", "nope"), "", false},
+		{"unknown user", Basic("nobody", "plain"), "", false},
+		{"bearer", Bearer("t0k3n"), "web", true},
+		{"lower case scheme", "bearer t0k3n", "web", true},
+		{"wrong token", Bearer("nope"), "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/get/k", nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		name, ok := a.Authenticate(r)
+		if ok != tt.ok || (ok && name != tt.want) {
+			t.Errorf("%s: got %q, %v, want %q, %v", tt.name, name, ok, tt.want, tt.ok)
+		}
+	}
+	if st := a.Stats(); st["auth_users"] != "2" || st["auth_successes"] != "4" || st["auth_failures"] != "4" {
+		t.Errorf("stats: got %v", st)
+	}
+}
+
+func TestNewRejectsBadUsers(t *testing.T) {
+	for name, users := range map[string][]User{
+		"no name":      {{Password: "p"}},
+		"duplicate":    {{Name: "a"}, {Name: "a"}},
+		"shared token": {{Name: "a", Tokens: []string{"t"}}, {Name: "b", Tokens: []string{"t"}}},
+		"bad sha256":   {{Name: "a", Password: "sha256:xyz"}},
+		"short sha256": {{Name: "a", Password: "sha256:abcd"}},
+	} {
+		if _, err := New(users); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.json")
+	if err := ioutil.WriteFile(path, []byte(`{"users":[{"name":"web","tokens":["t"]}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", Bearer("t"))
+	if name, ok := a.Authenticate(r); !ok || name != "web" {
+		t.Errorf("got %q, %v", name, ok)
+	}
+}
+
+func TestMiddlewareAndTransport(t *testing.T) {
+	a, err := New([]User{{Name: "web", Tokens: []string{"t"}}, {Name: "ops", Tokens: []string{"o"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, _ := Identity(r.Context())
+		w.Write([]byte(name))
+	})))
+	defer srv.Close()
+
+	get := func(c *http.Client, r *http.Request) (int, string) {
+		resp, err := c.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	r, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if status, _ := get(http.DefaultClient, r); status != http.StatusUnauthorized {
+		t.Errorf("no credentials: got %d", status)
+	}
+	c := &http.Client{Transport: &Transport{Authorization: Bearer("t")}}
+	if status, body := get(c, r); status != http.StatusOK || body != "web" {
+		t.Errorf("transport: got %d %q", status, body)
+	}
+	if r.Header.Get("Authorization") != "" {
+		t.Error("transport modified the request")
+	}
+
+	// forwarded credentials are only sent to the servers ForwardTo
+	// allows, the transport's own going to the others
+	caller := httptest.NewRequest(http.MethodGet, "/", nil)
+	caller.Header.Set("Authorization", Bearer("o"))
+	if status, body := get(c, r.WithContext(Forward(r.Context(), caller))); status != http.StatusOK || body != "web" {
+		t.Errorf("forwarded to a server not allowed: got %d %q", status, body)
+	}
+
+	// where allowed, they replace the transport's, even when missing
+	c.Transport.(*Transport).ForwardTo = func(*http.Request) bool { return true }
+	if status, body := get(c, r.WithContext(Forward(r.Context(), caller))); status != http.StatusOK || body != "ops" {
+		t.Errorf("forwarded: got %d %q", status, body)
+	}
+	anonymous := httptest.NewRequest(http.MethodGet, "/", nil)
+	if status, _ := get(c, r.WithContext(Forward(r.Context(), anonymous))); status != http.StatusUnauthorized {
+		t.Errorf("forwarded without credentials: got %d", status)
+	}
+}
+
+func TestACL(t *testing.T) {
+	a, err := New([]User{
+		{Name: "admin"},
+		{Name: "reader", Commands: []Class{ClassRead}},
+		{Name: "web", Commands: []Class{ClassRead, ClassWrite}, Prefixes: []string{"web:", "shared:"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		class Class
+		keys  []string
+		want  bool
+	}{
+		{"admin", ClassFlush, nil, true},
+		{"admin", ClassWrite, []string{"any"}, true},
+		{"reader", ClassRead, []string{"any"}, true},
+		{"reader", ClassWrite, []string{"any"}, false},
+		{"reader", ClassStats, nil, false},
+		{"web", ClassWrite, []string{"web:1"}, true},
+		{"web", ClassRead, []string{"web:1", "shared:2"}, true},
+		{"web", ClassRead, []string{"web:1", "batch:2"}, false},
+		{"web", ClassFlush, nil, false},
+		{"nobody", ClassRead, []string{"web:1"}, false},
+	}
+	for _, tt := range tests {
+		if got := a.Allowed(tt.name, tt.class, tt.keys...); got != tt.want {
+			t.Errorf("%s %s %v: got %v, want %v", tt.name, tt.class, tt.keys, got, tt.want)
+		}
+	}
+	st := a.Stats()
+	if st["acl_denials"] != "5" || st["acl_denials_read"] != "2" || st["acl_denials_flush"] != "1" {
+		t.Errorf("stats: got %v", st)
+	}
+
+	if _, err := New([]User{{Name: "x", Commands: []Class{"everything"}}}); err == nil {
+		t.Error("unknown class: got no error")
+	}
+}
+
+func TestACLNamespaces(t *testing.T) {
+	a, err := New([]User{
+		{Name: "admin"},
+		{Name: "web", Prefixes: []string{"web:"}},
+		{Name: "sessions", Prefixes: []string{"web:"}, Namespaces: []string{"sessions"}},
+		{Name: "ops", Commands: []Class{ClassStats}, Namespaces: []string{AllNamespaces}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		class Class
+		ns    string
+		keys  []string
+		want  bool
+	}{
+		{"admin", ClassWrite, "sessions", []string{"any"}, true},
+		{"web", ClassRead, "", []string{"web:1"}, true},
+		{"web", ClassRead, "sessions", []string{"web:1"}, false},
+		{"sessions", ClassRead, "sessions", []string{"web:1"}, true},
+		{"sessions", ClassRead, "sessions", []string{"ops:1"}, false},
+		{"sessions", ClassRead, "batch", []string{"web:1"}, false},
+		{"ops", ClassStats, "batch", nil, true},
+	}
+	for _, tt := range tests {
+		if got := a.AllowedIn(tt.name, tt.class, tt.ns, tt.keys...); got != tt.want {
+			t.Errorf("%s %s %s %v: got %v, want %v", tt.name, tt.class, tt.ns, tt.keys, got, tt.want)
+		}
+	}
+}
+
+func TestRequire(t *testing.T) {
+	a, err := New([]User{{Name: "web", Tokens: []string{"t"}, Prefixes: []string{"web:"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.Middleware(a.Require(ClassRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	for target, want := range map[string]int{
+		"/mget?key=web:1&key=web:2":    http.StatusOK,
+		"/mget?key=web:1&key=ops:2":    http.StatusForbidden,
+		"/get/x?:key=web:1":            http.StatusOK,
+		"/get/x?:key=ops:1":            http.StatusForbidden,
+		"/ns/b/get/x?:ns=b&:key=web:1": http.StatusForbidden,
+	} {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Authorization", Bearer("t"))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("%s: got %d, want %d", target, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+// Transport adds credentials to outgoing requests, e.g. those a server
+// makes to its primary or peers
+type Transport struct {
+	// Authorization is the header sent, see Bearer and Basic
+	Authorization string
+	// Base sends the requests, http.DefaultTransport if nil
+	Base http.RoundTripper
+	// ForwardTo reports whether credentials forwarded with Forward may
+	// be sent to the request's server. If it's nil, or they may not, the
+	// Transport's own are sent instead
+	ForwardTo func(r *http.Request) bool
+}
+
+// Bearer returns the Authorization header value for a token, empty if
+// the token is, so that no header is sent
+func Bearer(token string) string {
+	if token == "" {
+		return ""
+	}
+	return "Bearer " + token
+}
+
+// Basic returns the Authorization header value for a user and password
+func Basic(user, password string) string {
+	r := &http.Request{Header: make(http.Header)}
+	r.SetBasicAuth(user, password)
+	return r.Header.Get("Authorization")
+}
+
+type forwardedKey struct{}
+
+// Forward returns a copy of ctx carrying the credentials of r, which
+// Transport sends instead of its own to the servers its ForwardTo
+// allows. Requests made on behalf of a caller, e.g. by a proxy, are so
+// authorized as the caller, or not at all
+func Forward(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, forwardedKey{}, r.Header.Get("Authorization"))
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	authorization := t.Authorization
+	if forwarded, ok := r.Context().Value(forwardedKey{}).(string); ok && t.ForwardTo != nil && t.ForwardTo(r) {
+		authorization = forwarded
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if authorization == "" {
+		return base.RoundTrip(r)
+	}
+	// a RoundTripper mustn't modify the caller's request
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", authorization)
+	return base.RoundTrip(r)
+}
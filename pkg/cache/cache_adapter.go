@@ -1,12 +1,10 @@
 package cache
 
 import (
+	"fmt"
 	"strconv"
 	"sync"
-
-	lfu "github.com/nagamocha3000/go-memcached/pkg/cache/lfu_cache"
-	lfuLruT "github.com/nagamocha3000/go-memcached/pkg/cache/lfu_lru_t_cache"
-	lru "github.com/nagamocha3000/go-memcached/pkg/cache/lru_cache"
+	"time"
 )
 
 //Token ...
@@ -14,65 +12,245 @@ type Token string
 
 //Adapter ...
 type Adapter struct {
-	mu    *sync.Mutex
-	cache Cache
+	mu        *sync.Mutex
+	cache     Cache
+	cacheType string
+	capacity  int
+	opts      Options
+	stats     stats
+
+	listeners    []func(Mutation)
+	writeHook    func(Mutation) error
+	statsSources []func() map[string]string
+	leases       *leaseTable
+	grace        *graceTable
+	cas          *casTable
+	defaultTTL   int // seconds, for sets without an exptime
+	limits       Limits
+	purgedAt     int64 // Unix time expired entries were last removed
+}
+
+// stats are the adapter's command counters, guarded by mu
+type stats struct {
+	cmdGet       int64
+	getHits      int64
+	getMisses    int64
+	cmdSet       int64
+	cmdTouch     int64
+	deleteHits   int64
+	deleteMisses int64
 }
 
 //NewCache ...
-func NewCache(cacheType string, capacity int) Adapter {
-	var c Cache
-	switch cacheType {
-	case "lru":
-		c = lru.Constructor(capacity)
-	case "lfu":
-		c = lfu.Constructor(capacity)
-	case "lfu-lrt":
-		c = lfuLruT.Constructor(capacity)
+func NewCache(cacheType string, capacity int, opts Options) (*Adapter, error) {
+	cacheType, c, err := newPolicy(cacheType, capacity, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Adapter{
+		mu:        &sync.Mutex{},
+		cache:     c,
+		cacheType: cacheType,
+		capacity:  capacity,
+		opts:      opts,
+		limits:    Limits{MaxKeyLength: DefaultMaxKeyLength, MaxItemSize: DefaultMaxItemSize},
+		cas:       newCASTable(),
+	}, nil
+}
+
+// CacheType returns the name of the current cache policy
+func (cw *Adapter) CacheType() string {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.cacheType
+}
+
+// KeepCapacity has Migrate keep the current capacity
+const KeepCapacity = -1
+
+// Migrate moves the live contents of the cache into a new cache of the
+// given type and capacity, which replaces the current one. A capacity of
+// KeepCapacity keeps the current capacity, other capacities less than 1
+// being unlimited, and nil opts keep the current options. Remaining TTLs
+// and hints are preserved, frequency and recency information is
+// approximated by the order in which entries are moved. If the new
+// capacity is smaller, the entries the current policy would evict first
+// are lost
+func (cw *Adapter) Migrate(cacheType string, capacity int, opts Options) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if capacity == KeepCapacity {
+		capacity = cw.capacity
+	} else if capacity < 1 {
+		capacity = 0
+	}
+	if opts == nil {
+		opts = cw.opts
+	}
+	cacheType, c, err := newPolicy(cacheType, capacity, opts)
+	if err != nil {
+		return err
 	}
-	return Adapter{
-		mu:    &sync.Mutex{},
-		cache: c,
+	ranger, ok := cw.cache.(RangeCache)
+	if !ok {
+		return fmt.Errorf("cache type %q doesn't support iterating its entries", cw.cacheType)
 	}
+	hc, isHinted := c.(HintedCache)
+	now := time.Now().Unix()
+	ranger.Range(func(e Entry) bool {
+		exptime := 0
+		if e.Expire != 0 {
+			exptime = int(e.Expire - now)
+			if exptime < 1 {
+				return true
+			}
+		}
+		if isHinted {
+			hc.SetWithHints(e.Key, e.Value, exptime, e.Cost, e.Priority)
+		} else {
+			c.Set(e.Key, e.Value, exptime)
+		}
+		return true
+	})
+	cw.cache = c
+	cw.cacheType = cacheType
+	cw.capacity = capacity
+	cw.opts = opts
+	return nil
 }
 
-//Set ...
-func (cw *Adapter) Set(key, val, exptimeStr string) Reply {
+// set stores the entry, passing on hints if given and supported by the
+// underlying cache. If the policy can't hold it, or the write hook fails
+// and the entry is restored, the error is returned
+func (cw *Adapter) set(key, val string, exptime int, hints *Hints) error {
+	return cw.setEntry(key, val, exptime, hints, true)
+}
+
+// setEntry is set, only calling the write hook if persist is true
+func (cw *Adapter) setEntry(key, val string, exptime int, hints *Hints, persist bool) error {
+	if bc, ok := cw.cache.(BoundedCache); ok {
+		if err := bc.CheckSize(key, val); err != nil {
+			return err
+		}
+	}
+	if exptime < 0 && cw.defaultTTL > 0 {
+		exptime = cw.defaultTTL
+	}
+	var undo func()
+	if persist {
+		undo = cw.undoer(key)
+	}
+	cw.stats.cmdSet++
+	if cw.pastTTL(key) {
+		// a new value, rather than an update keeping the old expiry
+		cw.cache.Delete(key)
+	}
+	if hc, ok := cw.cache.(HintedCache); ok && hints != nil {
+		hc.SetWithHints(key, val, cw.graceExptime(exptime), hints.Cost, cw.priority(key, hints))
+	} else {
+		cw.cache.Set(key, val, cw.graceExptime(exptime))
+	}
+	cw.trackExpiry(key, exptime)
+	cw.written(key)
+	if cw.leases != nil {
+		cw.leases.invalidate(key)
+	}
+	if !persist {
+		cw.emitSet(key, val, exptime, hints)
+		return nil
+	}
+	return cw.commitSet(key, val, exptime, hints, undo)
+}
+
+// priority returns the priority key is to be set with, its current one
+// unless the hints give one. Callers hold mu
+func (cw *Adapter) priority(key string, hints *Hints) bool {
+	if hints.Priority != nil {
+		return *hints.Priority
+	}
+	if pc, ok := cw.cache.(PeekCache); ok {
+		e, _ := pc.Peek(key)
+		return e.Priority
+	}
+	return false
+}
+
+// Fill stores a value loaded from elsewhere on a miss, e.g. by a
+// read-through loader. Unlike Set it doesn't call the write hook, as
+// the value already is where it was loaded from, but it's still emitted
+func (cw *Adapter) Fill(key, val, exptimeStr string) Reply {
 	cw.mu.Lock()
 	defer cw.mu.Unlock()
+	if err := cw.checkItem(key, val); err != nil {
+		return ClientError(err.Error())
+	}
 	exptime, err := strconv.Atoi(exptimeStr)
 	if err != nil {
 		return ClientErrorReply
 	}
-	cw.cache.Set(key, val, exptime)
+	return setReply(cw.setEntry(key, val, exptime, nil, false))
+}
+
+// SetDefaultTTL sets the TTL of values stored without an exptime, i.e. a
+// negative one, rounded up to a second. Zero for none
+func (cw *Adapter) SetDefaultTTL(ttl time.Duration) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.defaultTTL = int((ttl + time.Second - 1) / time.Second)
+}
+
+// setReply is the reply to a successful store, or to a failed write hook
+func setReply(err error) Reply {
+	if err != nil {
+		return ServerError(err.Error())
+	}
 	return StoredReply
 }
 
+//Set ...
+func (cw *Adapter) Set(key, val, exptimeStr string, hints *Hints) Reply {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if err := cw.checkItem(key, val); err != nil {
+		return ClientError(err.Error())
+	}
+	exptime, err := strconv.Atoi(exptimeStr)
+	if err != nil {
+		return ClientErrorReply
+	}
+	return setReply(cw.set(key, val, exptime, hints))
+}
+
 //Add ...
-func (cw *Adapter) Add(key, val, exptimeStr string) Reply {
+func (cw *Adapter) Add(key, val, exptimeStr string, hints *Hints) Reply {
 	cw.mu.Lock()
 	defer cw.mu.Unlock()
+	if err := cw.checkItem(key, val); err != nil {
+		return ClientError(err.Error())
+	}
 	exptime, err := strconv.Atoi(exptimeStr)
 	if err != nil {
 		return ClientErrorReply
 	}
-	if cw.cache.Exists(key) {
+	if cw.cache.Exists(key) && !cw.pastTTL(key) {
 		return NotStoredReply
 	}
-	cw.cache.Set(key, val, exptime)
-	return StoredReply
+	return setReply(cw.set(key, val, exptime, hints))
 }
 
 //Replace ...
-func (cw *Adapter) Replace(key, val, exptimeStr string) Reply {
+func (cw *Adapter) Replace(key, val, exptimeStr string, hints *Hints) Reply {
 	cw.mu.Lock()
 	defer cw.mu.Unlock()
+	if err := cw.checkItem(key, val); err != nil {
+		return ClientError(err.Error())
+	}
 	exptime, err := strconv.Atoi(exptimeStr)
 	if err != nil {
 		return ClientErrorReply
 	}
-	if cw.cache.Exists(key) {
-		cw.cache.Set(key, val, exptime)
-		return StoredReply
+	if cw.cache.Exists(key) && !cw.pastTTL(key) {
+		return setReply(cw.set(key, val, exptime, hints))
 	}
 	return NotStoredReply
 }
@@ -92,54 +270,60 @@ func (cw *Adapter) Prepend(key, val, exptimeStr string) Reply {
 }
 
 func (cw *Adapter) appendPrependHelper(key, val, exptimeStr string, isAppend bool) Reply {
+	if err := cw.checkKey(key); err != nil {
+		return ClientError(err.Error())
+	}
 	currVal, exists := cw.cache.Get(key)
-	if exists == false {
+	if exists == false || cw.pastTTL(key) {
 		return NotStoredReply
 	}
+	if err := cw.checkItem(key, currVal+val); err != nil {
+		return ClientError(err.Error())
+	}
 	exptime, err := strconv.Atoi(exptimeStr)
 	if err != nil {
 		return ClientErrorReply
 	}
 	if isAppend {
-		cw.cache.Set(key, currVal+val, exptime)
-	} else { //is prepend
-		cw.cache.Set(key, val+currVal, exptime)
-	}
-
-	return StoredReply
+		return setReply(cw.set(key, currVal+val, exptime, nil))
+	} //is prepend
+	return setReply(cw.set(key, val+currVal, exptime, nil))
 }
 
-//Increment ...
-func (cw *Adapter) Increment(key, numStr string) Reply {
+//Increment returns the reply and the incremented value
+func (cw *Adapter) Increment(key, numStr string) (Reply, string) {
 	cw.mu.Lock()
 	defer cw.mu.Unlock()
 	return cw.incrDecrHelper(key, numStr, true)
 }
 
-//Decrement ...
-func (cw *Adapter) Decrement(key, numStr string) Reply {
+//Decrement returns the reply and the decremented value
+func (cw *Adapter) Decrement(key, numStr string) (Reply, string) {
 	cw.mu.Lock()
 	defer cw.mu.Unlock()
 	return cw.incrDecrHelper(key, numStr, false)
 }
 
 //Increment ...
-func (cw *Adapter) incrDecrHelper(key, val string, isAddition bool) Reply {
+func (cw *Adapter) incrDecrHelper(key, val string, isAddition bool) (Reply, string) {
+	if err := cw.checkKey(key); err != nil {
+		return ClientError(err.Error()), ""
+	}
 	currVal, exists := cw.cache.Get(key)
-	if exists == false {
-		return NotFoundReply
+	if exists == false || cw.pastTTL(key) {
+		return NotFoundReply, ""
 	}
 	if val == "" {
 		val = "1"
 	}
 	opNum, err := strconv.Atoi(val)
 	if err != nil {
-		return ClientErrorReply
+		return ClientErrorReply, ""
 	}
 
 	valNum, err := strconv.Atoi(currVal)
 	if err != nil {
-		return ClientErrorReply
+		return ClientErrorReply, ""
 	}
 	var result int
 	if isAddition {
@@ -147,55 +331,268 @@ func (cw *Adapter) incrDecrHelper(key, val string, isAddition bool) Reply {
 	} else {
 		result = valNum - opNum
 	}
-	cw.cache.Set(key, strconv.Itoa(result), 0)
-	if err != nil {
-		return NotStoredReply
+	resultStr := strconv.Itoa(result)
+	if err := cw.set(key, resultStr, 0, nil); err != nil {
+		return ServerError(err.Error()), ""
 	}
-	return StoredReply
-}
-
-//CompareAndSwap ...
-func (cw *Adapter) CompareAndSwap(key, val, exptimeStr string, casKey Token) Reply {
-	// exptime, err := strconv.Atoi(exptimeStr)
-	// if err != nil {
-	// 	return ClientErrorReply
-	// }
-	return NotImplementedReply
+	return StoredReply, resultStr
 }
 
 //Get ...
 func (cw *Adapter) Get(key string) (Reply, string) {
 	cw.mu.Lock()
 	defer cw.mu.Unlock()
-	val, exists := cw.cache.Get(key)
+	if err := cw.checkKey(key); err != nil {
+		return ClientError(err.Error()), ""
+	}
+	val, exists := cw.get(key)
 	if exists == false {
 		return NotFoundReply, ""
 	}
+	if cw.pastTTL(key) {
+		return cw.staleReply(key), val
+	}
 	return ValueReply, val
 }
 
-//GetEntryPlusToken ...
-func (cw *Adapter) GetEntryPlusToken(key string) (Reply, string, Token) {
-	return NotImplementedReply, "", ""
+func (cw *Adapter) get(key string) (string, bool) {
+	cw.stats.cmdGet++
+	val, exists := cw.cache.Get(key)
+	if exists {
+		cw.stats.getHits++
+	} else {
+		cw.stats.getMisses++
+	}
+	return val, exists
+}
+
+// GetMulti returns the values of the keys that are present. Those past
+// their TTL are also in stale, with the reply Get would give them, so
+// that one client at a time is told to refresh each
+func (cw *Adapter) GetMulti(keys []string) (reply Reply, vals map[string]string, stale map[string]Reply) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	for _, key := range keys {
+		if err := cw.checkKey(key); err != nil {
+			return ClientError(err.Error()), nil, nil
+		}
+	}
+	vals = make(map[string]string, len(keys))
+	for _, key := range keys {
+		val, exists := cw.get(key)
+		if !exists {
+			continue
+		}
+		vals[key] = val
+		if cw.pastTTL(key) {
+			if stale == nil {
+				stale = make(map[string]Reply)
+			}
+			stale[key] = cw.staleReply(key)
+		}
+	}
+	return ValueReply, vals, stale
+}
+
+// Touch updates the expiry of an existing entry without changing its
+// value. An exptime of 0 leaves the current expiry as is
+func (cw *Adapter) Touch(key, exptimeStr string) Reply {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if err := cw.checkKey(key); err != nil {
+		return ClientError(err.Error())
+	}
+	exptime, err := strconv.Atoi(exptimeStr)
+	if err != nil {
+		return ClientErrorReply
+	}
+	cw.stats.cmdTouch++
+	val, exists := cw.cache.Get(key)
+	if exists == false || cw.pastTTL(key) {
+		return NotFoundReply
+	}
+	undo := cw.undoer(key)
+	cw.cache.Set(key, val, cw.graceExptime(exptime))
+	cw.trackExpiry(key, exptime)
+	if err := cw.commitSet(key, val, exptime, nil, undo); err != nil {
+		return ServerError(err.Error())
+	}
+	return TouchedReply
 }
 
 //Delete ...
 func (cw *Adapter) Delete(key string) Reply {
 	cw.mu.Lock()
 	defer cw.mu.Unlock()
+	if err := cw.checkKey(key); err != nil {
+		return ClientError(err.Error())
+	}
 	if cw.cache.Exists(key) {
-		cw.cache.Delete(key)
+		if err := cw.delete(key); err != nil {
+			return ServerError(err.Error())
+		}
+		cw.stats.deleteHits++
 		return DeletedReply
 	}
+	if cw.leases != nil {
+		// the key may be about to be filled from outdated data
+		delete(cw.leases.held, key)
+	}
+	cw.stats.deleteMisses++
 	return NotFoundReply
 }
 
-//Clear ...
+// delete removes the entry, restoring it if the write hook fails. With
+// leases in use, the value is kept to be served stale
+func (cw *Adapter) delete(key string) error {
+	undo := cw.undoer(key)
+	if cw.leases != nil {
+		cw.leases.invalidate(key)
+		if pc, ok := cw.cache.(PeekCache); ok {
+			if e, exists := pc.Peek(key); exists {
+				cw.leases.keepStale(key, e.Value)
+			}
+		}
+	}
+	cw.cache.Delete(key)
+	cw.forgetExpiry(key)
+	delete(cw.cas.uniques, key)
+	return cw.commit(Mutation{Op: DeleteMutation, Entry: Entry{Key: key}}, undo)
+}
+
+// Bytes returns the size of the unexpired keys and values held. ok is
+// false if the policy can't tell
+func (cw *Adapter) Bytes() (n int, ok bool) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.purgeExpired()
+	sc, ok := cw.cache.(SizedCache)
+	if !ok {
+		return 0, false
+	}
+	return sc.Bytes(), true
+}
+
+// Evict removes the expired entries, then, until n bytes are freed, the
+// entries the policy would evict first. Like the policy's own evictions,
+// they aren't mutations, so they're neither written through nor
+// replicated. It returns the bytes freed, and how many entries were
+// evicted besides the expired ones
+func (cw *Adapter) Evict(n int) (freed, evicted int) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	sc, ok := cw.cache.(SizedCache)
+	if !ok {
+		return 0, 0
+	}
+	before := sc.Bytes()
+	cw.purgeExpired()
+	ranger, ok := cw.cache.(RangeCache)
+	if !ok || before-sc.Bytes() >= n {
+		return before - sc.Bytes(), 0
+	}
+	need := n - (before - sc.Bytes())
+	var victims []string
+	ranger.Range(func(e Entry) bool {
+		victims = append(victims, e.Key)
+		need -= len(e.Key) + len(e.Value)
+		return need > 0
+	})
+	for _, key := range victims {
+		cw.remove(key)
+	}
+	return before - sc.Bytes(), len(victims)
+}
+
+// remove drops the entry along with what's kept about it, without it
+// being a mutation. Callers hold mu
+func (cw *Adapter) remove(key string) {
+	cw.cache.Delete(key)
+	cw.forgetExpiry(key)
+	delete(cw.cas.uniques, key)
+}
+
+// purgeExpired removes the expired entries, at most once a second as
+// expiry times are in seconds, and returns how many there were. Callers
+// hold mu
+func (cw *Adapter) purgeExpired() int {
+	ec, ok := cw.cache.(ExpiringCache)
+	now := time.Now().Unix()
+	if !ok || cw.purgedAt == now {
+		return 0
+	}
+	cw.purgedAt = now
+	keys := ec.RemoveExpired()
+	for _, key := range keys {
+		cw.forgetExpiry(key)
+	}
+	return len(keys)
+}
+
+//Clear removes every entry by replacing the cache with an empty one of
+//the same policy
 func (cw *Adapter) Clear() Reply {
-	return NotImplementedReply
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.clear()
+}
+
+func (cw *Adapter) clear() Reply {
+	_, c, err := newPolicy(cw.cacheType, cw.capacity, cw.opts)
+	if err != nil {
+		return ErrReply
+	}
+	cw.cache = c
+	cw.cas.uniques = make(map[string]uint64)
+	if cw.leases != nil {
+		cw.leases.reset()
+	}
+	if cw.grace != nil {
+		cw.grace.softExpire = make(map[string]int64)
+		cw.grace.refreshing = make(map[string]time.Time)
+	}
+	cw.emit(Mutation{Op: ClearMutation})
+	return OkReply
 }
 
-//Stats ...
-func (cw *Adapter) Stats() Reply {
-	return NotImplementedReply
+//Stats returns the command counters and the state of the cache
+func (cw *Adapter) Stats() (Reply, map[string]string) {
+	cw.mu.Lock()
+	st := map[string]string{
+		"cache_type":    cw.cacheType,
+		"capacity":      strconv.Itoa(cw.capacity),
+		"cmd_get":       strconv.FormatInt(cw.stats.cmdGet, 10),
+		"get_hits":      strconv.FormatInt(cw.stats.getHits, 10),
+		"get_misses":    strconv.FormatInt(cw.stats.getMisses, 10),
+		"cmd_set":       strconv.FormatInt(cw.stats.cmdSet, 10),
+		"cmd_touch":     strconv.FormatInt(cw.stats.cmdTouch, 10),
+		"delete_hits":   strconv.FormatInt(cw.stats.deleteHits, 10),
+		"delete_misses": strconv.FormatInt(cw.stats.deleteMisses, 10),
+	}
+	cw.purgeExpired()
+	if lc, ok := cw.cache.(LenCache); ok {
+		st["curr_items"] = strconv.Itoa(lc.Len())
+	}
+	if sc, ok := cw.cache.(SizedCache); ok {
+		st["bytes"] = strconv.Itoa(sc.Bytes())
+	}
+	cw.limits.addStats(st)
+	if cw.defaultTTL > 0 {
+		st["default_ttl"] = strconv.Itoa(cw.defaultTTL)
+	}
+	cw.cas.addStats(st)
+	if cw.leases != nil {
+		cw.leases.addStats(st)
+	}
+	if cw.grace != nil {
+		cw.grace.addStats(st)
+	}
+	sources := cw.statsSources
+	cw.mu.Unlock()
+	for _, fn := range sources {
+		for name, val := range fn() {
+			st[name] = val
+		}
+	}
+	return OkReply, st
 }
@@ -0,0 +1,319 @@
+package cache_test
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	_ "github.com/nagamocha3000/go-memcached/pkg/cache/policies"
+)
+
+func TestNewCacheUnknownType(t *testing.T) {
+	_, err := cache.NewCache("lfu-lru", 10, nil)
+	if !errors.Is(err, cache.ErrUnknownCacheType) {
+		t.Errorf("got %v, want ErrUnknownCacheType", err)
+	}
+	c, err := cache.NewCache("lfu-lru-t", 10, nil)
+	if err != nil || c.CacheType() != "lfu-lrt" {
+		t.Errorf("expected alias to resolve to lfu-lrt, got %v", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	c, err := cache.NewCache("lru", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priority := true
+	c.Set("a", "1", "100", nil)
+	c.Set("b", "2", "-1", &cache.Hints{Priority: &priority})
+	if err := c.Migrate("gdsf", cache.KeepCapacity, cache.Options{"maxBytes": "1024"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.CacheType() != "gdsf" {
+		t.Errorf("got cache type %q, want gdsf", c.CacheType())
+	}
+	for key, want := range map[string]string{"a": "1", "b": "2"} {
+		if reply, val := c.Get(key); reply != cache.ValueReply || val != want {
+			t.Errorf("get %q: got %v %q, want %q", key, reply, val, want)
+		}
+	}
+	if _, st := c.Stats(); st["capacity"] != "10" {
+		t.Errorf("got capacity %s, want 10 kept", st["capacity"])
+	}
+	if err := c.Migrate("nope", cache.KeepCapacity, nil); err == nil {
+		t.Errorf("expected error migrating to unknown cache type")
+	}
+}
+
+func TestMigrateToUnlimited(t *testing.T) {
+	c, err := cache.NewCache("lru", 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Migrate("lru", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		c.Set(strconv.Itoa(i), "v", "0", nil)
+	}
+	if _, st := c.Stats(); st["capacity"] != "0" || st["curr_items"] != "5" {
+		t.Errorf("got capacity %s holding %s items, want 0 holding 5", st["capacity"], st["curr_items"])
+	}
+}
+
+func TestSetOverPolicyMaxBytes(t *testing.T) {
+	c, err := cache.NewCache("gdsf", 0, cache.Options{"maxBytes": "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply := c.Set("a", "0123456789", "-1", nil); !strings.HasPrefix(string(reply), string(cache.ServerErrorReply)) {
+		t.Errorf("got %v, want a server error", reply)
+	}
+	if reply, _ := c.Get("a"); reply != cache.NotFoundReply {
+		t.Errorf("get: got %v, want not found", reply)
+	}
+}
+
+func TestHintsKeepPriority(t *testing.T) {
+	c, err := cache.NewCache("lru", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hints := range [][2]string{{"", "true"}, {"5", ""}, {"", ""}} {
+		h, ok := cache.ParseHints(hints[0], hints[1])
+		if !ok {
+			t.Fatalf("ParseHints(%q, %q) failed", hints[0], hints[1])
+		}
+		c.Set("a", "1", "0", h)
+		entries, _ := c.Snapshot(nil)
+		if len(entries) != 1 || !entries[0].Priority {
+			t.Errorf("after a set with cost %q and priority %q: got %+v, want a kept priority", hints[0], hints[1], entries)
+		}
+	}
+	h, _ := cache.ParseHints("", "false")
+	c.Set("a", "1", "0", h)
+	if entries, _ := c.Snapshot(nil); len(entries) != 1 || entries[0].Priority {
+		t.Errorf("after a set with priority false: got %+v", entries)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	opts, err := cache.ParseOptions("maxFrequency=16, decayInterval = 100,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := opts.Int("decayInterval", 0); n != 100 {
+		t.Errorf("got decayInterval %d, want 100", n)
+	}
+	if n, _ := opts.Int("maxBytes", 7); n != 7 {
+		t.Errorf("got default %d, want 7", n)
+	}
+	if _, err := cache.ParseOptions("maxFrequency"); err == nil {
+		t.Errorf("expected error for option without value")
+	}
+	if _, err := cache.NewCache("lfu", 10, cache.Options{"maxFrequency": "x"}); err == nil {
+		t.Errorf("expected error for invalid option value")
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	c, err := cache.NewCache("lru", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply := c.CompareAndSwap("a", "1", "0", "1"); reply != cache.NotFoundReply {
+		t.Errorf("cas of a missing key: got %s", reply)
+	}
+	c.Set("a", "1", "0", nil)
+	reply, val, token := c.GetEntryPlusToken("a")
+	if reply != cache.ValueReply || val != "1" || token == "" {
+		t.Fatalf("gets: got %s %q %q", reply, val, token)
+	}
+	if _, _, again := c.GetEntryPlusToken("a"); again != token {
+		t.Errorf("gets changed the token from %q to %q", token, again)
+	}
+	if reply := c.CompareAndSwap("a", "2", "0", token); reply != cache.StoredReply {
+		t.Errorf("cas with the token: got %s", reply)
+	}
+	if reply := c.CompareAndSwap("a", "3", "0", token); reply != cache.ExistsReply {
+		t.Errorf("cas after a write: got %s", reply)
+	}
+	_, _, token = c.GetEntryPlusToken("a")
+	c.Increment("a", "1")
+	if reply := c.CompareAndSwap("a", "4", "0", token); reply != cache.ExistsReply {
+		t.Errorf("cas after an increment: got %s", reply)
+	}
+	if _, val := c.Get("a"); val != "3" {
+		t.Errorf("got %q, want the incremented 3", val)
+	}
+	_, st := c.Stats()
+	if st["cas_hits"] != "1" || st["cas_badval"] != "2" || st["cas_misses"] != "1" {
+		t.Errorf("stats: got %v", st)
+	}
+}
+
+func TestLeases(t *testing.T) {
+	c, err := cache.NewCache("lru", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, _, token, _ := c.GetWithLease("a")
+	if reply != cache.NotFoundReply || token == "" {
+		t.Fatalf("expected a lease on a miss, got %s %q", reply, token)
+	}
+	if reply, _, other, _ := c.GetWithLease("a"); reply != cache.HotMissReply || other != "" {
+		t.Errorf("expected a hot miss while the lease is held, got %s %q", reply, other)
+	}
+	if reply := c.SetWithLease("a", "1", "0", "bogus", nil); reply != cache.NotStoredReply {
+		t.Errorf("expected a set with the wrong token to be refused, got %s", reply)
+	}
+	if reply := c.SetWithLease("a", "1", "0", token, nil); reply != cache.StoredReply {
+		t.Errorf("expected a set with the lease to be stored, got %s", reply)
+	}
+	if reply := c.SetWithLease("a", "2", "0", token, nil); reply != cache.NotStoredReply {
+		t.Errorf("expected the lease to be used up, got %s", reply)
+	}
+
+	// a delete invalidates the lease, and the old value is served stale
+	c.Delete("a")
+	_, _, token, _ = c.GetWithLease("a")
+	c.Delete("a")
+	if reply := c.SetWithLease("a", "3", "0", token, nil); reply != cache.NotStoredReply {
+		t.Errorf("expected a set after a delete to be refused, got %s", reply)
+	}
+	reply, val, _, stale := c.GetWithLease("a")
+	if val != "1" || !stale {
+		t.Errorf("expected the deleted value to be served stale, got %s %q %v", reply, val, stale)
+	}
+}
+
+func TestLeaseExpires(t *testing.T) {
+	c, err := cache.NewCache("lru", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetLeaseOptions(cache.LeaseOptions{TTL: 50 * time.Millisecond})
+	_, _, token, _ := c.GetWithLease("a")
+	time.Sleep(100 * time.Millisecond)
+	if reply := c.SetWithLease("a", "1", "0", token, nil); reply != cache.NotStoredReply {
+		t.Errorf("expected a set with an expired lease to be refused, got %s", reply)
+	}
+	if _, st := c.Stats(); st["lease_rejected_sets"] != "1" {
+		t.Errorf("got %s rejected sets, want 1", st["lease_rejected_sets"])
+	}
+}
+
+func TestGracePeriod(t *testing.T) {
+	c, err := cache.NewCache("lfu", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetGraceOptions(cache.GraceOptions{Period: time.Minute, RefreshTimeout: time.Minute})
+	c.Set("a", "1", "1", nil)
+	if reply, val := c.Get("a"); reply != cache.ValueReply || val != "1" {
+		t.Fatalf("expected a fresh value, got %s %q", reply, val)
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	if reply, val := c.Get("a"); reply != cache.RefreshReply || val != "1" {
+		t.Errorf("expected the first get past the TTL to be told to refresh, got %s %q", reply, val)
+	}
+	if reply, val := c.Get("a"); reply != cache.StaleReply || val != "1" {
+		t.Errorf("expected later gets to be served stale, got %s %q", reply, val)
+	}
+	if reply, _ := c.Increment("a", "1"); reply != cache.NotFoundReply {
+		t.Errorf("expected other commands to treat the value as missing, got %s", reply)
+	}
+	if reply := c.Add("a", "2", "-1", nil); reply != cache.StoredReply {
+		t.Errorf("expected add to replace the stale value, got %s", reply)
+	}
+	if reply, val := c.Get("a"); reply != cache.ValueReply || val != "2" {
+		t.Errorf("expected the refreshed value, got %s %q", reply, val)
+	}
+}
+
+func TestGracePeriodGetMulti(t *testing.T) {
+	c, err := cache.NewCache("lru", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetGraceOptions(cache.GraceOptions{Period: time.Minute, RefreshTimeout: time.Minute})
+	c.Set("a", "1", "1", nil)
+	c.Set("b", "2", "0", nil)
+	time.Sleep(1100 * time.Millisecond)
+
+	for i, want := range []cache.Reply{cache.RefreshReply, cache.StaleReply} {
+		reply, vals, stale := c.GetMulti([]string{"a", "b", "c"})
+		if reply != cache.ValueReply || len(vals) != 2 || vals["a"] != "1" || vals["b"] != "2" {
+			t.Fatalf("mget %d: got %s %v", i, reply, vals)
+		}
+		if len(stale) != 1 || stale["a"] != want {
+			t.Errorf("mget %d: got stale %v, want a %s", i, stale, want)
+		}
+	}
+	if reply, _ := c.Get("a"); reply != cache.StaleReply {
+		t.Errorf("expected a get after the mget to be served stale, got %s", reply)
+	}
+}
+
+func TestGracePeriodLeases(t *testing.T) {
+	c, err := cache.NewCache("lru", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetGraceOptions(cache.GraceOptions{Period: time.Minute, RefreshTimeout: time.Minute})
+	c.Set("a", "1", "1", nil)
+	time.Sleep(1100 * time.Millisecond)
+
+	reply, val, token, stale := c.GetWithLease("a")
+	if reply != cache.RefreshReply || val != "1" || token == "" || !stale {
+		t.Fatalf("expected a refresh lease on the value past its TTL, got %s %q %q %v", reply, val, token, stale)
+	}
+	if reply, val, other, stale := c.GetWithLease("a"); reply != cache.StaleReply || val != "1" || other != "" || !stale {
+		t.Errorf("expected others to be served stale, got %s %q %q %v", reply, val, other, stale)
+	}
+	if reply, _ := c.Get("a"); reply != cache.StaleReply {
+		t.Errorf("expected gets to be served stale while refreshing, got %s", reply)
+	}
+	if reply := c.SetWithLease("a", "2", "0", token, nil); reply != cache.StoredReply {
+		t.Fatalf("expected the refresh to be stored, got %s", reply)
+	}
+	if reply, val, _, stale := c.GetWithLease("a"); reply != cache.ValueReply || val != "2" || stale {
+		t.Errorf("expected the refreshed value, got %s %q %v", reply, val, stale)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	c, err := cache.NewCache("lru", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("k", cache.DefaultMaxKeyLength+1)
+	if reply := c.Set(long, "v", "0", nil); reply != cache.ClientError("key of 251 bytes is over the 250 byte limit") {
+		t.Errorf("set of a long key: got %s", reply)
+	}
+	if reply, _ := c.Get("a\nb"); reply != cache.ClientError("key contains control character 0x0a at byte 1") {
+		t.Errorf("get of a key with a newline: got %s", reply)
+	}
+	if reply, _, _ := c.GetMulti([]string{"a", ""}); reply != cache.ClientError("empty key") {
+		t.Errorf("get of an empty key: got %s", reply)
+	}
+
+	c.SetLimits(cache.Limits{MaxKeyLength: 4, MaxItemSize: 8})
+	if reply := c.Set("abcde", "v", "0", nil); reply != cache.ClientError("key of 5 bytes is over the 4 byte limit") {
+		t.Errorf("set past the configured key length: got %s", reply)
+	}
+	if reply := c.Set("k", "1234567", "0", nil); reply != cache.StoredReply {
+		t.Errorf("set of an item at the limit: got %s", reply)
+	}
+	if reply := c.Append("k", "8", "0"); reply != cache.ClientError("item of 9 bytes is over the 8 byte limit") {
+		t.Errorf("append past the item size: got %s", reply)
+	}
+	if _, val := c.Get("k"); val != "1234567" {
+		t.Errorf("value changed by a refused append: got %q", val)
+	}
+}
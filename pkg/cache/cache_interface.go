@@ -7,3 +7,65 @@ type Cache interface {
 	Exists(string) bool
 	Delete(string)
 }
+
+// HintedCache is implemented by policies that take per-item hints into
+// account on eviction. Priority entries must not be evicted before normal
+// ones, cost may be ignored by policies that don't weigh it
+type HintedCache interface {
+	Cache
+	SetWithHints(key, value string, exptime, cost int, priority bool)
+}
+
+// Entry is an item as stored by a policy
+type Entry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// Expire is a Unix time, zero for none
+	Expire   int64 `json:"expire,omitempty"`
+	Cost     int   `json:"cost,omitempty"`
+	Priority bool  `json:"priority,omitempty"`
+}
+
+// RangeCache is implemented by policies that can iterate their unexpired
+// entries, least valuable first
+type RangeCache interface {
+	Cache
+	Range(fn func(Entry) bool)
+}
+
+// PeekCache is implemented by policies that can return an unexpired
+// entry without counting it as used
+type PeekCache interface {
+	Cache
+	Peek(key string) (Entry, bool)
+}
+
+// LenCache is implemented by policies that can report how many entries
+// they hold
+type LenCache interface {
+	Cache
+	Len() int
+}
+
+// SizedCache is implemented by policies that can report the size in
+// bytes of the keys and values they hold
+type SizedCache interface {
+	Cache
+	Bytes() int
+}
+
+// BoundedCache is implemented by policies that can't hold entries past
+// some size. CheckSize returns why the key and value can't be held, if
+// they can't
+type BoundedCache interface {
+	Cache
+	CheckSize(key, value string) error
+}
+
+// ExpiringCache is implemented by policies that can remove their expired
+// entries at once, rather than as they're come across. It returns the
+// keys removed
+type ExpiringCache interface {
+	Cache
+	RemoveExpired() []string
+}
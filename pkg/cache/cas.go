@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"strconv"
+	"time"
+)
+
+// casTable holds the CAS unique of each entry, which every write of the
+// entry changes, guarded by the adapter's mu. Uniques of entries the
+// policy evicted are dropped in sweeps
+type casTable struct {
+	uniques map[string]uint64
+	next    uint64
+	writes  int
+
+	hits   int64
+	misses int64
+	badval int64
+}
+
+func newCASTable() *casTable {
+	return &casTable{
+		uniques: make(map[string]uint64),
+		// uniques from before a restart are never valid again
+		next: uint64(time.Now().UnixNano()),
+	}
+}
+
+// written gives key a new unique after a write. Callers hold mu
+func (cw *Adapter) written(key string) {
+	ct := cw.cas
+	ct.next++
+	ct.uniques[key] = ct.next
+	ct.writes++
+	if ct.writes < sweepEvery {
+		return
+	}
+	ct.writes = 0
+	for key := range ct.uniques {
+		if !cw.cache.Exists(key) {
+			delete(ct.uniques, key)
+		}
+	}
+}
+
+// unique returns key's unique, giving it one if it has none, e.g. after
+// a migration. Callers hold mu
+func (cw *Adapter) unique(key string) Token {
+	ct := cw.cas
+	u, ok := ct.uniques[key]
+	if !ok {
+		ct.next++
+		u = ct.next
+		ct.uniques[key] = u
+	}
+	return Token(strconv.FormatUint(u, 10))
+}
+
+// GetEntryPlusToken gets the value of key along with its CAS unique, to
+// be given to CompareAndSwap
+func (cw *Adapter) GetEntryPlusToken(key string) (Reply, string, Token) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if err := cw.checkKey(key); err != nil {
+		return ClientError(err.Error()), "", ""
+	}
+	val, exists := cw.get(key)
+	if exists == false {
+		return NotFoundReply, "", ""
+	}
+	if cw.pastTTL(key) {
+		return cw.staleReply(key), val, cw.unique(key)
+	}
+	return ValueReply, val, cw.unique(key)
+}
+
+// CompareAndSwap sets the value of key if it hasn't been written since
+// its CAS unique, casKey, was got with GetEntryPlusToken. The reply is
+// EXISTS if it has, NOT_FOUND if the key is missing
+func (cw *Adapter) CompareAndSwap(key, val, exptimeStr string, casKey Token) Reply {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if err := cw.checkItem(key, val); err != nil {
+		return ClientError(err.Error())
+	}
+	exptime, err := strconv.Atoi(exptimeStr)
+	if err != nil {
+		return ClientErrorReply
+	}
+	if !cw.cache.Exists(key) || cw.pastTTL(key) {
+		cw.cas.misses++
+		return NotFoundReply
+	}
+	if casKey != cw.unique(key) {
+		cw.cas.badval++
+		return ExistsReply
+	}
+	cw.cas.hits++
+	return setReply(cw.set(key, val, exptime, nil))
+}
+
+func (ct *casTable) addStats(st map[string]string) {
+	st["cas_hits"] = strconv.FormatInt(ct.hits, 10)
+	st["cas_misses"] = strconv.FormatInt(ct.misses, 10)
+	st["cas_badval"] = strconv.FormatInt(ct.badval, 10)
+}
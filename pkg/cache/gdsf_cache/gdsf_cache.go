@@ -0,0 +1,368 @@
+package gdsf
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+)
+
+/*
+GdsfCache implements the GreedyDual-Size-Frequency policy. Each entry is
+given a priority
+
+	H = L + frequency * cost / size
+
+where L is an inflation value ("clock") that's set to the priority of the
+last evicted entry. Entries with the lowest priority are evicted first, so
+small, frequently used or expensive entries are kept over large, rarely used
+or cheap ones. Since L only increases, entries that were hot long ago age
+out relative to those accessed recently.
+Entries are kept in a min-heap ordered by priority, hence Get, Set and
+eviction are O(log n). Entries set with the priority hint are kept in a
+separate heap and are only evicted once there are no normal entries left.
+*/
+
+type entry struct {
+	key       string
+	value     string
+	expire    int64 // Unix time
+	frequency int
+	cost      int
+	priority  float64
+	index     int // position in the heap
+	tier      int
+}
+
+const (
+	normalTier = iota
+	priorityTier
+	numTiers
+)
+
+func tierOf(priority bool) int {
+	if priority {
+		return priorityTier
+	}
+	return normalTier
+}
+
+// bytes returns the size of the key and value
+func (e *entry) bytes() int {
+	return len(e.key) + len(e.value)
+}
+
+// size returns the size the priority is computed from, at least 1
+func (e *entry) size() int {
+	if n := e.bytes(); n > 0 {
+		return n
+	}
+	return 1
+}
+
+type priorityQueue []*entry
+
+func (pq priorityQueue) Len() int { return len(pq) }
+
+func (pq priorityQueue) Less(i, j int) bool {
+	return pq[i].priority < pq[j].priority
+}
+
+func (pq priorityQueue) Swap(i, j int) {
+	pq[i], pq[j] = pq[j], pq[i]
+	pq[i].index = i
+	pq[j].index = j
+}
+
+func (pq *priorityQueue) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*pq)
+	*pq = append(*pq, e)
+}
+
+func (pq *priorityQueue) Pop() interface{} {
+	old := *pq
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*pq = old[:n-1]
+	return e
+}
+
+// Options configures GdsfCache
+type Options struct {
+	// MaxBytes caps the total size of keys plus values. Zero for unlimited
+	MaxBytes int
+}
+
+// GdsfCache ...
+type GdsfCache struct {
+	kvStore  map[string]*entry
+	pq       [numTiers]priorityQueue
+	capacity int
+	maxBytes int
+	bytes    int
+	clock    float64
+}
+
+// Constructor ...
+func Constructor(capacity int) *GdsfCache {
+	return ConstructorWithOptions(capacity, Options{})
+}
+
+// ConstructorWithOptions ...
+func ConstructorWithOptions(capacity int, opts Options) *GdsfCache {
+	if capacity < 1 {
+		capacity = math.MaxInt64
+	}
+	maxBytes := opts.MaxBytes
+	if maxBytes < 1 {
+		maxBytes = math.MaxInt64
+	}
+	return &GdsfCache{
+		kvStore:  make(map[string]*entry),
+		capacity: capacity,
+		maxBytes: maxBytes,
+	}
+}
+
+// Len returns the number of entries, including expired ones that
+// haven't been removed yet
+func (c *GdsfCache) Len() int {
+	return len(c.kvStore)
+}
+
+// Bytes returns the size of the keys and values held, including expired
+// ones that haven't been removed yet
+func (c *GdsfCache) Bytes() int {
+	return c.bytes
+}
+
+// CheckSize returns an error if the key and value are too big to be
+// held at all, which Set would drop
+func (c *GdsfCache) CheckSize(key, value string) error {
+	if n := len(key) + len(value); n > c.maxBytes {
+		return fmt.Errorf("item of %d bytes is over the cache's %d bytes", n, c.maxBytes)
+	}
+	return nil
+}
+
+// Exists returns true if entry with given key exists, else false
+func (c *GdsfCache) Exists(key string) bool {
+	_, isPresent := c.kvStore[key]
+	return isPresent
+}
+
+// Set entry from given key-value plus add expiry. New entries have a cost
+// of 1 and no priority, existing ones keep theirs
+func (c *GdsfCache) Set(key, value string, exptime int) {
+	tier := normalTier
+	if e, isPresent := c.kvStore[key]; isPresent {
+		tier = e.tier
+	}
+	c.set(key, value, exptime, 0, tier)
+}
+
+// SetWithHints is like Set but also records the cost of recomputing the
+// value and whether the entry has priority. Costs less than 1 leave an
+// existing entry's cost unchanged
+func (c *GdsfCache) SetWithHints(key, value string, exptime, cost int, priority bool) {
+	c.set(key, value, exptime, cost, tierOf(priority))
+}
+
+func (c *GdsfCache) set(key, value string, exptime int, cost int, tier int) {
+	var expire int64 = 0
+	if exptime > 0 {
+		expire = time.Now().Unix() + int64(exptime)
+	}
+	e, isPresent := c.kvStore[key]
+	if isPresent { //is update
+		if isExpired := c.checkIfExpired(e); isExpired {
+			return
+		}
+		if len(key)+len(value) > c.maxBytes {
+			c.remove(e)
+			return
+		}
+		c.bytes -= e.bytes()
+		e.value = value
+		c.bytes += e.bytes()
+		// during update, only update expire val if exptime g.t. 0
+		if exptime > 0 {
+			e.expire = expire
+		}
+		if cost > 0 {
+			e.cost = cost
+		}
+		e.frequency++
+		c.prioritize(e)
+		if e.tier != tier {
+			heap.Remove(&c.pq[e.tier], e.index)
+			e.tier = tier
+			heap.Push(&c.pq[e.tier], e)
+		} else {
+			heap.Fix(&c.pq[e.tier], e.index)
+		}
+		c.evictExtra(e)
+		return
+	}
+	//new entry
+	if cost < 1 {
+		cost = 1
+	}
+	e = &entry{
+		key:       key,
+		value:     value,
+		expire:    expire,
+		frequency: 1,
+		cost:      cost,
+		tier:      tier,
+	}
+	if e.bytes() > c.maxBytes {
+		return
+	}
+	for len(c.kvStore) >= c.capacity {
+		c.evict()
+	}
+	c.prioritize(e)
+	c.kvStore[key] = e
+	c.bytes += e.bytes()
+	heap.Push(&c.pq[e.tier], e)
+	c.evictExtra(e)
+}
+
+// prioritize recomputes the entry's priority from the current clock
+func (c *GdsfCache) prioritize(e *entry) {
+	e.priority = c.clock + float64(e.frequency)*float64(e.cost)/float64(e.size())
+}
+
+// evictExtra evicts entries until the total size fits in maxBytes,
+// never evicting keep, the entry that was just written
+func (c *GdsfCache) evictExtra(keep *entry) {
+	if c.bytes <= c.maxBytes {
+		return
+	}
+	// take keep out of its heap while evicting
+	heap.Remove(&c.pq[keep.tier], keep.index)
+	for c.bytes > c.maxBytes && len(c.kvStore) > 1 {
+		c.evict()
+	}
+	heap.Push(&c.pq[keep.tier], keep)
+}
+
+// nextTier returns the tier that's evicted from next
+func (c *GdsfCache) nextTier() int {
+	for tier, pq := range c.pq {
+		if len(pq) > 0 {
+			return tier
+		}
+	}
+	return normalTier
+}
+
+// evict removes the entry with the lowest priority, normal entries
+// first, and inflates the clock
+func (c *GdsfCache) evict() {
+	pq := &c.pq[c.nextTier()]
+	if len(*pq) == 0 {
+		return
+	}
+	e := heap.Pop(pq).(*entry)
+	c.clock = e.priority
+	c.bytes -= e.bytes()
+	delete(c.kvStore, e.key)
+}
+
+// Get entry by given key
+func (c *GdsfCache) Get(key string) (string, bool) {
+	e, isPresent := c.kvStore[key]
+	if isPresent == false {
+		return "", false
+	}
+	if isExpired := c.checkIfExpired(e); isExpired {
+		return "", false
+	}
+	e.frequency++
+	c.prioritize(e)
+	heap.Fix(&c.pq[e.tier], e.index)
+	return e.value, true
+}
+
+// returns true and deletes entry if is expired, else false
+func (c *GdsfCache) checkIfExpired(e *entry) bool {
+	if e.expire != 0 && e.expire <= time.Now().Unix() {
+		c.remove(e)
+		return true
+	}
+	return false
+}
+
+func (c *GdsfCache) remove(e *entry) {
+	heap.Remove(&c.pq[e.tier], e.index)
+	c.bytes -= e.bytes()
+	delete(c.kvStore, e.key)
+}
+
+// Range calls fn for every unexpired entry, from the lowest to the
+// highest priority, normal entries before priority ones. Stops if fn
+// returns false. The entries mustn't be modified during Range
+func (c *GdsfCache) Range(fn func(cache.Entry) bool) {
+	now := time.Now().Unix()
+	for _, pq := range c.pq {
+		// the heap is only partly ordered, so go through a sorted copy
+		sorted := make(priorityQueue, len(pq))
+		copy(sorted, pq)
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].priority < sorted[j].priority })
+		for _, e := range sorted {
+			if e.expire != 0 && e.expire <= now {
+				continue
+			}
+			if !fn(e.toEntry()) {
+				return
+			}
+		}
+	}
+}
+
+// Peek returns the unexpired entry with the given key without counting
+// it as used
+func (c *GdsfCache) Peek(key string) (cache.Entry, bool) {
+	e, isPresent := c.kvStore[key]
+	if isPresent == false || (e.expire != 0 && e.expire <= time.Now().Unix()) {
+		return cache.Entry{}, false
+	}
+	return e.toEntry(), true
+}
+
+func (e *entry) toEntry() cache.Entry {
+	return cache.Entry{
+		Key:      e.key,
+		Value:    e.value,
+		Expire:   e.expire,
+		Cost:     e.cost,
+		Priority: e.tier == priorityTier,
+	}
+}
+
+// RemoveExpired removes the expired entries, returning their keys
+func (c *GdsfCache) RemoveExpired() []string {
+	var keys []string
+	for key, e := range c.kvStore {
+		if c.checkIfExpired(e) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Delete entry with given key
+func (c *GdsfCache) Delete(key string) {
+	e, isPresent := c.kvStore[key]
+	if isPresent {
+		c.remove(e)
+	}
+}
@@ -0,0 +1,88 @@
+package gdsf
+
+import (
+	"strings"
+	"testing"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+)
+
+func TestGDSFEvictsLargeColdEntries(t *testing.T) {
+	gdsfCache := ConstructorWithOptions(0, Options{MaxBytes: 40})
+	gdsfCache.Set("a", "1", 0)
+	gdsfCache.Set("b", "2", 0)
+	gdsfCache.Get("a")
+	gdsfCache.Get("b")
+	gdsfCache.Set("big", "01234567890123456789012345678901", 0)
+	// doesn't fit, the big entry has the lowest priority of the old ones
+	gdsfCache.Set("c", "3", 0)
+	gdsfCache.Set("d", "4", 0)
+	if gdsfCache.Exists("big") {
+		t.Errorf("expected big entry to be evicted")
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if !gdsfCache.Exists(key) {
+			t.Errorf("expected %q to remain", key)
+		}
+	}
+	if gdsfCache.bytes > 40 {
+		t.Errorf("got %d bytes, want at most 40", gdsfCache.bytes)
+	}
+}
+
+func TestGDSFCost(t *testing.T) {
+	gdsfCache := Constructor(2)
+	gdsfCache.SetWithHints("expensive", "1", 0, 100, false)
+	gdsfCache.Set("cheap", "2", 0)
+	gdsfCache.Get("cheap")
+	gdsfCache.Set("new", "3", 0)
+	if !gdsfCache.Exists("expensive") {
+		t.Errorf("expected expensive entry to remain")
+	}
+	if gdsfCache.Exists("cheap") {
+		t.Errorf("expected cheap entry to be evicted")
+	}
+	if gdsfCache.clock == 0 {
+		t.Errorf("expected clock to be inflated on eviction")
+	}
+}
+
+func TestGDSFRangeInPriorityOrder(t *testing.T) {
+	gdsfCache := Constructor(0)
+	for i, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		gdsfCache.SetWithHints(key, "1", 0, 8-i, false)
+	}
+	gdsfCache.SetWithHints("kept", "1", 0, 1, true)
+	var got []string
+	gdsfCache.Range(func(e cache.Entry) bool {
+		got = append(got, e.Key)
+		return true
+	})
+	want := []string{"h", "g", "f", "e", "d", "c", "b", "a", "kept"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGDSFKeepsToMaxBytes(t *testing.T) {
+	gdsfCache := ConstructorWithOptions(0, Options{MaxBytes: 10})
+	if err := gdsfCache.CheckSize("k", "0123456789"); err == nil {
+		t.Error("expected an entry over MaxBytes not to fit")
+	}
+	gdsfCache.Set("", "", 0)
+	if gdsfCache.Bytes() != 0 {
+		t.Errorf("got %d bytes for an empty entry, want 0", gdsfCache.Bytes())
+	}
+	gdsfCache.Set("a", "1", 0)
+	gdsfCache.Set("b", "2", 0)
+	// growing b past what's left evicts a
+	gdsfCache.Set("b", "23456789", 0)
+	if gdsfCache.Exists("a") || gdsfCache.Bytes() > 10 {
+		t.Errorf("got %d bytes with a kept, want a evicted", gdsfCache.Bytes())
+	}
+	// growing b past MaxBytes drops it
+	gdsfCache.Set("b", "23456789012", 0)
+	if gdsfCache.Exists("b") || gdsfCache.Bytes() != 0 {
+		t.Errorf("got %d bytes with b kept, want b dropped", gdsfCache.Bytes())
+	}
+}
@@ -0,0 +1,13 @@
+package gdsf
+
+import cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+
+func init() {
+	cache.Register("gdsf", func(capacity int, opts cache.Options) (cache.Cache, error) {
+		maxBytes, err := opts.Int("maxBytes", 0)
+		if err != nil {
+			return nil, err
+		}
+		return ConstructorWithOptions(capacity, Options{MaxBytes: maxBytes}), nil
+	})
+}
@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"strconv"
+	"time"
+)
+
+// GraceOptions configures serving values stale past their TTL while
+// they're refreshed, so that a hot key expiring doesn't turn into a
+// burst of misses
+type GraceOptions struct {
+	// Period is how long values are kept past their TTL, zero to delete
+	// them at their TTL as usual. It's rounded up to a second
+	Period time.Duration
+	// RefreshTimeout is how long the client told to refresh a value has
+	// to set it before another client is told to. Defaults to 10s, or
+	// Period if shorter
+	RefreshTimeout time.Duration
+}
+
+// graceTable tracks the TTLs of the values kept in the cache for
+// longer, guarded by the adapter's mu
+type graceTable struct {
+	period         int64 // seconds
+	refreshTimeout time.Duration
+	softExpire     map[string]int64
+	refreshing     map[string]time.Time
+	sets           int
+
+	staleServed int64
+	refreshes   int64
+}
+
+// SetGraceOptions enables, or with a zero Period disables, serving
+// values stale for a grace period past their TTL. With it enabled, Get
+// replies STALE with the value once it's past its TTL, except to one
+// client at a time, which is told to set a new value with a REFRESH
+// reply. Other commands treat values past their TTL as missing. It only
+// applies to values set from then on
+func (cw *Adapter) SetGraceOptions(opts GraceOptions) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if opts.Period <= 0 {
+		cw.grace = nil
+		return
+	}
+	refreshTimeout := opts.RefreshTimeout
+	if refreshTimeout <= 0 {
+		refreshTimeout = 10 * time.Second
+	}
+	if refreshTimeout > opts.Period {
+		refreshTimeout = opts.Period
+	}
+	cw.grace = &graceTable{
+		period:         int64((opts.Period + time.Second - 1) / time.Second),
+		refreshTimeout: refreshTimeout,
+		softExpire:     make(map[string]int64),
+		refreshing:     make(map[string]time.Time),
+	}
+}
+
+// exptime returns the exptime to store a value with, extended by the
+// grace period. Callers hold mu
+func (cw *Adapter) graceExptime(exptime int) int {
+	if cw.grace == nil || exptime <= 0 {
+		return exptime
+	}
+	return exptime + int(cw.grace.period)
+}
+
+// trackExpiry records the TTL of key after it was set with exptime.
+// Callers hold mu
+func (cw *Adapter) trackExpiry(key string, exptime int) {
+	g := cw.grace
+	if g == nil {
+		return
+	}
+	delete(g.refreshing, key)
+	pc, ok := cw.cache.(PeekCache)
+	if !ok {
+		return
+	}
+	e, exists := pc.Peek(key)
+	switch {
+	case !exists || e.Expire == 0:
+		delete(g.softExpire, key)
+	case exptime > 0:
+		g.softExpire[key] = time.Now().Unix() + int64(exptime)
+	default:
+		// the expiry was kept as it was
+		if _, tracked := g.softExpire[key]; !tracked {
+			g.softExpire[key] = e.Expire - g.period
+		}
+	}
+	g.sets++
+	if g.sets >= sweepEvery {
+		g.sets = 0
+		cw.sweepGrace()
+	}
+}
+
+// sweepGrace forgets the keys that were evicted. Callers hold mu
+func (cw *Adapter) sweepGrace() {
+	now := time.Now()
+	for key := range cw.grace.softExpire {
+		if !cw.cache.Exists(key) {
+			delete(cw.grace.softExpire, key)
+		}
+	}
+	for key, since := range cw.grace.refreshing {
+		if now.Sub(since) > cw.grace.refreshTimeout {
+			delete(cw.grace.refreshing, key)
+		}
+	}
+}
+
+// forgetExpiry is called when key is deleted. Callers hold mu
+func (cw *Adapter) forgetExpiry(key string) {
+	if cw.grace != nil {
+		delete(cw.grace.softExpire, key)
+		delete(cw.grace.refreshing, key)
+	}
+}
+
+// pastTTL reports whether key is being kept past its TTL. Callers hold mu
+func (cw *Adapter) pastTTL(key string) bool {
+	if cw.grace == nil {
+		return false
+	}
+	softExpire, ok := cw.grace.softExpire[key]
+	return ok && softExpire <= time.Now().Unix()
+}
+
+// staleReply is the reply to a get of a value past its TTL. Callers hold mu
+func (cw *Adapter) staleReply(key string) Reply {
+	g := cw.grace
+	now := time.Now()
+	if since, ok := g.refreshing[key]; !ok || now.Sub(since) > g.refreshTimeout {
+		g.refreshing[key] = now
+		g.refreshes++
+		return RefreshReply
+	}
+	g.staleServed++
+	return StaleReply
+}
+
+func (g *graceTable) addStats(st map[string]string) {
+	st["grace_period"] = strconv.FormatInt(g.period, 10)
+	st["grace_stale_served"] = strconv.FormatInt(g.staleServed, 10)
+	st["grace_refreshes"] = strconv.FormatInt(g.refreshes, 10)
+}
@@ -0,0 +1,38 @@
+package cache
+
+import "strconv"
+
+// Hints are optional per-item eviction hints given on set, add and replace
+type Hints struct {
+	// Cost is the relative cost of recomputing the value, zero to keep the
+	// current cost
+	Cost int
+	// Priority entries are only evicted once no normal entries are left.
+	// nil to keep the current priority, none for new entries
+	Priority *bool
+}
+
+// ParseHints parses the cost and priority strings of a request. It returns
+// nil hints if neither is given, in which case an existing entry keeps its
+// hints, and hints without a priority if only the cost is given
+func ParseHints(costStr, priorityStr string) (*Hints, bool) {
+	if costStr == "" && priorityStr == "" {
+		return nil, true
+	}
+	hints := &Hints{}
+	if costStr != "" {
+		cost, err := strconv.Atoi(costStr)
+		if err != nil || cost < 0 {
+			return nil, false
+		}
+		hints.Cost = cost
+	}
+	if priorityStr != "" {
+		priority, err := strconv.ParseBool(priorityStr)
+		if err != nil {
+			return nil, false
+		}
+		hints.Priority = &priority
+	}
+	return hints, true
+}
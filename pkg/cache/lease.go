@@ -0,0 +1,196 @@
+package cache
+
+import (
+	"strconv"
+	"time"
+)
+
+// LeaseOptions configures leases, as described in Facebook's "Scaling
+// Memcache at Facebook". A miss hands one client a lease to fill the
+// key, other clients get a hot miss until it does. Sets and deletes of
+// the key invalidate the lease, so a lease holder that read from the
+// database before an update can't overwrite the newer value
+type LeaseOptions struct {
+	// TTL is how long a lease is held before another one can be issued
+	// for the key. Defaults to 10s
+	TTL time.Duration
+	// StaleTTL is how long deleted values are kept to be served, marked
+	// stale, on misses of the key. Defaults to 10s, negative for none
+	StaleTTL time.Duration
+}
+
+type lease struct {
+	token   Token
+	expires time.Time
+}
+
+type staleValue struct {
+	value   string
+	expires time.Time
+}
+
+// sweepEvery is the number of leases issued or values kept stale between
+// sweeps of the expired ones
+const sweepEvery = 1024
+
+// leaseTable holds the leases and stale values, guarded by the adapter's mu
+type leaseTable struct {
+	opts    LeaseOptions
+	held    map[string]lease
+	stale   map[string]staleValue
+	next    uint64
+	inserts int
+
+	issued       int64
+	hotMisses    int64
+	staleServed  int64
+	rejectedSets int64
+}
+
+func newLeaseTable(opts LeaseOptions) *leaseTable {
+	if opts.TTL <= 0 {
+		opts.TTL = 10 * time.Second
+	}
+	if opts.StaleTTL == 0 {
+		opts.StaleTTL = 10 * time.Second
+	}
+	return &leaseTable{
+		opts:  opts,
+		held:  make(map[string]lease),
+		stale: make(map[string]staleValue),
+		// tokens from before a restart are never valid again
+		next: uint64(time.Now().UnixNano()),
+	}
+}
+
+// SetLeaseOptions configures leases, dropping every lease held
+func (cw *Adapter) SetLeaseOptions(opts LeaseOptions) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.leases = newLeaseTable(opts)
+}
+
+// leaseTable returns the leases, with the default options if they
+// haven't been used yet. Callers hold mu
+func (cw *Adapter) leaseTable() *leaseTable {
+	if cw.leases == nil {
+		cw.leases = newLeaseTable(LeaseOptions{})
+	}
+	return cw.leases
+}
+
+func (lt *leaseTable) inserted(now time.Time) {
+	lt.inserts++
+	if lt.inserts < sweepEvery {
+		return
+	}
+	lt.inserts = 0
+	for key, l := range lt.held {
+		if now.After(l.expires) {
+			delete(lt.held, key)
+		}
+	}
+	for key, s := range lt.stale {
+		if now.After(s.expires) {
+			delete(lt.stale, key)
+		}
+	}
+}
+
+// invalidate drops the lease on key, if any, after a write
+func (lt *leaseTable) invalidate(key string) {
+	delete(lt.held, key)
+	delete(lt.stale, key)
+}
+
+// reset drops every lease and stale value, e.g. when the cache is cleared
+func (lt *leaseTable) reset() {
+	lt.held = make(map[string]lease)
+	lt.stale = make(map[string]staleValue)
+}
+
+// keepStale keeps a deleted value to be served stale
+func (lt *leaseTable) keepStale(key, value string) {
+	if lt.opts.StaleTTL < 0 {
+		return
+	}
+	now := time.Now()
+	lt.stale[key] = staleValue{value, now.Add(lt.opts.StaleTTL)}
+	lt.inserted(now)
+}
+
+// GetWithLease gets the value of key. On a miss the client is given a
+// lease token to set the value with SetWithLease, unless another client
+// holds the lease, in which case the reply is HOT_MISS and the client
+// should retry shortly. Either way, a recently deleted value is returned
+// if there's one, with stale true. A value kept past its TTL for the
+// grace period is returned with stale true too, and a REFRESH reply with
+// a lease to the one client that should set a new value, STALE to others
+func (cw *Adapter) GetWithLease(key string) (reply Reply, val string, token Token, stale bool) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if err := cw.checkKey(key); err != nil {
+		return ClientError(err.Error()), "", "", false
+	}
+	if val, exists := cw.get(key); exists {
+		if !cw.pastTTL(key) {
+			return ValueReply, val, "", false
+		}
+		if reply = cw.staleReply(key); reply == RefreshReply {
+			token = cw.leaseTable().issue(key, time.Now())
+		}
+		return reply, val, token, true
+	}
+	lt := cw.leaseTable()
+	now := time.Now()
+	if s, ok := lt.stale[key]; ok && now.Before(s.expires) {
+		val, stale = s.value, true
+		lt.staleServed++
+	}
+	if l, held := lt.held[key]; held && now.Before(l.expires) {
+		lt.hotMisses++
+		return HotMissReply, val, "", stale
+	}
+	return NotFoundReply, val, lt.issue(key, now), stale
+}
+
+// issue hands out a new lease on key
+func (lt *leaseTable) issue(key string, now time.Time) Token {
+	lt.next++
+	token := Token(strconv.FormatUint(lt.next, 36))
+	lt.held[key] = lease{token, now.Add(lt.opts.TTL)}
+	lt.issued++
+	lt.inserted(now)
+	return token
+}
+
+// SetWithLease sets the value of key if token is the lease on it. The
+// reply is NOT_STORED if the lease was invalidated by a set or delete
+// of the key since it was issued, or has expired
+func (cw *Adapter) SetWithLease(key, val, exptimeStr string, token Token, hints *Hints) Reply {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if err := cw.checkItem(key, val); err != nil {
+		return ClientError(err.Error())
+	}
+	exptime, err := strconv.Atoi(exptimeStr)
+	if err != nil {
+		return ClientErrorReply
+	}
+	if cw.leases == nil {
+		return NotStoredReply
+	}
+	if l, held := cw.leases.held[key]; !held || l.token != token || !time.Now().Before(l.expires) {
+		cw.leases.rejectedSets++
+		return NotStoredReply
+	}
+	return setReply(cw.set(key, val, exptime, hints))
+}
+
+func (lt *leaseTable) addStats(st map[string]string) {
+	st["lease_held"] = strconv.Itoa(len(lt.held))
+	st["lease_issued"] = strconv.FormatInt(lt.issued, 10)
+	st["lease_hot_misses"] = strconv.FormatInt(lt.hotMisses, 10)
+	st["lease_stale_served"] = strconv.FormatInt(lt.staleServed, 10)
+	st["lease_rejected_sets"] = strconv.FormatInt(lt.rejectedSets, 10)
+}
@@ -1,41 +1,137 @@
 package lfu
 
 import (
+	"container/list"
 	"math"
 	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
 )
 
 type payload struct {
 	frequency int
 	value     string
 	expire    int64 // Unix time
+	tier      int
 }
 
-var emptyVal struct{}
+// entries are split into tiers, each with its own lfuList. Keys in the
+// priority tier are only evicted once the normal tier is empty
+const (
+	normalTier = iota
+	priorityTier
+	numTiers
+)
+
+func tierOf(priority bool) int {
+	if priority {
+		return priorityTier
+	}
+	return normalTier
+}
 
-type set map[string]struct{}
+// set is an insertion-ordered set of keys. Keys enter a bucket when used,
+// so among keys of equal frequency the oldest is the least recently used,
+// and that's the one evicted
+type set struct {
+	elems map[string]*list.Element
+	order *list.List
+}
+
+func newSet() *set {
+	return &set{
+		elems: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (s *set) add(key string) {
+	s.elems[key] = s.order.PushBack(key)
+}
+
+func (s *set) remove(key string) {
+	if elem, isPresent := s.elems[key]; isPresent {
+		s.order.Remove(elem)
+		delete(s.elems, key)
+	}
+}
+
+func (s *set) len() int {
+	return len(s.elems)
+}
+
+func (s *set) popOldest() (string, bool) {
+	elem := s.order.Front()
+	if elem == nil {
+		return "", false
+	}
+	key := elem.Value.(string)
+	s.order.Remove(elem)
+	delete(s.elems, key)
+	return key, true
+}
+
+// Options configures frequency aging for LfuCache
+type Options struct {
+	// MaxFrequency caps the frequency count, and hence the number of
+	// buckets in lfuList. Zero for unlimited
+	MaxFrequency int
+	// DecayInterval is the number of accesses after which every
+	// frequency is halved. Zero disables decay
+	DecayInterval int
+}
 
 //LfuCache ...
 type LfuCache struct {
-	lfuList  []set
-	kvStore  map[string]payload
-	capacity int
+	lfuList      [numTiers][]*set
+	kvStore      map[string]payload
+	capacity     int
+	minFrequency [numTiers]int // lowest bucket that may hold keys, advanced lazily
+	maxFrequency int
+	decayEvery   int
+	accesses     int
+	bytes        int // of the keys and values held
 }
 
 //Constructor ...
 func Constructor(capacity int) *LfuCache {
+	return ConstructorWithOptions(capacity, Options{})
+}
+
+// ConstructorWithOptions ...
+func ConstructorWithOptions(capacity int, opts Options) *LfuCache {
 	if capacity < 1 {
 		capacity = math.MaxInt64
 	}
-	lfuList := make([]set, 1)
-	lfuList[0] = make(map[string]struct{})
+	maxFrequency := opts.MaxFrequency
+	if maxFrequency < 1 {
+		maxFrequency = math.MaxInt64
+	}
+	var lfuList [numTiers][]*set
+	for t := range lfuList {
+		lfuList[t] = []*set{newSet()}
+	}
 	return &LfuCache{
-		lfuList:  lfuList,
-		kvStore:  make(map[string]payload),
-		capacity: capacity,
+		lfuList:      lfuList,
+		kvStore:      make(map[string]payload),
+		capacity:     capacity,
+		maxFrequency: maxFrequency,
+		decayEvery:   opts.DecayInterval,
 	}
 }
 
+// Len returns the number of entries, including expired ones that
+// haven't been removed yet
+func (c *LfuCache) Len() int {
+	return len(c.kvStore)
+}
+
+// Bytes returns the size of the keys and values held, including expired
+// ones that haven't been removed yet
+func (c *LfuCache) Bytes() int {
+	return c.bytes
+}
+
 // Exists returns true if entry with given key exists, else false
 func (c *LfuCache) Exists(key string) bool {
 	_, isPresent := c.kvStore[key]
@@ -44,6 +140,17 @@ func (c *LfuCache) Exists(key string) bool {
 
 // Set entry from given key-value plus add expiry
 func (c *LfuCache) Set(key, value string, exptime int) {
+	entry := c.kvStore[key]
+	c.set(key, value, exptime, entry.tier)
+}
+
+// SetWithHints is like Set but also sets whether the entry has priority.
+// Cost is not taken into account by this policy
+func (c *LfuCache) SetWithHints(key, value string, exptime, cost int, priority bool) {
+	c.set(key, value, exptime, tierOf(priority))
+}
+
+func (c *LfuCache) set(key, value string, exptime int, tier int) {
 	//add new entry
 	entry, isPresent := c.kvStore[key]
 	var expire int64 = 0
@@ -54,29 +161,59 @@ func (c *LfuCache) Set(key, value string, exptime int) {
 		if isExpired := c.checkIfExpired(key, entry); isExpired {
 			return
 		}
-		c.updateFrequency(key, entry)
+		entry = c.updateFrequency(key, entry)
 		// during update, only update expire val if exptime g.t. 0
 		if exptime > 0 {
 			entry.expire = expire
 		}
+		if entry.tier != tier {
+			c.lfuList[entry.tier][entry.frequency].remove(key)
+			entry.tier = tier
+			c.addToBucket(key, entry)
+		}
 	} else { //new entry
 		c.evictExtra()
 		entry.frequency = 0
 		entry.expire = expire
-		c.lfuList[0][key] = emptyVal
+		entry.tier = tier
+		c.addToBucket(key, entry)
 	}
+	if isPresent {
+		c.bytes -= len(entry.value)
+	} else {
+		c.bytes += len(key)
+	}
+	c.bytes += len(value)
 	entry.value = value
 	c.kvStore[key] = entry
 }
 
+func (c *LfuCache) addToBucket(key string, entry payload) {
+	lfuList := c.lfuList[entry.tier]
+	for entry.frequency >= len(lfuList) {
+		lfuList = append(lfuList, newSet())
+	}
+	lfuList[entry.frequency].add(key)
+	c.lfuList[entry.tier] = lfuList
+	if entry.frequency < c.minFrequency[entry.tier] {
+		c.minFrequency[entry.tier] = entry.frequency
+	}
+}
+
+// evictExtra removes one least-frequently-used key when at capacity,
+// preferring the normal tier. minFrequency only ever points at or below
+// the lowest non-empty bucket, so the scan starts there rather than at
+// bucket zero
 func (c *LfuCache) evictExtra() {
-	if len(c.kvStore) >= c.capacity {
-		for _, bucket := range c.lfuList {
-			if len(bucket) == 0 {
-				continue
-			}
-			for keyToEvict := range bucket {
-				delete(bucket, keyToEvict)
+	if len(c.kvStore) < c.capacity {
+		return
+	}
+	for tier, lfuList := range c.lfuList {
+		for i := c.minFrequency[tier]; i < len(lfuList); i++ {
+			keyToEvict, isNotEmpty := lfuList[i].popOldest()
+			if isNotEmpty {
+				c.minFrequency[tier] = i
+				c.bytes -= len(keyToEvict) + len(c.kvStore[keyToEvict].value)
 				delete(c.kvStore, keyToEvict)
 				return
 			}
@@ -93,37 +230,126 @@ func (c *LfuCache) Get(key string) (string, bool) {
 	if isExpired := c.checkIfExpired(key, entry); isExpired {
 		return "", false
 	}
-	c.updateFrequency(key, entry)
+	entry = c.updateFrequency(key, entry)
 	return entry.value, true
 }
 
 // returns true and deletes entry if is expired, else false
 func (c *LfuCache) checkIfExpired(key string, entry payload) bool {
 	if entry.expire != 0 && entry.expire <= time.Now().Unix() {
-		bucket := c.lfuList[entry.frequency]
-		delete(bucket, key)
+		c.lfuList[entry.tier][entry.frequency].remove(key)
+		c.bytes -= len(key) + len(entry.value)
 		delete(c.kvStore, key)
 		return true
 	}
 	return false
 }
 
-func (c *LfuCache) updateFrequency(key string, entry payload) {
-	delete(c.lfuList[entry.frequency], key)
-	entry.frequency++
+// updateFrequency bumps the key to the next bucket, unless it is already
+// at maxFrequency, and returns the updated entry
+func (c *LfuCache) updateFrequency(key string, entry payload) payload {
+	if entry.frequency < c.maxFrequency {
+		bucket := c.lfuList[entry.tier][entry.frequency]
+		bucket.remove(key)
+		if bucket.len() == 0 && c.minFrequency[entry.tier] == entry.frequency {
+			c.minFrequency[entry.tier]++
+		}
+		entry.frequency++
+		c.addToBucket(key, entry)
+	} else {
+		// already at maxFrequency, only mark it as most recently used
+		bucket := c.lfuList[entry.tier][entry.frequency]
+		bucket.remove(key)
+		bucket.add(key)
+	}
 	c.kvStore[key] = entry
-	if entry.frequency == len(c.lfuList) {
-		c.lfuList = append(c.lfuList, make(map[string]struct{}))
+	c.accesses++
+	if c.decayEvery > 0 && c.accesses >= c.decayEvery {
+		c.decay()
+		entry = c.kvStore[key]
+	}
+	return entry
+}
+
+// decay halves the frequency of every key and rebuilds lfuList so that
+// keys which were hot long ago eventually become evictable. Buckets are
+// walked from the lowest frequency up, so within a merged bucket keys from
+// the higher frequency count as the more recently used. It's O(n) but
+// only runs once every decayEvery accesses
+func (c *LfuCache) decay() {
+	c.accesses = 0
+	for t, oldList := range c.lfuList {
+		c.lfuList[t] = []*set{newSet()}
+		c.minFrequency[t] = 0
+		for _, bucket := range oldList {
+			for elem := bucket.order.Front(); elem != nil; elem = elem.Next() {
+				key := elem.Value.(string)
+				entry := c.kvStore[key]
+				entry.frequency /= 2
+				c.kvStore[key] = entry
+				c.addToBucket(key, entry)
+			}
+		}
+	}
+}
+
+// Range calls fn for every unexpired entry, from the least to the most
+// frequently used, normal entries before priority ones. Stops if fn
+// returns false. The entries mustn't be modified during Range
+func (c *LfuCache) Range(fn func(cache.Entry) bool) {
+	now := time.Now().Unix()
+	for _, lfuList := range c.lfuList {
+		for _, bucket := range lfuList {
+			for elem := bucket.order.Front(); elem != nil; elem = elem.Next() {
+				key := elem.Value.(string)
+				entry := c.kvStore[key]
+				if entry.expire != 0 && entry.expire <= now {
+					continue
+				}
+				if !fn(entry.toEntry(key)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Peek returns the unexpired entry with the given key without counting
+// it as used
+func (c *LfuCache) Peek(key string) (cache.Entry, bool) {
+	entry, isPresent := c.kvStore[key]
+	if isPresent == false || (entry.expire != 0 && entry.expire <= time.Now().Unix()) {
+		return cache.Entry{}, false
+	}
+	return entry.toEntry(key), true
+}
+
+func (entry payload) toEntry(key string) cache.Entry {
+	return cache.Entry{
+		Key:      key,
+		Value:    entry.value,
+		Expire:   entry.expire,
+		Priority: entry.tier == priorityTier,
+	}
+}
+
+// RemoveExpired removes the expired entries, returning their keys
+func (c *LfuCache) RemoveExpired() []string {
+	var keys []string
+	for key, entry := range c.kvStore {
+		if c.checkIfExpired(key, entry) {
+			keys = append(keys, key)
+		}
 	}
-	c.lfuList[entry.frequency][key] = emptyVal
+	return keys
 }
 
 // Delete entry with given key
 func (c *LfuCache) Delete(key string) {
 	entry, isPresent := c.kvStore[key]
 	if isPresent == true {
-		bucket := c.lfuList[entry.frequency]
-		delete(bucket, key)
+		c.lfuList[entry.tier][entry.frequency].remove(key)
+		c.bytes -= len(key) + len(entry.value)
 		delete(c.kvStore, key)
 	}
 }
@@ -32,3 +32,51 @@ func TestLRUCacheLeetCode(t *testing.T) {
 		t.Errorf("\ngot %v \nwant %v\n", output, expected)
 	}
 }
+
+func TestLFUCacheDecay(t *testing.T) {
+	lfuCache := ConstructorWithOptions(2, Options{MaxFrequency: 4, DecayInterval: 8})
+	lfuCache.Set("old", "1", 0)
+	for i := 0; i < 7; i++ {
+		lfuCache.Get("old")
+	}
+	if got := lfuCache.kvStore["old"].frequency; got != 4 {
+		t.Errorf("frequency should be capped, got %d want 4", got)
+	}
+	if len(lfuCache.lfuList[normalTier]) != 5 {
+		t.Errorf("got %d buckets, want 5", len(lfuCache.lfuList[normalTier]))
+	}
+	// 8th access triggers a decay
+	lfuCache.Set("new", "2", 0)
+	lfuCache.Get("new")
+	if got := lfuCache.kvStore["old"].frequency; got != 2 {
+		t.Errorf("frequency should be halved, got %d want 2", got)
+	}
+	for i := 0; i < 3; i++ {
+		lfuCache.Get("new")
+	}
+	// "old" is now the least frequently used and gets evicted
+	lfuCache.Set("newer", "3", 0)
+	if lfuCache.Exists("old") {
+		t.Errorf("expected decayed key to be evicted")
+	}
+	if !lfuCache.Exists("new") || !lfuCache.Exists("newer") {
+		t.Errorf("expected recent keys to remain")
+	}
+}
+
+func TestLFUCachePriority(t *testing.T) {
+	lfuCache := Constructor(2)
+	lfuCache.SetWithHints("vip", "1", 0, 0, true)
+	lfuCache.Set("a", "2", 0)
+	lfuCache.Get("a")
+	lfuCache.Set("b", "3", 0)
+	if !lfuCache.Exists("vip") || lfuCache.Exists("a") {
+		t.Errorf("expected normal key to be evicted before priority key")
+	}
+	// a plain set keeps the entry's priority
+	lfuCache.Set("vip", "4", 0)
+	lfuCache.Set("c", "5", 0)
+	if !lfuCache.Exists("vip") {
+		t.Errorf("expected priority to be kept on update")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"math"
 	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
 )
 
 /*
@@ -74,39 +76,98 @@ On max capacity, the least-frequently-used key plus its value are evicted
 However, if multiple keys are in the same frequency bucket, then the
 least-recently-used key is evicted. See section on bucket to see how
 it keeps track of the lru.
-Note however that the eviction operation could potentially be O(f)
-where f is the max frequency. In future, in order to mitigate this,
-an additional field will be added to the LfuLrtCache struct to keep track
-of the index of the bucket where the lfu key should be
+The minFrequency field tracks the index of the bucket where the lfu key
+should be, so eviction doesn't have to scan from bucket zero. It is bumped
+along with keys during use and advanced lazily past buckets emptied by
+deletes or expiry.
+Since frequencies only ever increase, keys that were hot long ago would
+otherwise never be evicted. To mitigate this, frequencies can be capped
+(maxFrequency) and periodically halved (decayEvery), see Options
+Keys set with priority are kept in a separate lfuList and minFrequency,
+and are only evicted once there are no normal keys left
 */
 
 type payload struct {
 	frequency int
 	expire    int64
 	value     string
+	tier      int
+}
+
+const (
+	normalTier = iota
+	priorityTier
+	numTiers
+)
+
+func tierOf(priority bool) int {
+	if priority {
+		return priorityTier
+	}
+	return normalTier
+}
+
+// Options configures frequency aging for LfuLrtCache
+type Options struct {
+	// MaxFrequency caps the frequency count, and hence the number of
+	// buckets in lfuList. Zero for unlimited
+	MaxFrequency int
+	// DecayInterval is the number of accesses after which every
+	// frequency is halved. Zero disables decay
+	DecayInterval int
 }
 
 //LfuLrtCache ...
 type LfuLrtCache struct {
-	lfuList []*bucket
-	kvStore map[string]payload
-	max     int
+	lfuList      [numTiers][]*bucket
+	kvStore      map[string]payload
+	max          int
+	minFrequency [numTiers]int
+	maxFrequency int
+	decayEvery   int
+	accesses     int
+	bytes        int // of the keys and values held
 }
 
 //Constructor ...
 func Constructor(max int) *LfuLrtCache {
+	return ConstructorWithOptions(max, Options{})
+}
+
+// ConstructorWithOptions ...
+func ConstructorWithOptions(max int, opts Options) *LfuLrtCache {
 	if max < 1 {
 		max = math.MaxInt64
 	}
-	lfuList := make([]*bucket, 1)
-	lfuList[0] = newBucket()
+	maxFrequency := opts.MaxFrequency
+	if maxFrequency < 1 {
+		maxFrequency = math.MaxInt64
+	}
+	var lfuList [numTiers][]*bucket
+	for t := range lfuList {
+		lfuList[t] = []*bucket{newBucket()}
+	}
 	return &LfuLrtCache{
-		lfuList: lfuList,
-		kvStore: make(map[string]payload),
-		max:     max,
+		lfuList:      lfuList,
+		kvStore:      make(map[string]payload),
+		max:          max,
+		maxFrequency: maxFrequency,
+		decayEvery:   opts.DecayInterval,
 	}
 }
 
+// Len returns the number of entries, including expired ones that
+// haven't been removed yet
+func (c *LfuLrtCache) Len() int {
+	return len(c.kvStore)
+}
+
+// Bytes returns the size of the keys and values held, including expired
+// ones that haven't been removed yet
+func (c *LfuLrtCache) Bytes() int {
+	return c.bytes
+}
+
 // Exists returns true if entry with given key exists, else false
 func (c *LfuLrtCache) Exists(key string) bool {
 	_, isPresent := c.kvStore[key]
@@ -116,7 +177,8 @@ func (c *LfuLrtCache) Exists(key string) bool {
 // returns true and deletes entry if is expired, else false
 func (c *LfuLrtCache) checkIfExpired(key string, entry payload) bool {
 	if entry.expire != 0 && entry.expire <= time.Now().Unix() {
-		c.lfuList[entry.frequency].remove(key)
+		c.lfuList[entry.tier][entry.frequency].remove(key)
+		c.bytes -= len(key) + len(entry.value)
 		delete(c.kvStore, key)
 		return true
 	}
@@ -125,6 +187,17 @@ func (c *LfuLrtCache) checkIfExpired(key string, entry payload) bool {
 
 // Set ...
 func (c *LfuLrtCache) Set(key, value string, exptime int) {
+	entry := c.kvStore[key]
+	c.set(key, value, exptime, entry.tier)
+}
+
+// SetWithHints is like Set but also sets whether the entry has priority.
+// Cost is not taken into account by this policy
+func (c *LfuLrtCache) SetWithHints(key, value string, exptime, cost int, priority bool) {
+	c.set(key, value, exptime, tierOf(priority))
+}
+
+func (c *LfuLrtCache) set(key, value string, exptime int, tier int) {
 	//add new entry
 	entry, isPresent := c.kvStore[key]
 	var expire int64 = 0
@@ -135,30 +208,89 @@ func (c *LfuLrtCache) Set(key, value string, exptime int) {
 		if isExpired := c.checkIfExpired(key, entry); isExpired {
 			return
 		}
-		c.updateFrequency(key, entry)
+		entry = c.updateFrequency(key, entry)
 		// during update, only update expire val if exptime g.t. 0
 		if exptime > 0 {
 			entry.expire = expire
 		}
+		if entry.tier != tier {
+			c.lfuList[entry.tier][entry.frequency].remove(key)
+			entry.tier = tier
+			c.addToBucket(key, entry)
+		}
 	} else { //new entry
 		c.evictExtra()
 		entry.frequency = 0
 		entry.expire = expire
-		c.lfuList[0].add(key)
+		entry.tier = tier
+		c.addToBucket(key, entry)
+	}
+	if isPresent {
+		c.bytes -= len(entry.value)
+	} else {
+		c.bytes += len(key)
 	}
+	c.bytes += len(value)
 	entry.value = value
 	c.kvStore[key] = entry
 
 }
 
-func (c *LfuLrtCache) updateFrequency(key string, entry payload) {
-	c.lfuList[entry.frequency].remove(key)
-	entry.frequency++
+// updateFrequency bumps the key to the next bucket and returns the updated
+// entry. Keys already at maxFrequency are only moved to the head of their
+// bucket's lruList
+func (c *LfuLrtCache) updateFrequency(key string, entry payload) payload {
+	b := c.lfuList[entry.tier][entry.frequency]
+	b.remove(key)
+	if entry.frequency < c.maxFrequency {
+		if b.isEmpty() && c.minFrequency[entry.tier] == entry.frequency {
+			c.minFrequency[entry.tier]++
+		}
+		entry.frequency++
+	}
+	c.addToBucket(key, entry)
 	c.kvStore[key] = entry
-	if entry.frequency == len(c.lfuList) {
-		c.lfuList = append(c.lfuList, newBucket())
+	c.accesses++
+	if c.decayEvery > 0 && c.accesses >= c.decayEvery {
+		c.decay()
+		entry = c.kvStore[key]
+	}
+	return entry
+}
+
+// decay halves the frequency of every key and rebuilds lfuList. Buckets are
+// walked from the lowest frequency up and each from its lru end, so that
+// within a merged bucket the keys from the higher frequency count as the
+// more recently used. O(n), but only runs once every decayEvery accesses
+func (c *LfuLrtCache) decay() {
+	c.accesses = 0
+	for t, oldList := range c.lfuList {
+		c.lfuList[t] = []*bucket{newBucket()}
+		c.minFrequency[t] = 0
+		for _, b := range oldList {
+			for elem := b.lruList.Back(); elem != nil; elem = elem.Prev() {
+				key := elem.Value.(string)
+				entry := c.kvStore[key]
+				entry.frequency /= 2
+				c.addToBucket(key, entry)
+				c.kvStore[key] = entry
+			}
+		}
+	}
+}
+
+// addToBucket adds the key to the head of its frequency bucket,
+// growing the entry's lfuList as needed
+func (c *LfuLrtCache) addToBucket(key string, entry payload) {
+	lfuList := c.lfuList[entry.tier]
+	for entry.frequency >= len(lfuList) {
+		lfuList = append(lfuList, newBucket())
+	}
+	lfuList[entry.frequency].add(key)
+	c.lfuList[entry.tier] = lfuList
+	if entry.frequency < c.minFrequency[entry.tier] {
+		c.minFrequency[entry.tier] = entry.frequency
 	}
-	c.lfuList[entry.frequency].add(key)
 }
 
 //Get ...
@@ -170,7 +302,7 @@ func (c *LfuLrtCache) Get(key string) (string, bool) {
 	if isExpired := c.checkIfExpired(key, entry); isExpired {
 		return "", false
 	}
-	c.updateFrequency(key, entry)
+	entry = c.updateFrequency(key, entry)
 	return entry.value, true
 }
 
@@ -178,11 +310,16 @@ var errorEvicting = errors.New("Error on eviction, incorrect LfuLrtCache state")
 
 func (c *LfuLrtCache) evictExtra() error {
 	if len(c.kvStore) >= c.max {
-		for _, bucket := range c.lfuList {
-			keyToEvict, isNotEmpty := bucket.popLRU()
-			if isNotEmpty {
-				delete(c.kvStore, keyToEvict)
-				return nil
+		// normal keys are evicted before priority ones
+		for tier, lfuList := range c.lfuList {
+			for i := c.minFrequency[tier]; i < len(lfuList); i++ {
+				keyToEvict, isNotEmpty := lfuList[i].popLRU()
+				if isNotEmpty {
+					c.minFrequency[tier] = i
+					c.bytes -= len(keyToEvict) + len(c.kvStore[keyToEvict].value)
+					delete(c.kvStore, keyToEvict)
+					return nil
+				}
 			}
 		}
 		return errorEvicting
@@ -190,11 +327,63 @@ func (c *LfuLrtCache) evictExtra() error {
 	return nil
 }
 
+// Range calls fn for every unexpired entry in eviction order, normal
+// entries before priority ones. Stops if fn returns false. The entries
+// mustn't be modified during Range
+func (c *LfuLrtCache) Range(fn func(cache.Entry) bool) {
+	now := time.Now().Unix()
+	for _, lfuList := range c.lfuList {
+		for _, b := range lfuList {
+			for elem := b.lruList.Back(); elem != nil; elem = elem.Prev() {
+				key := elem.Value.(string)
+				entry := c.kvStore[key]
+				if entry.expire != 0 && entry.expire <= now {
+					continue
+				}
+				if !fn(entry.toEntry(key)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Peek returns the unexpired entry with the given key without counting
+// it as used
+func (c *LfuLrtCache) Peek(key string) (cache.Entry, bool) {
+	entry, isPresent := c.kvStore[key]
+	if isPresent == false || (entry.expire != 0 && entry.expire <= time.Now().Unix()) {
+		return cache.Entry{}, false
+	}
+	return entry.toEntry(key), true
+}
+
+func (entry payload) toEntry(key string) cache.Entry {
+	return cache.Entry{
+		Key:      key,
+		Value:    entry.value,
+		Expire:   entry.expire,
+		Priority: entry.tier == priorityTier,
+	}
+}
+
+// RemoveExpired removes the expired entries, returning their keys
+func (c *LfuLrtCache) RemoveExpired() []string {
+	var keys []string
+	for key, entry := range c.kvStore {
+		if c.checkIfExpired(key, entry) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
 // Delete entry with given key
 func (c *LfuLrtCache) Delete(key string) {
 	entry, isPresent := c.kvStore[key]
 	if isPresent {
-		c.lfuList[entry.frequency].remove(key)
+		c.lfuList[entry.tier][entry.frequency].remove(key)
+		c.bytes -= len(key) + len(entry.value)
 		delete(c.kvStore, key)
 	}
 }
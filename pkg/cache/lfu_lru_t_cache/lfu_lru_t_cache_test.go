@@ -0,0 +1,108 @@
+package lfulrt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLFULRTCacheLeetCode(t *testing.T) {
+	type testInput struct {
+		key     string
+		val     string
+		exptime int
+	}
+	lfuLrtCache := Constructor(2)
+	actions := []string{"put", "put", "get", "put", "get", "get", "put", "get", "get", "get"}
+	inputs := []testInput{{"1", "1", 0}, {"2", "2", 0}, {"1", "", 0}, {"3", "3", 0},
+		{"2", "", 0}, {"3", "", 0}, {"4", "4", 0}, {"1", "", 0}, {"3", "", 0}, {"4", "", 0}}
+	expected := []string{"null", "null", "1", "null", "", "3", "null", "", "3", "4"}
+	output := make([]string, len(actions))
+	for i, action := range actions {
+		input := inputs[i]
+		switch action {
+		case "put":
+			lfuLrtCache.Set(input.key, input.val, input.exptime)
+			output[i] = "null"
+		case "get":
+			val, _ := lfuLrtCache.Get(input.key)
+			output[i] = val
+		}
+	}
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf("\ngot %v \nwant %v\n", output, expected)
+	}
+}
+
+func TestLFULRTCacheDecay(t *testing.T) {
+	lfuLrtCache := ConstructorWithOptions(2, Options{MaxFrequency: 4, DecayInterval: 8})
+	lfuLrtCache.Set("old", "1", 0)
+	for i := 0; i < 7; i++ {
+		lfuLrtCache.Get("old")
+	}
+	if got := lfuLrtCache.kvStore["old"].frequency; got != 4 {
+		t.Errorf("frequency should be capped, got %d want 4", got)
+	}
+	if len(lfuLrtCache.lfuList[normalTier]) != 5 {
+		t.Errorf("got %d buckets, want 5", len(lfuLrtCache.lfuList[normalTier]))
+	}
+	// 8th access triggers a decay
+	lfuLrtCache.Set("new", "2", 0)
+	lfuLrtCache.Get("new")
+	if got := lfuLrtCache.kvStore["old"].frequency; got != 2 {
+		t.Errorf("frequency should be halved, got %d want 2", got)
+	}
+	for i := 0; i < 3; i++ {
+		lfuLrtCache.Get("new")
+	}
+	// "old" is now the least frequently used and gets evicted
+	lfuLrtCache.Set("newer", "3", 0)
+	if lfuLrtCache.Exists("old") {
+		t.Errorf("expected decayed key to be evicted")
+	}
+	if !lfuLrtCache.Exists("new") || !lfuLrtCache.Exists("newer") {
+		t.Errorf("expected recent keys to remain")
+	}
+}
+
+func TestLFULRTCachePriority(t *testing.T) {
+	lfuLrtCache := Constructor(2)
+	lfuLrtCache.SetWithHints("vip", "1", 0, 0, true)
+	lfuLrtCache.Set("a", "2", 0)
+	lfuLrtCache.Get("a")
+	lfuLrtCache.Set("b", "3", 0)
+	if !lfuLrtCache.Exists("vip") || lfuLrtCache.Exists("a") {
+		t.Errorf("expected normal key to be evicted before priority key")
+	}
+	// a plain set keeps the entry's priority
+	lfuLrtCache.Set("vip", "4", 0)
+	lfuLrtCache.Set("c", "5", 0)
+	if !lfuLrtCache.Exists("vip") {
+		t.Errorf("expected priority to be kept on update")
+	}
+}
+
+func TestLFULRTCacheMinFrequency(t *testing.T) {
+	lfuLrtCache := Constructor(2)
+	lfuLrtCache.Set("a", "1", 0)
+	lfuLrtCache.Set("b", "2", 0)
+	lfuLrtCache.Get("a")
+	lfuLrtCache.Get("a")
+	lfuLrtCache.Get("b")
+	// deleting b leaves the min-frequency bucket empty
+	lfuLrtCache.Delete("b")
+	lfuLrtCache.Set("c", "3", 0)
+	if got := lfuLrtCache.minFrequency[normalTier]; got != 0 {
+		t.Errorf("new key should lower the min frequency, got %d want 0", got)
+	}
+	for i := 0; i < 3; i++ {
+		lfuLrtCache.Get("c")
+	}
+	if got := lfuLrtCache.minFrequency[normalTier]; got != 2 {
+		t.Errorf("min frequency should follow the emptied buckets, got %d want 2", got)
+	}
+	// "a" is now the least frequently used and gets evicted
+	lfuLrtCache.Set("d", "4", 0)
+	if lfuLrtCache.Exists("a") || !lfuLrtCache.Exists("c") || !lfuLrtCache.Exists("d") {
+		t.Errorf("expected a to be evicted, got %v", lfuLrtCache.kvStore)
+	}
+}
@@ -0,0 +1,27 @@
+package lfulrt
+
+import cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+
+func init() {
+	cache.Register("lfu-lrt", func(capacity int, opts cache.Options) (cache.Cache, error) {
+		o, err := parseOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return ConstructorWithOptions(capacity, o), nil
+	})
+	cache.RegisterAlias("lfu-lru-t", "lfu-lrt")
+}
+
+// parseOptions reads the maxFrequency and decayInterval options
+func parseOptions(opts cache.Options) (Options, error) {
+	var o Options
+	var err error
+	if o.MaxFrequency, err = opts.Int("maxFrequency", 0); err != nil {
+		return o, err
+	}
+	if o.DecayInterval, err = opts.Int("decayInterval", 0); err != nil {
+		return o, err
+	}
+	return o, nil
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Memcached's limits, the defaults
+const (
+	DefaultMaxKeyLength = 250
+	DefaultMaxItemSize  = 1 << 20
+)
+
+// Limits bound the keys and values the Adapter accepts. Commands past
+// them get a CLIENT_ERROR saying which was hit
+type Limits struct {
+	// MaxKeyLength is the longest key in bytes, negative for no limit
+	MaxKeyLength int
+	// MaxItemSize is the largest key and value together in bytes,
+	// negative for no limit. Appends and prepends count the value they
+	// result in
+	MaxItemSize int
+}
+
+// SetLimits sets the limits on keys and values, zero ones being the
+// defaults. Keys may never be empty or contain control characters
+func (cw *Adapter) SetLimits(limits Limits) {
+	if limits.MaxKeyLength == 0 {
+		limits.MaxKeyLength = DefaultMaxKeyLength
+	}
+	if limits.MaxItemSize == 0 {
+		limits.MaxItemSize = DefaultMaxItemSize
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.limits = limits
+}
+
+// CheckKey returns why the key isn't allowed, if it isn't, for callers
+// that must know before going to the cache, e.g. to load it
+func (cw *Adapter) CheckKey(key string) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.checkKey(key)
+}
+
+// checkKey is CheckKey, the caller holding mu
+func (cw *Adapter) checkKey(key string) error {
+	if key == "" {
+		return errors.New("empty key")
+	}
+	if max := cw.limits.MaxKeyLength; max > 0 && len(key) > max {
+		return fmt.Errorf("key of %d bytes is over the %d byte limit", len(key), max)
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] == 0x7f {
+			return fmt.Errorf("key contains control character 0x%02x at byte %d", key[i], i)
+		}
+	}
+	return nil
+}
+
+// checkItem returns why the key and value can't be stored, if they can't
+func (cw *Adapter) checkItem(key, val string) error {
+	if err := cw.checkKey(key); err != nil {
+		return err
+	}
+	if max := cw.limits.MaxItemSize; max > 0 && len(key)+len(val) > max {
+		return fmt.Errorf("item of %d bytes is over the %d byte limit", len(key)+len(val), max)
+	}
+	return nil
+}
+
+func (limits Limits) addStats(st map[string]string) {
+	st["max_key_length"] = strconv.Itoa(limits.MaxKeyLength)
+	st["max_item_size"] = strconv.Itoa(limits.MaxItemSize)
+}
@@ -4,20 +4,27 @@ import (
 	"container/list"
 	"math"
 	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
 )
 
-// LruCache contains an LRU LruCache
+// LruCache contains an LRU LruCache.
+// Priority entries are kept in their own list and are only evicted
+// once there are no normal entries left
 type LruCache struct {
-	kv      map[string]*list.Element
-	lruList *list.List
-	max     int // Max items present, zero for unlimited
+	kv           map[string]*list.Element
+	lruList      *list.List
+	priorityList *list.List
+	max          int // Max items present, zero for unlimited
+	bytes        int // of the keys and values held
 }
 
 // node maps a value to a key
 type node struct {
-	key    string
-	value  string
-	expire int64 // Unix time
+	key      string
+	value    string
+	expire   int64 // Unix time
+	priority bool
 }
 
 // NewLRUCache returns an empty LRUCache
@@ -26,13 +33,33 @@ func Constructor(max int) *LruCache {
 		max = math.MaxInt64
 	}
 	c := &LruCache{
-		kv:      make(map[string]*list.Element),
-		lruList: list.New(),
-		max:     max,
+		kv:           make(map[string]*list.Element),
+		lruList:      list.New(),
+		priorityList: list.New(),
+		max:          max,
 	}
 	return c
 }
 
+func (c *LruCache) listOf(n *node) *list.List {
+	if n.priority {
+		return c.priorityList
+	}
+	return c.lruList
+}
+
+// Len returns the number of entries, including expired ones that
+// haven't been removed yet
+func (c *LruCache) Len() int {
+	return len(c.kv)
+}
+
+// Bytes returns the size of the keys and values held, including expired
+// ones that haven't been removed yet
+func (c *LruCache) Bytes() int {
+	return c.bytes
+}
+
 // Exists returns true if entry with given key exists, else false
 func (c *LruCache) Exists(key string) bool {
 	_, exists := c.kv[key]
@@ -42,6 +69,18 @@ func (c *LruCache) Exists(key string) bool {
 // Set entry from given key-value plus add expiry
 func (c *LruCache) Set(key, value string, exptime int) {
 	current, exists := c.kv[key]
+	priority := exists && current.Value.(*node).priority
+	c.set(key, value, exptime, priority)
+}
+
+// SetWithHints is like Set but also sets whether the entry has priority.
+// Cost is not taken into account by this policy
+func (c *LruCache) SetWithHints(key, value string, exptime, cost int, priority bool) {
+	c.set(key, value, exptime, priority)
+}
+
+func (c *LruCache) set(key, value string, exptime int, priority bool) {
+	current, exists := c.kv[key]
 
 	var expire int64 = 0
 	if exptime > 0 {
@@ -50,30 +89,50 @@ func (c *LruCache) Set(key, value string, exptime int) {
 
 	if exists == false {
 		//add new entry
-		c.kv[key] = c.lruList.PushFront(&node{
-			key:    key,
-			value:  value,
-			expire: expire,
-		})
-		if c.lruList.Len() > c.max {
-			lruKey := (c.lruList.Back().Value).(*node).key
-			c.Delete(lruKey)
+		n := &node{
+			key:      key,
+			value:    value,
+			expire:   expire,
+			priority: priority,
+		}
+		c.kv[key] = c.listOf(n).PushFront(n)
+		c.bytes += len(key) + len(value)
+		if len(c.kv) > c.max {
+			c.evict()
 		}
 	} else {
 		//first check if expired, if so then delete and return immediately
-		prevExpire := int64(current.Value.(*node).expire)
-		if prevExpire != 0 || expire <= time.Now().Unix() {
-			c.lruList.Remove(current)
-			delete(c.kv, key)
+		n := current.Value.(*node)
+		if n.expire != 0 && n.expire <= time.Now().Unix() {
+			c.Delete(key)
 			return
 		}
 		//update current entry
 		//only update expire val if exptime g.t. 0
-		current.Value.(*node).value = value
+		c.bytes += len(value) - len(n.value)
+		n.value = value
 		if exptime > 0 {
-			current.Value.(*node).expire = expire
+			n.expire = expire
 		}
-		c.lruList.MoveToFront(current)
+		if n.priority != priority {
+			c.listOf(n).Remove(current)
+			n.priority = priority
+			c.kv[key] = c.listOf(n).PushFront(n)
+			return
+		}
+		c.listOf(n).MoveToFront(current)
+	}
+}
+
+// evict removes the least recently used entry, preferring normal entries
+// over priority ones
+func (c *LruCache) evict() {
+	l := c.lruList
+	if l.Len() == 0 {
+		l = c.priorityList
+	}
+	if back := l.Back(); back != nil {
+		c.Delete(back.Value.(*node).key)
 	}
 }
 
@@ -82,10 +141,10 @@ func (c *LruCache) Get(key string) (string, bool) {
 
 	current, exists := c.kv[key]
 	if exists {
-		expire := int64(current.Value.(*node).expire)
-		if expire == 0 || expire > time.Now().Unix() {
-			c.lruList.MoveToFront(current)
-			return current.Value.(*node).value, true
+		n := current.Value.(*node)
+		if n.expire == 0 || n.expire > time.Now().Unix() {
+			c.listOf(n).MoveToFront(current)
+			return n.value, true
 		}
 		// remove expired entry instead of returning it
 		c.Delete(key)
@@ -93,11 +152,67 @@ func (c *LruCache) Get(key string) (string, bool) {
 	return "", false
 }
 
+// Range calls fn for every unexpired entry, from the least to the most
+// recently used, normal entries before priority ones. Stops if fn returns
+// false. The entries mustn't be modified during Range
+func (c *LruCache) Range(fn func(cache.Entry) bool) {
+	now := time.Now().Unix()
+	for _, l := range []*list.List{c.lruList, c.priorityList} {
+		for elem := l.Back(); elem != nil; elem = elem.Prev() {
+			n := elem.Value.(*node)
+			if n.expire != 0 && n.expire <= now {
+				continue
+			}
+			if !fn(n.entry()) {
+				return
+			}
+		}
+	}
+}
+
+// Peek returns the unexpired entry with the given key without counting
+// it as used
+func (c *LruCache) Peek(key string) (cache.Entry, bool) {
+	current, exists := c.kv[key]
+	if exists == false {
+		return cache.Entry{}, false
+	}
+	n := current.Value.(*node)
+	if n.expire != 0 && n.expire <= time.Now().Unix() {
+		return cache.Entry{}, false
+	}
+	return n.entry(), true
+}
+
+func (n *node) entry() cache.Entry {
+	return cache.Entry{
+		Key:      n.key,
+		Value:    n.value,
+		Expire:   n.expire,
+		Priority: n.priority,
+	}
+}
+
+// RemoveExpired removes the expired entries, returning their keys
+func (c *LruCache) RemoveExpired() []string {
+	now := time.Now().Unix()
+	var keys []string
+	for key, elem := range c.kv {
+		if n := elem.Value.(*node); n.expire != 0 && n.expire <= now {
+			c.Delete(key)
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
 // Delete entry with given key
 func (c *LruCache) Delete(key string) {
 	current, exists := c.kv[key]
 	if exists == true {
-		c.lruList.Remove(current)
+		n := current.Value.(*node)
+		c.listOf(n).Remove(current)
+		c.bytes -= len(n.key) + len(n.value)
 		delete(c.kv, key)
 	}
 }
@@ -0,0 +1,9 @@
+package lru
+
+import cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+
+func init() {
+	cache.Register("lru", func(capacity int, opts cache.Options) (cache.Cache, error) {
+		return Constructor(capacity), nil
+	})
+}
@@ -0,0 +1,196 @@
+package cache
+
+import (
+	"strconv"
+	"time"
+)
+
+// MutationOp is the kind of change a Mutation makes
+type MutationOp string
+
+// Mutation ops
+const (
+	SetMutation    MutationOp = "set"
+	DeleteMutation MutationOp = "delete"
+	ClearMutation  MutationOp = "clear"
+)
+
+// Mutation describes a change applied through the Adapter as the state
+// it results in, so that applying it elsewhere gives the same entry
+// regardless of the command that caused it. Evictions and expiry aren't
+// mutations, each cache does those on its own
+type Mutation struct {
+	Op MutationOp `json:"op"`
+	Entry
+}
+
+// OnMutation registers fn to be called after every change to the cache.
+// fn is called with the adapter locked, in the order the changes are
+// applied, so it must be quick and mustn't call back into the adapter
+func (cw *Adapter) OnMutation(fn func(Mutation)) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.listeners = append(cw.listeners, fn)
+}
+
+// SetWriteHook sets fn to be called after every set, touch and delete,
+// before the change is acknowledged or emitted. If fn returns an error
+// the change is undone and the command replies SERVER_ERROR, which is
+// how write-through stores refuse writes. Clearing the cache and Fill
+// don't call fn, they only change cached copies. fn is called with the adapter
+// locked, nil removes the hook
+func (cw *Adapter) SetWriteHook(fn func(Mutation) error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.writeHook = fn
+}
+
+func (cw *Adapter) emit(m Mutation) {
+	for _, fn := range cw.listeners {
+		fn(m)
+	}
+}
+
+// commit passes a change to the write hook, undoing it if the hook
+// fails, then emits it. Callers hold mu
+func (cw *Adapter) commit(m Mutation, undo func()) error {
+	if cw.writeHook != nil {
+		if err := cw.writeHook(m); err != nil {
+			undo()
+			return err
+		}
+	}
+	cw.emit(m)
+	return nil
+}
+
+// commitSet commits the stored state of key after a write. Callers hold mu
+func (cw *Adapter) commitSet(key, val string, exptime int, hints *Hints, undo func()) error {
+	if len(cw.listeners) == 0 && cw.writeHook == nil {
+		return nil
+	}
+	return cw.commit(cw.setMutation(key, val, exptime, hints), undo)
+}
+
+// emitSet emits the stored state of key after a write that isn't
+// committed. Callers hold mu
+func (cw *Adapter) emitSet(key, val string, exptime int, hints *Hints) {
+	if len(cw.listeners) > 0 {
+		cw.emit(cw.setMutation(key, val, exptime, hints))
+	}
+}
+
+// setMutation describes the stored state of key after a write. Callers
+// hold mu
+func (cw *Adapter) setMutation(key, val string, exptime int, hints *Hints) Mutation {
+	if pc, ok := cw.cache.(PeekCache); ok {
+		if e, exists := pc.Peek(key); exists {
+			return Mutation{Op: SetMutation, Entry: e}
+		}
+		return Mutation{Op: DeleteMutation, Entry: Entry{Key: key}}
+	}
+	// best effort for policies that can't peek
+	e := Entry{Key: key, Value: val}
+	if exptime > 0 {
+		e.Expire = time.Now().Unix() + int64(exptime)
+	}
+	if hints != nil {
+		e.Cost = hints.Cost
+		e.Priority = hints.Priority != nil && *hints.Priority
+	}
+	return Mutation{Op: SetMutation, Entry: e}
+}
+
+// undoer returns a func that restores the current state of key, for
+// when the write hook fails. Without a hook there's nothing to undo.
+// Callers hold mu
+func (cw *Adapter) undoer(key string) func() {
+	if cw.writeHook == nil {
+		return nil
+	}
+	var prev Entry
+	existed := false
+	if pc, ok := cw.cache.(PeekCache); ok {
+		prev, existed = pc.Peek(key)
+	}
+	// policies that can't peek lose the entry, so a value that wasn't
+	// persisted is never served
+	return func() {
+		cw.cache.Delete(key)
+		if !existed {
+			return
+		}
+		exptime := 0
+		if prev.Expire != 0 {
+			if exptime = int(prev.Expire - time.Now().Unix()); exptime < 1 {
+				return
+			}
+		}
+		if hc, ok := cw.cache.(HintedCache); ok {
+			hc.SetWithHints(key, prev.Value, exptime, prev.Cost, prev.Priority)
+		} else {
+			cw.cache.Set(key, prev.Value, exptime)
+		}
+	}
+}
+
+// Apply makes the change described by m, as produced by another
+// adapter's OnMutation. Entries that have expired in the meantime are
+// deleted instead
+func (cw *Adapter) Apply(m Mutation) Reply {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	switch m.Op {
+	case SetMutation:
+		exptime := 0
+		if m.Expire != 0 {
+			exptime = int(m.Expire - time.Now().Unix())
+			if exptime < 1 {
+				if err := cw.delete(m.Key); err != nil {
+					return ServerError(err.Error())
+				}
+				return DeletedReply
+			}
+		}
+		// replace so the entry doesn't keep the old expiry if it has none
+		cw.cache.Delete(m.Key)
+		return setReply(cw.set(m.Key, m.Value, exptime, &Hints{Cost: m.Cost, Priority: &m.Priority}))
+	case DeleteMutation:
+		if err := cw.delete(m.Key); err != nil {
+			return ServerError(err.Error())
+		}
+		return DeletedReply
+	case ClearMutation:
+		return cw.clear()
+	}
+	return ClientError("unknown mutation " + strconv.Quote(string(m.Op)))
+}
+
+// Snapshot returns every unexpired entry. mark, if not nil, is called
+// while the cache is locked, so whatever it records is consistent with
+// the snapshot, e.g. the position in a stream of mutations
+func (cw *Adapter) Snapshot(mark func()) ([]Entry, bool) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if mark != nil {
+		mark()
+	}
+	ranger, ok := cw.cache.(RangeCache)
+	if !ok {
+		return nil, false
+	}
+	var entries []Entry
+	ranger.Range(func(e Entry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	return entries, true
+}
+
+// AddStats registers fn to add to the stats returned by Stats. fn is
+// called without the adapter locked
+func (cw *Adapter) AddStats(fn func() map[string]string) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.statsSources = append(cw.statsSources, fn)
+}
@@ -0,0 +1,16 @@
+// Package policies registers the built-in cache policies with package
+// cache. Import it for its side effects:
+//
+//	import _ "github.com/nagamocha3000/go-memcached/pkg/cache/policies"
+//
+// In-house policies live in their own packages, register themselves the
+// same way and are imported alongside it
+package policies
+
+import (
+	// built-in policies
+	_ "github.com/nagamocha3000/go-memcached/pkg/cache/gdsf_cache"
+	_ "github.com/nagamocha3000/go-memcached/pkg/cache/lfu_cache"
+	_ "github.com/nagamocha3000/go-memcached/pkg/cache/lfu_lru_t_cache"
+	_ "github.com/nagamocha3000/go-memcached/pkg/cache/lru_cache"
+)
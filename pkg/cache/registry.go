@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Options are policy specific settings, given as name=value pairs.
+// Policies ignore the options they don't know, so that the same options
+// can be kept when migrating between policies
+type Options map[string]string
+
+// Int returns the named option as an int, or def if it's not set
+func (o Options) Int(name string, def int) (int, error) {
+	s, ok := o[name]
+	if !ok || s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("option %s: invalid integer %q", name, s)
+	}
+	return n, nil
+}
+
+// ParseOptions parses a comma separated list of name=value pairs
+func ParseOptions(s string) (Options, error) {
+	opts := Options{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid option %q, want name=value", pair)
+		}
+		opts[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	return opts, nil
+}
+
+// Constructor creates a cache policy holding at most capacity entries,
+// capacities less than 1 being unlimited
+type Constructor func(capacity int, opts Options) (Cache, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Constructor)
+	aliases    = make(map[string]string)
+)
+
+// ErrUnknownCacheType is returned for cache types that aren't registered
+var ErrUnknownCacheType = errors.New("unknown cache type")
+
+// Register makes a cache policy available under the given name. Policy
+// packages call it from their init function. It panics if a policy is
+// registered twice under the same name or the constructor is nil
+func Register(name string, ctor Constructor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if ctor == nil {
+		panic("cache: Register constructor is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("cache: Register called twice for policy " + name)
+	}
+	registry[name] = ctor
+}
+
+// RegisterAlias makes a registered policy also available under alias
+func RegisterAlias(alias, name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	aliases[alias] = name
+}
+
+// CacheTypes returns the sorted names of the registered cache policies
+func CacheTypes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookup resolves aliases and returns the policy's canonical name
+func lookup(cacheType string) (string, Constructor, error) {
+	registryMu.RLock()
+	if name, ok := aliases[cacheType]; ok {
+		cacheType = name
+	}
+	ctor, ok := registry[cacheType]
+	registryMu.RUnlock()
+	if !ok {
+		return "", nil, fmt.Errorf("%w %q, must be one of %s",
+			ErrUnknownCacheType, cacheType, strings.Join(CacheTypes(), ", "))
+	}
+	return cacheType, ctor, nil
+}
+
+// NewPolicy creates a bare cache policy of the given registered type,
+// without the locking and request parsing of an Adapter
+func NewPolicy(cacheType string, capacity int, opts Options) (Cache, error) {
+	_, c, err := newPolicy(cacheType, capacity, opts)
+	return c, err
+}
+
+func newPolicy(cacheType string, capacity int, opts Options) (string, Cache, error) {
+	name, ctor, err := lookup(cacheType)
+	if err != nil {
+		return "", nil, err
+	}
+	c, err := ctor(capacity, opts)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return name, c, nil
+}
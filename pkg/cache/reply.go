@@ -13,4 +13,23 @@ const (
 	NotFoundReply             = "NOT_FOUND"
 	DeletedReply              = "DELETED"
 	ClientErrorReply          = "CLIENT_ERROR"
+	OkReply                   = "OK"
+	TouchedReply              = "TOUCHED"
+	ExistsReply               = "EXISTS"
+	ServerErrorReply          = "SERVER_ERROR"
+	HotMissReply              = "HOT_MISS"
+	StaleReply                = "STALE"
+	RefreshReply              = "REFRESH"
+	ThrottledReply            = "THROTTLED"
 )
+
+// ClientError returns a CLIENT_ERROR reply explaining what was wrong
+func ClientError(msg string) Reply {
+	return Reply(ClientErrorReply + " " + msg)
+}
+
+// ServerError returns a SERVER_ERROR reply for failures that aren't the
+// client's fault, e.g. of a backing store
+func ServerError(msg string) Reply {
+	return Reply(ServerErrorReply + " " + msg)
+}
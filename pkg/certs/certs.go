@@ -0,0 +1,194 @@
+// Package certs serves TLS with certificates that are reloaded when their
+// files change, optionally requiring clients to present certificates too
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// checkInterval is how often the files are checked for changes, at most
+const checkInterval = time.Second
+
+// Options configures a Reloader
+type Options struct {
+	// CertFile and KeyFile are the PEM encoded certificate, chain
+	// included, and its private key
+	CertFile string
+	KeyFile  string
+	// ClientCAFile, if given, has the PEM encoded CAs that clients'
+	// certificates must be signed by, and that are required. It's also
+	// what servers are verified against by ClientConfig
+	ClientCAFile string
+	ErrorLog     *log.Logger
+}
+
+// Reloader loads the certificates of Options, and loads them again on
+// the first handshake after one of their files changes. Failed reloads
+// are logged and the previous certificates kept. It's safe for
+// concurrent use
+type Reloader struct {
+	opts Options
+
+	mu           sync.Mutex
+	cert         *tls.Certificate
+	clientCAs    *x509.CertPool
+	modTimes     []time.Time
+	checked      time.Time
+	reloads      int64
+	reloadErrors int64
+}
+
+// New returns a Reloader of the certificates, failing if they can't be
+// loaded
+func New(opts Options) (*Reloader, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("certs: both a certificate and a key are needed")
+	}
+	if opts.ErrorLog == nil {
+		opts.ErrorLog = log.New(ioutil.Discard, "", 0)
+	}
+	r := &Reloader{opts: opts}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.ClientCAFile != "" {
+		files = append(files, r.opts.ClientCAFile)
+	}
+	return files
+}
+
+// load reads the files, the caller holding mu
+func (r *Reloader) load() error {
+	var modTimes []time.Time
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("certs: %w", err)
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("certs: %w", err)
+	}
+	if cert.Leaf == nil {
+		cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	}
+	var clientCAs *x509.CertPool
+	if r.opts.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("certs: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("certs: no certificates in %s", r.opts.ClientCAFile)
+		}
+	}
+	r.cert, r.clientCAs, r.modTimes = &cert, clientCAs, modTimes
+	return nil
+}
+
+// maybeReload reloads the files if they changed since last loaded,
+// checking at most once every checkInterval
+func (r *Reloader) maybeReload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) < checkInterval {
+		return
+	}
+	r.checked = time.Now()
+	changed := false
+	for i, file := range r.files() {
+		info, err := os.Stat(file)
+		if err == nil && !info.ModTime().Equal(r.modTimes[i]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := r.load(); err != nil {
+		r.reloadErrors++
+		r.opts.ErrorLog.Printf("reloading certificates, keeping the previous ones: %s", err)
+		return
+	}
+	r.reloads++
+}
+
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.maybeReload()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, r.clientCAs
+}
+
+// ServerConfig returns the TLS config of a server using the current
+// certificates
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// set so http.Server.ListenAndServeTLS doesn't look for files,
+		// though GetConfigForClient's config is the one used
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, clientCAs := r.current()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if clientCAs != nil {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				config.ClientCAs = clientCAs
+			}
+			return config, nil
+		},
+	}
+}
+
+// ClientConfig returns the TLS config for connecting to other servers,
+// presenting the current certificate and verifying theirs against the
+// client CAs as of the call, if any, else the system's
+func (r *Reloader) ClientConfig() *tls.Config {
+	_, clientCAs := r.current()
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    clientCAs,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+	}
+}
+
+// Stats reports the reloads and when the current certificate expires
+func (r *Reloader) Stats() map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := map[string]string{
+		"tls_reloads":       strconv.FormatInt(r.reloads, 10),
+		"tls_reload_errors": strconv.FormatInt(r.reloadErrors, 10),
+		"tls_client_auth":   strconv.FormatBool(r.clientCAs != nil),
+	}
+	if r.cert.Leaf != nil {
+		stats["tls_cert_expiry"] = strconv.FormatInt(r.cert.Leaf.NotAfter.Unix(), 10)
+	}
+	return stats
+}
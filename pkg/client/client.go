@@ -0,0 +1,413 @@
+// Package client is a Go client for the go-memcached HTTP API
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nagamocha3000/go-memcached/pkg/auth"
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	"github.com/nagamocha3000/go-memcached/pkg/membership"
+)
+
+// Options configures a Client
+type Options struct {
+	// Timeout applies to calls whose context has no deadline. Zero for none
+	Timeout time.Duration
+	// MaxIdleConns is the number of idle connections kept open to the
+	// server. Defaults to 16
+	MaxIdleConns int
+	// IdleConnTimeout closes pooled connections idle for longer. Defaults
+	// to 90 seconds
+	IdleConnTimeout time.Duration
+	// Transport replaces the pooled transport, e.g. for TLS settings
+	Transport http.RoundTripper
+	// Token, or else Username and Password, authenticate the client to
+	// servers requiring it
+	Token    string
+	Username string
+	Password string
+}
+
+// Authorization returns the Authorization header for the credentials, if any
+func (opts Options) Authorization() string {
+	if opts.Token != "" {
+		return auth.Bearer(opts.Token)
+	}
+	if opts.Username != "" {
+		return auth.Basic(opts.Username, opts.Password)
+	}
+	return ""
+}
+
+// Client talks to a single go-memcached server. It's safe for concurrent
+// use and keeps a pool of connections to the server
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	timeout    time.Duration
+}
+
+// NeverExpire is the Expiration of items kept until they're evicted, even
+// if the server has a default TTL
+const NeverExpire = -1
+
+// Item is a value to store
+type Item struct {
+	Key   string
+	Value string
+	// Expiration is the TTL in seconds. Zero for the server's default
+	// TTL, if it has one, or NeverExpire for none
+	Expiration int
+	// Cost and Priority are eviction hints, see cache.Hints
+	Cost     int
+	Priority bool
+}
+
+// New returns a client for the server at addr, e.g. "http://localhost:4000"
+// or "localhost:4000"
+func New(addr string, opts Options) (*Client, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("client: invalid server address %q", addr)
+	}
+	transport := opts.Transport
+	if transport == nil {
+		maxIdle := opts.MaxIdleConns
+		if maxIdle < 1 {
+			maxIdle = 16
+		}
+		idleTimeout := opts.IdleConnTimeout
+		if idleTimeout <= 0 {
+			idleTimeout = 90 * time.Second
+		}
+		transport = &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConns:        maxIdle,
+			MaxIdleConnsPerHost: maxIdle,
+			IdleConnTimeout:     idleTimeout,
+		}
+	}
+	if authorization := opts.Authorization(); authorization != "" {
+		transport = &auth.Transport{Authorization: authorization, Base: transport}
+	}
+	return &Client{
+		baseURL:    strings.TrimSuffix(u.String(), "/"),
+		httpClient: &http.Client{Transport: transport},
+		timeout:    opts.Timeout,
+	}, nil
+}
+
+// Addr returns the server's base URL
+func (c *Client) Addr() string {
+	return c.baseURL
+}
+
+// response holds every field the server may reply with
+type response struct {
+	Reply string            `json:"reply"`
+	Val   string            `json:"val"`
+	Token string            `json:"token"`
+	Vals  map[string]string `json:"vals"`
+	// Replies has the replies of the values of an mget past their TTL
+	Replies map[string]string `json:"replies"`
+	Stats   map[string]string `json:"stats"`
+	Stale   bool              `json:"stale"`
+
+	Members []membership.Member `json:"members"`
+}
+
+// do sends the command and decodes the reply. The error is nil only for
+// successful replies
+func (c *Client) do(ctx context.Context, path string, params url.Values) (*response, error) {
+	if c.timeout > 0 {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+		}
+	}
+	u := c.baseURL + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return nil, ErrUnauthorized
+	case http.StatusForbidden:
+		return nil, ErrForbidden
+	case http.StatusTooManyRequests:
+		return nil, ErrThrottled
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("client: %s %s: %s", req.Method, path, resp.Status)
+	}
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("client: decoding reply: %w", err)
+	}
+	return &r, replyError(r.Reply)
+}
+
+// keyPath returns the path of a command on key. The server's router
+// query unescapes path parameters, turning + into a space, so the key is
+// escaped as a query value would be but with spaces as %20
+func keyPath(cmd, key string) string {
+	return "/" + cmd + "/" + strings.Replace(url.QueryEscape(key), "+", "%20", -1)
+}
+
+// Get returns the value of key, or ErrNotFound. Values past their TTL
+// but within the server's grace period are returned too, see GetValue
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	r, err := c.do(ctx, keyPath("get", key), nil)
+	if err != nil {
+		return "", err
+	}
+	return r.Val, nil
+}
+
+// Value is a value along with its freshness
+type Value struct {
+	Value string
+	// Stale is set for values past their TTL, served during the server's
+	// grace period
+	Stale bool
+	// Refresh is set when this client was chosen to set a new value for
+	// the stale one
+	Refresh bool
+}
+
+// GetValue is like Get, but also reports whether the value is stale and
+// should be refreshed by the caller
+func (c *Client) GetValue(ctx context.Context, key string) (*Value, error) {
+	r, err := c.do(ctx, keyPath("get", key), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Value{
+		Value:   r.Val,
+		Stale:   r.Reply == string(cache.StaleReply) || r.Reply == string(cache.RefreshReply),
+		Refresh: r.Reply == string(cache.RefreshReply),
+	}, nil
+}
+
+// GetMulti returns the values of the keys that are present
+func (c *Client) GetMulti(ctx context.Context, keys []string) (map[string]string, error) {
+	if len(keys) == 0 {
+		return map[string]string{}, nil
+	}
+	r, err := c.do(ctx, "/mget", url.Values{"key": keys})
+	if err != nil {
+		return nil, err
+	}
+	if r.Vals == nil {
+		r.Vals = map[string]string{}
+	}
+	return r.Vals, nil
+}
+
+// GetMultiValues is like GetMulti, but also reports whether each value
+// is stale and should be refreshed by the caller, see GetValue
+func (c *Client) GetMultiValues(ctx context.Context, keys []string) (map[string]*Value, error) {
+	values := make(map[string]*Value, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+	r, err := c.do(ctx, "/mget", url.Values{"key": keys})
+	if err != nil {
+		return nil, err
+	}
+	for key, val := range r.Vals {
+		reply := cache.Reply(r.Replies[key])
+		values[key] = &Value{
+			Value:   val,
+			Stale:   reply == cache.StaleReply || reply == cache.RefreshReply,
+			Refresh: reply == cache.RefreshReply,
+		}
+	}
+	return values, nil
+}
+
+func itemParams(item *Item) url.Values {
+	params := url.Values{}
+	params.Set("val", item.Value)
+	switch {
+	case item.Expiration == NeverExpire:
+		params.Set("exp", "0")
+	case item.Expiration != 0:
+		params.Set("exp", strconv.Itoa(item.Expiration))
+	}
+	if item.Cost > 0 {
+		params.Set("cost", strconv.Itoa(item.Cost))
+	}
+	if item.Priority {
+		params.Set("priority", "true")
+	}
+	return params
+}
+
+func (c *Client) store(ctx context.Context, cmd string, item *Item) error {
+	_, err := c.do(ctx, keyPath(cmd, item.Key), itemParams(item))
+	return err
+}
+
+// Set stores the item
+func (c *Client) Set(ctx context.Context, item *Item) error {
+	return c.store(ctx, "set", item)
+}
+
+// Add stores the item only if its key isn't present, else ErrNotStored
+func (c *Client) Add(ctx context.Context, item *Item) error {
+	return c.store(ctx, "add", item)
+}
+
+// Replace stores the item only if its key is present, else ErrNotStored
+func (c *Client) Replace(ctx context.Context, item *Item) error {
+	return c.store(ctx, "replace", item)
+}
+
+// Append adds value to the end of an existing item, else ErrNotStored
+func (c *Client) Append(ctx context.Context, key, value string) error {
+	_, err := c.do(ctx, keyPath("append", key), url.Values{"val": {value}})
+	return err
+}
+
+// Prepend adds value to the start of an existing item, else ErrNotStored
+func (c *Client) Prepend(ctx context.Context, key, value string) error {
+	_, err := c.do(ctx, keyPath("prepend", key), url.Values{"val": {value}})
+	return err
+}
+
+func (c *Client) incrDecr(ctx context.Context, cmd, key string, delta int) (int, error) {
+	r, err := c.do(ctx, keyPath(cmd, key), url.Values{"num": {strconv.Itoa(delta)}})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(r.Val)
+}
+
+// Incr adds delta to a numeric item and returns the new value
+func (c *Client) Incr(ctx context.Context, key string, delta int) (int, error) {
+	return c.incrDecr(ctx, "increment", key, delta)
+}
+
+// Decr subtracts delta from a numeric item and returns the new value
+func (c *Client) Decr(ctx context.Context, key string, delta int) (int, error) {
+	return c.incrDecr(ctx, "decrement", key, delta)
+}
+
+// Gets returns the value of key along with a token for CAS
+func (c *Client) Gets(ctx context.Context, key string) (string, string, error) {
+	r, err := c.do(ctx, keyPath("gets", key), nil)
+	if err != nil {
+		return "", "", err
+	}
+	return r.Val, r.Token, nil
+}
+
+// CAS stores the item only if it wasn't modified since token was fetched
+// with Gets, else ErrCASConflict
+func (c *Client) CAS(ctx context.Context, item *Item, token string) error {
+	params := itemParams(item)
+	params.Set("token", token)
+	_, err := c.do(ctx, keyPath("cas", item.Key), params)
+	return err
+}
+
+// Touch sets a new expiration, in seconds, on an existing item
+func (c *Client) Touch(ctx context.Context, key string, expiration int) error {
+	_, err := c.do(ctx, keyPath("touch", key), url.Values{"exp": {strconv.Itoa(expiration)}})
+	return err
+}
+
+// Delete removes the item, or returns ErrNotFound
+func (c *Client) Delete(ctx context.Context, key string) error {
+	_, err := c.do(ctx, keyPath("delete", key), nil)
+	return err
+}
+
+// Flush removes every item
+func (c *Client) Flush(ctx context.Context) error {
+	_, err := c.do(ctx, "/clear", nil)
+	return err
+}
+
+// Stats returns the server's statistics
+func (c *Client) Stats(ctx context.Context) (map[string]string, error) {
+	r, err := c.do(ctx, "/stats", nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.Stats, nil
+}
+
+// Members returns the cluster members known to the server, if it runs
+// in cluster mode. The APIAddr of the live ones can be added to a Ring
+func (c *Client) Members(ctx context.Context) ([]membership.Member, error) {
+	r, err := c.do(ctx, "/cluster/members", nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.Members, nil
+}
+
+// Lease is the result of a LeaseGet
+type Lease struct {
+	// Value is the value of the key if found, else a recently deleted
+	// value if Stale
+	Value string
+	Found bool
+	Stale bool
+	// Token is set when the client was given the lease to fill the key
+	// with LeaseSet, on a miss or, with Found and Stale, for a value past
+	// its TTL that the client should refresh
+	Token string
+}
+
+// LeaseGet gets the value of key, getting a lease to fill it on a miss.
+// It returns ErrHotMiss, along with any stale value, if another client
+// holds the lease, in which case the caller should retry shortly
+func (c *Client) LeaseGet(ctx context.Context, key string) (*Lease, error) {
+	r, err := c.do(ctx, keyPath("lget", key), nil)
+	switch {
+	case err == nil:
+		return &Lease{Value: r.Val, Found: true, Stale: r.Stale, Token: r.Token}, nil
+	case errors.Is(err, ErrNotFound):
+		return &Lease{Value: r.Val, Stale: r.Stale, Token: r.Token}, nil
+	case errors.Is(err, ErrHotMiss):
+		return &Lease{Value: r.Val, Stale: r.Stale}, err
+	}
+	return nil, err
+}
+
+// LeaseSet stores the item with the token of a lease from LeaseGet. It
+// returns ErrNotStored if the lease was invalidated by a set or delete
+// of the key in the meantime
+func (c *Client) LeaseSet(ctx context.Context, item *Item, token string) error {
+	params := itemParams(item)
+	params.Set("token", token)
+	_, err := c.do(ctx, keyPath("lset", item.Key), params)
+	return err
+}
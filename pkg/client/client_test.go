@@ -0,0 +1,182 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/pat"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *httptest.Server) {
+	srv := httptest.NewServer(handler)
+	c, err := New(srv.URL, Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, srv
+}
+
+func TestClientReplies(t *testing.T) {
+	replies := map[string]string{
+		"/get/hit":         `{"reply":"VALUE","val":"v"}`,
+		"/get/miss":        `{"reply":"NOT_FOUND","val":""}`,
+		"/add/k":           `{"reply":"NOT_STORED"}`,
+		"/cas/k":           `{"reply":"EXISTS"}`,
+		"/set/k":           `{"reply":"CLIENT_ERROR key too long"}`,
+		"/increment/n":     `{"reply":"STORED","val":"42"}`,
+		"/mget":            `{"reply":"VALUE","vals":{"a":"1","b":"2"},"replies":{"b":"STALE"}}`,
+		"/stats":           `{"reply":"OK","stats":{"curr_items":"1"}}`,
+		"/get/a%2Fb%20c":   `{"reply":"VALUE","val":"escaped"}`,
+		"/delete/anything": `{"reply":"SOMETHING_NEW"}`,
+		"/lget/miss":       `{"reply":"NOT_FOUND","val":"old","token":"t1","stale":true}`,
+		"/lget/hot":        `{"reply":"HOT_MISS","val":""}`,
+		"/lget/stale":      `{"reply":"REFRESH","val":"old","token":"t2","stale":true}`,
+		"/get/stale":       `{"reply":"REFRESH","val":"old"}`,
+		"/get/throttled":   `{"reply":"THROTTLED"}`,
+	}
+	c, srv := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/get/throttled" {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+		w.Write([]byte(replies[r.URL.EscapedPath()]))
+	})
+	defer srv.Close()
+	ctx := context.Background()
+
+	if val, err := c.Get(ctx, "hit"); err != nil || val != "v" {
+		t.Errorf("Get hit: got %q, %v", val, err)
+	}
+	if _, err := c.Get(ctx, "miss"); err != ErrNotFound {
+		t.Errorf("Get miss: got %v, want ErrNotFound", err)
+	}
+	if err := c.Add(ctx, &Item{Key: "k"}); err != ErrNotStored {
+		t.Errorf("Add: got %v, want ErrNotStored", err)
+	}
+	if err := c.CAS(ctx, &Item{Key: "k"}, "1"); err != ErrCASConflict {
+		t.Errorf("CAS: got %v, want ErrCASConflict", err)
+	}
+	var clientErr *ClientError
+	if err := c.Set(ctx, &Item{Key: "k"}); !errors.As(err, &clientErr) || clientErr.Message != "key too long" {
+		t.Errorf("Set: got %v, want ClientError", err)
+	}
+	if n, err := c.Incr(ctx, "n", 2); err != nil || n != 42 {
+		t.Errorf("Incr: got %d, %v", n, err)
+	}
+	if vals, err := c.GetMulti(ctx, []string{"a", "b"}); err != nil || !reflect.DeepEqual(vals, map[string]string{"a": "1", "b": "2"}) {
+		t.Errorf("GetMulti: got %v, %v", vals, err)
+	}
+	wantValues := map[string]*Value{"a": {"1", false, false}, "b": {"2", true, false}}
+	if values, err := c.GetMultiValues(ctx, []string{"a", "b"}); err != nil || !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("GetMultiValues: got %v, %v", values, err)
+	}
+	if st, err := c.Stats(ctx); err != nil || st["curr_items"] != "1" {
+		t.Errorf("Stats: got %v, %v", st, err)
+	}
+	if val, err := c.Get(ctx, "a/b c"); err != nil || val != "escaped" {
+		t.Errorf("Get escaped key: got %q, %v", val, err)
+	}
+	var unexpected *UnexpectedReplyError
+	if err := c.Delete(ctx, "anything"); !errors.As(err, &unexpected) {
+		t.Errorf("Delete: got %v, want UnexpectedReplyError", err)
+	}
+	want := &Lease{Value: "old", Stale: true, Token: "t1"}
+	if lease, err := c.LeaseGet(ctx, "miss"); err != nil || !reflect.DeepEqual(lease, want) {
+		t.Errorf("LeaseGet miss: got %+v, %v", lease, err)
+	}
+	want = &Lease{Value: "old", Found: true, Stale: true, Token: "t2"}
+	if lease, err := c.LeaseGet(ctx, "stale"); err != nil || !reflect.DeepEqual(lease, want) {
+		t.Errorf("LeaseGet stale: got %+v, %v", lease, err)
+	}
+	if _, err := c.LeaseGet(ctx, "hot"); err != ErrHotMiss {
+		t.Errorf("LeaseGet hot: got %v, want ErrHotMiss", err)
+	}
+	if v, err := c.GetValue(ctx, "stale"); err != nil || !reflect.DeepEqual(v, &Value{"old", true, true}) {
+		t.Errorf("GetValue stale: got %+v, %v", v, err)
+	}
+	if _, err := c.Get(ctx, "throttled"); err != ErrThrottled {
+		t.Errorf("Get throttled: got %v, want ErrThrottled", err)
+	}
+}
+
+func TestItemExpiration(t *testing.T) {
+	tests := []struct {
+		expiration int
+		want       []string
+	}{
+		{0, nil}, // left to the server's default TTL
+		{NeverExpire, []string{"0"}},
+		{60, []string{"60"}},
+	}
+	var got []string
+	c, srv := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query()["exp"]
+		w.Write([]byte(`{"reply":"STORED"}`))
+	})
+	defer srv.Close()
+	for _, tt := range tests {
+		if err := c.Set(context.Background(), &Item{Key: "k", Expiration: tt.expiration}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expiration %d: got exp %q, want %q", tt.expiration, got, tt.want)
+		}
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	c, srv := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+}
+
+func TestKeysRoundTrip(t *testing.T) {
+	// the server's router, which unescapes keys as query values
+	vals := make(map[string]string)
+	mux := pat.New()
+	mux.Get("/set/:key", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vals[r.URL.Query().Get(":key")] = r.URL.Query().Get("val")
+		w.Write([]byte(`{"reply":"STORED"}`))
+	}))
+	mux.Get("/get/:key", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		val, ok := vals[r.URL.Query().Get(":key")]
+		if !ok {
+			w.Write([]byte(`{"reply":"NOT_FOUND"}`))
+			return
+		}
+		b, _ := json.Marshal(map[string]string{"reply": "VALUE", "val": val})
+		w.Write(b)
+	}))
+	c, srv := newTestClient(t, mux.ServeHTTP)
+	defer srv.Close()
+	ctx := context.Background()
+
+	keys := []string{"a+b", "a b", "a%b", "%2B", "a/b", "a?b&c=d", "a#b", "é", "100%"}
+	for _, key := range keys {
+		if err := c.Set(ctx, &Item{Key: key, Value: "value of " + key}); err != nil {
+			t.Fatalf("Set %q: %v", key, err)
+		}
+	}
+	if len(vals) != len(keys) {
+		t.Errorf("got %d keys stored, want %d: %q", len(vals), len(keys), vals)
+	}
+	for _, key := range keys {
+		if val, err := c.Get(ctx, key); err != nil || val != "value of "+key {
+			t.Errorf("Get %q: got %q, %v", key, val, err)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package client
+
+import (
+	"context"
+	"sync"
+)
+
+// Cluster spreads keys across several servers using a Ring. It has the
+// same API as Client, multi-key and server-wide commands are fanned out
+// to the servers concurrently
+type Cluster struct {
+	ring *Ring
+	opts Options
+
+	mu      sync.Mutex
+	clients map[string]*Client
+}
+
+// NewCluster returns a client for the given nodes. With failover, keys
+// of nodes marked dead go to the next live node
+func NewCluster(failover bool, opts Options, nodes ...Node) *Cluster {
+	return &Cluster{
+		ring:    NewRing(failover, nodes...),
+		opts:    opts,
+		clients: make(map[string]*Client),
+	}
+}
+
+// Ring returns the cluster's ring, to add, remove or mark nodes dead
+func (cl *Cluster) Ring() *Ring {
+	return cl.ring
+}
+
+// Client returns the client of the node at addr
+func (cl *Cluster) Client(addr string) (*Client, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if c, ok := cl.clients[addr]; ok {
+		return c, nil
+	}
+	c, err := New(addr, cl.opts)
+	if err != nil {
+		return nil, err
+	}
+	cl.clients[addr] = c
+	return c, nil
+}
+
+func (cl *Cluster) pick(key string) (*Client, error) {
+	addr, err := cl.ring.Pick(key)
+	if err != nil {
+		return nil, err
+	}
+	return cl.Client(addr)
+}
+
+// Get returns the value of key, or ErrNotFound
+func (cl *Cluster) Get(ctx context.Context, key string) (string, error) {
+	c, err := cl.pick(key)
+	if err != nil {
+		return "", err
+	}
+	return c.Get(ctx, key)
+}
+
+// GetMulti returns the values of the keys that are present, fetching
+// them from all the owning nodes concurrently
+func (cl *Cluster) GetMulti(ctx context.Context, keys []string) (map[string]string, error) {
+	byNode := make(map[string][]string)
+	for _, key := range keys {
+		addr, err := cl.ring.Pick(key)
+		if err != nil {
+			return nil, err
+		}
+		byNode[addr] = append(byNode[addr], key)
+	}
+	vals := make(map[string]string, len(keys))
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for addr, nodeKeys := range byNode {
+		wg.Add(1)
+		go func(addr string, nodeKeys []string) {
+			defer wg.Done()
+			c, err := cl.Client(addr)
+			var nodeVals map[string]string
+			if err == nil {
+				nodeVals, err = c.GetMulti(ctx, nodeKeys)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for k, v := range nodeVals {
+				vals[k] = v
+			}
+		}(addr, nodeKeys)
+	}
+	wg.Wait()
+	return vals, firstErr
+}
+
+// Set stores the item
+func (cl *Cluster) Set(ctx context.Context, item *Item) error {
+	c, err := cl.pick(item.Key)
+	if err != nil {
+		return err
+	}
+	return c.Set(ctx, item)
+}
+
+// Add stores the item only if its key isn't present, else ErrNotStored
+func (cl *Cluster) Add(ctx context.Context, item *Item) error {
+	c, err := cl.pick(item.Key)
+	if err != nil {
+		return err
+	}
+	return c.Add(ctx, item)
+}
+
+// Replace stores the item only if its key is present, else ErrNotStored
+func (cl *Cluster) Replace(ctx context.Context, item *Item) error {
+	c, err := cl.pick(item.Key)
+	if err != nil {
+		return err
+	}
+	return c.Replace(ctx, item)
+}
+
+// Append adds value to the end of an existing item, else ErrNotStored
+func (cl *Cluster) Append(ctx context.Context, key, value string) error {
+	c, err := cl.pick(key)
+	if err != nil {
+		return err
+	}
+	return c.Append(ctx, key, value)
+}
+
+// Prepend adds value to the start of an existing item, else ErrNotStored
+func (cl *Cluster) Prepend(ctx context.Context, key, value string) error {
+	c, err := cl.pick(key)
+	if err != nil {
+		return err
+	}
+	return c.Prepend(ctx, key, value)
+}
+
+// Incr adds delta to a numeric item and returns the new value
+func (cl *Cluster) Incr(ctx context.Context, key string, delta int) (int, error) {
+	c, err := cl.pick(key)
+	if err != nil {
+		return 0, err
+	}
+	return c.Incr(ctx, key, delta)
+}
+
+// Decr subtracts delta from a numeric item and returns the new value
+func (cl *Cluster) Decr(ctx context.Context, key string, delta int) (int, error) {
+	c, err := cl.pick(key)
+	if err != nil {
+		return 0, err
+	}
+	return c.Decr(ctx, key, delta)
+}
+
+// Gets returns the value of key along with a token for CAS
+func (cl *Cluster) Gets(ctx context.Context, key string) (string, string, error) {
+	c, err := cl.pick(key)
+	if err != nil {
+		return "", "", err
+	}
+	return c.Gets(ctx, key)
+}
+
+// CAS stores the item only if it wasn't modified since token was fetched
+// with Gets, else ErrCASConflict
+func (cl *Cluster) CAS(ctx context.Context, item *Item, token string) error {
+	c, err := cl.pick(item.Key)
+	if err != nil {
+		return err
+	}
+	return c.CAS(ctx, item, token)
+}
+
+// Touch sets a new expiration, in seconds, on an existing item
+func (cl *Cluster) Touch(ctx context.Context, key string, expiration int) error {
+	c, err := cl.pick(key)
+	if err != nil {
+		return err
+	}
+	return c.Touch(ctx, key, expiration)
+}
+
+// Delete removes the item, or returns ErrNotFound
+func (cl *Cluster) Delete(ctx context.Context, key string) error {
+	c, err := cl.pick(key)
+	if err != nil {
+		return err
+	}
+	return c.Delete(ctx, key)
+}
+
+// eachNode calls fn concurrently for every live node, returning the
+// first error
+func (cl *Cluster) eachNode(fn func(addr string, c *Client) error) error {
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for _, n := range cl.ring.Nodes() {
+		if cl.ring.IsDead(n.Addr) {
+			continue
+		}
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			c, err := cl.Client(addr)
+			if err == nil {
+				err = fn(addr, c)
+			}
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(n.Addr)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// Flush removes every item from every live node
+func (cl *Cluster) Flush(ctx context.Context) error {
+	return cl.eachNode(func(addr string, c *Client) error {
+		return c.Flush(ctx)
+	})
+}
+
+// Stats returns the statistics of every live node, by address
+func (cl *Cluster) Stats(ctx context.Context) (map[string]map[string]string, error) {
+	var mu sync.Mutex
+	stats := make(map[string]map[string]string)
+	err := cl.eachNode(func(addr string, c *Client) error {
+		st, err := c.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		stats[addr] = st
+		mu.Unlock()
+		return nil
+	})
+	return stats, err
+}
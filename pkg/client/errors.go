@@ -0,0 +1,88 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+)
+
+// Errors mapped from the server's replies
+var (
+	// ErrNotFound is returned when the key isn't in the cache
+	ErrNotFound = errors.New("client: not found")
+	// ErrNotStored is returned when an add, replace, append or prepend
+	// condition wasn't met
+	ErrNotStored = errors.New("client: not stored")
+	// ErrCASConflict is returned when the item was modified since its
+	// token was fetched
+	ErrCASConflict = errors.New("client: compare-and-swap conflict")
+	// ErrNotImplemented is returned for commands the server doesn't support
+	ErrNotImplemented = errors.New("client: not implemented by server")
+	// ErrServer is returned when the server replies with a generic error
+	ErrServer = errors.New("client: server error")
+	// ErrHotMiss is returned by LeaseGet when another client holds the
+	// lease on the missing key
+	ErrHotMiss = errors.New("client: hot miss")
+	// ErrUnauthorized is returned when the server requires credentials
+	// and the client's are missing or wrong
+	ErrUnauthorized = errors.New("client: unauthorized")
+	// ErrForbidden is returned when the client's user isn't allowed the
+	// command or key
+	ErrForbidden = errors.New("client: forbidden")
+	// ErrThrottled is returned when the client made more requests than
+	// the server's rate limit allows, and should retry later
+	ErrThrottled = errors.New("client: throttled")
+)
+
+// ClientError is returned when the server rejects a request as invalid
+type ClientError struct {
+	Message string
+}
+
+func (e *ClientError) Error() string {
+	if e.Message == "" {
+		return "client: invalid request"
+	}
+	return "client: invalid request: " + e.Message
+}
+
+// UnexpectedReplyError is returned for replies the client doesn't know
+type UnexpectedReplyError struct {
+	Reply string
+}
+
+func (e *UnexpectedReplyError) Error() string {
+	return "client: unexpected reply " + e.Reply
+}
+
+// replyError maps a reply to its error, nil for successful replies
+func replyError(reply string) error {
+	switch cache.Reply(reply) {
+	case cache.StoredReply, cache.ValueReply, cache.DeletedReply,
+		cache.OkReply, cache.TouchedReply, cache.StaleReply, cache.RefreshReply:
+		return nil
+	case cache.NotFoundReply:
+		return ErrNotFound
+	case cache.NotStoredReply:
+		return ErrNotStored
+	case cache.ExistsReply:
+		return ErrCASConflict
+	case cache.NotImplementedReply:
+		return ErrNotImplemented
+	case cache.ErrReply:
+		return ErrServer
+	case cache.HotMissReply:
+		return ErrHotMiss
+	case cache.ThrottledReply:
+		return ErrThrottled
+	}
+	if reply == cache.ClientErrorReply || strings.HasPrefix(reply, cache.ClientErrorReply+" ") {
+		return &ClientError{Message: strings.TrimSpace(strings.TrimPrefix(reply, cache.ClientErrorReply))}
+	}
+	if reply == cache.ServerErrorReply || strings.HasPrefix(reply, cache.ServerErrorReply+" ") {
+		return fmt.Errorf("%w: %s", ErrServer, strings.TrimSpace(strings.TrimPrefix(reply, cache.ServerErrorReply)))
+	}
+	return &UnexpectedReplyError{Reply: reply}
+}
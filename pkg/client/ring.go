@@ -0,0 +1,186 @@
+package client
+
+import (
+	"crypto/md5"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+/*
+Ring maps keys to nodes using ketama consistent hashing, so that it
+agrees with other ketama clients given the same node addresses and weights.
+Each node is placed on a circle of uint32 hashes at several points
+(virtual nodes), four per md5 digest of "addr-i", with the number of
+points proportional to its share of the total weight. A key belongs to the
+node of the first point at or after the key's hash, wrapping around.
+Adding or removing a node hence only moves the keys that land on that
+node's points, roughly 1/n of them.
+Nodes can be marked dead. Lookups for keys owned by a dead node fail,
+unless failover is enabled, in which case they go to the next live node on
+the circle. Marking a node dead doesn't move any other keys.
+*/
+type Ring struct {
+	mu       sync.RWMutex
+	nodes    map[string]*ringNode
+	points   []point
+	failover bool
+}
+
+// Node is a server taking part in the ring
+type Node struct {
+	Addr string
+	// Weight is the node's relative share of the keys. Defaults to 1
+	Weight int
+}
+
+type ringNode struct {
+	weight int
+	dead   bool
+}
+
+type point struct {
+	hash uint32
+	addr string
+}
+
+// pointsPerServer is libketama's number of md5 digests per server at
+// equal weights, each giving four points
+const pointsPerServer = 40
+
+// Errors returned by Ring lookups
+var (
+	ErrNoNodes  = errors.New("client: no nodes in ring")
+	ErrNodeDead = errors.New("client: node owning key is dead")
+)
+
+// NewRing returns a ring of the given nodes. With failover, keys of dead
+// nodes go to the next live node instead of failing
+func NewRing(failover bool, nodes ...Node) *Ring {
+	r := &Ring{
+		nodes:    make(map[string]*ringNode),
+		failover: failover,
+	}
+	for _, n := range nodes {
+		r.nodes[n.Addr] = &ringNode{weight: weightOf(n)}
+	}
+	r.rebuild()
+	return r
+}
+
+func weightOf(n Node) int {
+	if n.Weight < 1 {
+		return 1
+	}
+	return n.Weight
+}
+
+// Add adds a node, or updates its weight if it's already in the ring
+func (r *Ring) Add(n Node) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rn, ok := r.nodes[n.Addr]; ok {
+		rn.weight = weightOf(n)
+	} else {
+		r.nodes[n.Addr] = &ringNode{weight: weightOf(n)}
+	}
+	r.rebuild()
+}
+
+// Remove takes a node out of the ring
+func (r *Ring) Remove(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.nodes, addr)
+	r.rebuild()
+}
+
+// SetDead marks a node as dead or alive again
+func (r *Ring) SetDead(addr string, dead bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rn, ok := r.nodes[addr]; ok {
+		rn.dead = dead
+	}
+}
+
+// Nodes returns the nodes in the ring, sorted by address
+func (r *Ring) Nodes() []Node {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	nodes := make([]Node, 0, len(r.nodes))
+	for addr, rn := range r.nodes {
+		nodes = append(nodes, Node{Addr: addr, Weight: rn.weight})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Addr < nodes[j].Addr })
+	return nodes
+}
+
+// IsDead reports whether the node is marked dead
+func (r *Ring) IsDead(addr string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rn, ok := r.nodes[addr]
+	return ok && rn.dead
+}
+
+// rebuild recomputes the points as libketama does. Callers hold mu
+func (r *Ring) rebuild() {
+	totalWeight := 0
+	for _, rn := range r.nodes {
+		totalWeight += rn.weight
+	}
+	points := make([]point, 0, len(r.nodes)*pointsPerServer*4)
+	for addr, rn := range r.nodes {
+		pct := float64(rn.weight) / float64(totalWeight)
+		ks := int(math.Floor(pct * pointsPerServer * float64(len(r.nodes))))
+		for k := 0; k < ks; k++ {
+			digest := md5.Sum([]byte(addr + "-" + strconv.Itoa(k)))
+			for h := 0; h < 4; h++ {
+				points = append(points, point{hash: digestHash(digest, h), addr: addr})
+			}
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].addr < points[j].addr
+		}
+		return points[i].hash < points[j].hash
+	})
+	r.points = points
+}
+
+func digestHash(digest [md5.Size]byte, h int) uint32 {
+	return uint32(digest[3+h*4])<<24 |
+		uint32(digest[2+h*4])<<16 |
+		uint32(digest[1+h*4])<<8 |
+		uint32(digest[h*4])
+}
+
+// hashKey is ketama's key hash, the first four bytes of its md5 digest
+func hashKey(key string) uint32 {
+	return digestHash(md5.Sum([]byte(key)), 0)
+}
+
+// Pick returns the address of the node for key
+func (r *Ring) Pick(key string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.points) == 0 {
+		return "", ErrNoNodes
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	for n := 0; n < len(r.points); n++ {
+		p := r.points[(i+n)%len(r.points)]
+		if !r.nodes[p.addr].dead {
+			return p.addr, nil
+		}
+		if !r.failover {
+			return "", ErrNodeDead
+		}
+	}
+	return "", ErrNodeDead
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"strconv"
+	"testing"
+)
+
+func pickAll(t *testing.T, r *Ring, n int) []string {
+	owners := make([]string, n)
+	for i := range owners {
+		addr, err := r.Pick("key" + strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		owners[i] = addr
+	}
+	return owners
+}
+
+func TestRingMinimalMovement(t *testing.T) {
+	r := NewRing(false, Node{Addr: "a:1"}, Node{Addr: "b:1"}, Node{Addr: "c:1"})
+	before := pickAll(t, r, 10000)
+	r.Add(Node{Addr: "d:1"})
+	after := pickAll(t, r, 10000)
+	moved := 0
+	for i := range before {
+		if before[i] != after[i] {
+			moved++
+			if after[i] != "d:1" {
+				t.Fatalf("key moved from %s to %s instead of the new node", before[i], after[i])
+			}
+		}
+	}
+	// about a quarter of the keys should move to the new node
+	if moved < 1500 || moved > 3500 {
+		t.Errorf("got %d keys moved, want about 2500", moved)
+	}
+	r.Remove("d:1")
+	for i, addr := range pickAll(t, r, 10000) {
+		if addr != before[i] {
+			t.Fatalf("key %d: got %s after removal, want %s", i, addr, before[i])
+		}
+	}
+}
+
+func TestRingWeights(t *testing.T) {
+	r := NewRing(false, Node{Addr: "a:1", Weight: 3}, Node{Addr: "b:1", Weight: 1})
+	counts := map[string]int{}
+	for _, addr := range pickAll(t, r, 10000) {
+		counts[addr]++
+	}
+	if counts["a:1"] < 6500 || counts["a:1"] > 8500 {
+		t.Errorf("got %d keys on the heavier node, want about 7500", counts["a:1"])
+	}
+}
+
+func TestRingFailover(t *testing.T) {
+	nodes := []Node{{Addr: "a:1"}, {Addr: "b:1"}, {Addr: "c:1"}}
+	strict := NewRing(false, nodes...)
+	withFailover := NewRing(true, nodes...)
+	before := pickAll(t, withFailover, 1000)
+	strict.SetDead("b:1", true)
+	withFailover.SetDead("b:1", true)
+	after := pickAll(t, withFailover, 1000)
+	for i := range before {
+		key := "key" + strconv.Itoa(i)
+		if before[i] == "b:1" {
+			if after[i] == "b:1" {
+				t.Fatalf("key %d still on dead node", i)
+			}
+			if _, err := strict.Pick(key); err != ErrNodeDead {
+				t.Fatalf("got %v, want ErrNodeDead without failover", err)
+			}
+		} else if after[i] != before[i] {
+			t.Fatalf("key %d on live node moved from %s to %s", i, before[i], after[i])
+		}
+	}
+	if _, err := NewRing(true).Pick("k"); err != ErrNoNodes {
+		t.Errorf("got %v, want ErrNoNodes", err)
+	}
+}
@@ -0,0 +1,185 @@
+// Package conns caps the connections a server accepts at once and keeps
+// track of those open, with their age and the last command made on each
+package conns
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Conn describes an open connection
+type Conn struct {
+	Remote      string    `json:"remote"`
+	Opened      time.Time `json:"opened"`
+	Age         string    `json:"age"`
+	Requests    int64     `json:"requests"`
+	LastCommand string    `json:"last_command,omitempty"`
+	LastActive  time.Time `json:"last_active"`
+}
+
+type conn struct {
+	remote      string
+	opened      time.Time
+	requests    int64
+	lastCommand string
+	lastActive  time.Time
+}
+
+type connKey struct{}
+
+// Tracker tracks a server's connections once its ConnState and
+// ConnContext are set to the Tracker's, see Server, and limits them
+// through Listener. It's safe for concurrent use
+type Tracker struct {
+	// sem holds a token per connection accepted, nil for no limit
+	sem chan struct{}
+
+	mu       sync.Mutex
+	conns    map[net.Conn]*conn
+	total    int64
+	waits    int64
+	maxConns int
+}
+
+// New returns a Tracker letting at most max connections be open at
+// once, zero for no limit
+func New(max int) *Tracker {
+	t := &Tracker{conns: make(map[net.Conn]*conn), maxConns: max}
+	if max > 0 {
+		t.sem = make(chan struct{}, max)
+	}
+	return t
+}
+
+// Server sets the server's hooks so that the Tracker sees its
+// connections and the commands made on them
+func (t *Tracker) Server(srv *http.Server) {
+	srv.ConnState = t.connState
+	srv.ConnContext = t.connContext
+}
+
+// Listener returns a listener that stops accepting connections while
+// the maximum is open, leaving new ones waiting in the listen backlog
+func (t *Tracker) Listener(l net.Listener) net.Listener {
+	if t.sem == nil {
+		return l
+	}
+	return &limitListener{Listener: l, t: t}
+}
+
+type limitListener struct {
+	net.Listener
+	t *Tracker
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.t.sem <- struct{}{}:
+	default:
+		l.t.mu.Lock()
+		l.t.waits++
+		l.t.mu.Unlock()
+		l.t.sem <- struct{}{}
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.t.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.t.sem }}, nil
+}
+
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+func (t *Tracker) connState(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		now := time.Now()
+		t.conns[c] = &conn{remote: c.RemoteAddr().String(), opened: now, lastActive: now}
+		t.total++
+	case http.StateClosed, http.StateHijacked:
+		delete(t.conns, c)
+	}
+}
+
+func (t *Tracker) connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// Middleware records the command of each request as the last made on
+// its connection
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+			t.mu.Lock()
+			if info, ok := t.conns[c]; ok {
+				info.requests++
+				info.lastCommand = Command(r.URL.Path)
+				info.lastActive = time.Now()
+			}
+			t.mu.Unlock()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Command returns the command of a request's path, without its key or
+// namespace, e.g. get for /ns/sessions/get/k
+func Command(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if parts[0] == "ns" && len(parts) > 2 {
+		parts = parts[2:]
+	}
+	return parts[0]
+}
+
+// Conns returns the open connections, oldest first
+func (t *Tracker) Conns() []Conn {
+	t.mu.Lock()
+	now := time.Now()
+	conns := make([]Conn, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, Conn{
+			Remote:      c.remote,
+			Opened:      c.opened,
+			Age:         now.Sub(c.opened).Round(time.Millisecond).String(),
+			Requests:    c.requests,
+			LastCommand: c.lastCommand,
+			LastActive:  c.lastActive,
+		})
+	}
+	t.mu.Unlock()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].Opened.Before(conns[j].Opened) })
+	return conns
+}
+
+// Stats reports the connections open and accepted, the limit and how
+// often it held new connections back
+func (t *Tracker) Stats() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return map[string]string{
+		"curr_connections":  strconv.Itoa(len(t.conns)),
+		"total_connections": strconv.FormatInt(t.total, 10),
+		"max_connections":   strconv.Itoa(t.maxConns),
+		"listen_waits":      strconv.FormatInt(t.waits, 10),
+	}
+}
@@ -0,0 +1,71 @@
+package conns
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *Tracker) *httptest.Server {
+	srv := httptest.NewUnstartedServer(t.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	srv.Listener = t.Listener(srv.Listener)
+	t.Server(srv.Config)
+	srv.Start()
+	return srv
+}
+
+func TestTracker(t *testing.T) {
+	tracker := New(0)
+	srv := newTestServer(tracker)
+	defer srv.Close()
+
+	c := &http.Client{Transport: &http.Transport{}}
+	for _, path := range []string{"/set/k", "/ns/sessions/get/k"} {
+		resp, err := c.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	conns := tracker.Conns()
+	if len(conns) != 1 || conns[0].Requests != 2 || conns[0].LastCommand != "get" {
+		t.Fatalf("got conns %+v, want one with 2 requests, the last a get", conns)
+	}
+	c.Transport.(*http.Transport).CloseIdleConnections()
+	for deadline := time.Now().Add(time.Second); len(tracker.Conns()) > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("closed connection still tracked")
+		}
+	}
+	if st := tracker.Stats(); st["total_connections"] != "1" || st["curr_connections"] != "0" {
+		t.Errorf("stats: got %v", st)
+	}
+}
+
+func TestTrackerLimit(t *testing.T) {
+	tracker := New(1)
+	srv := newTestServer(tracker)
+	defer srv.Close()
+
+	idle, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{Timeout: 200 * time.Millisecond}
+	if resp, err := c.Get(srv.URL + "/get/k"); err == nil {
+		resp.Body.Close()
+		t.Fatal("served a connection past the limit")
+	}
+	idle.Close()
+	c.Timeout = time.Second
+	resp, err := c.Get(srv.URL + "/get/k")
+	if err != nil {
+		t.Fatalf("once a connection closed: %v", err)
+	}
+	resp.Body.Close()
+	if st := tracker.Stats(); st["listen_waits"] == "0" {
+		t.Errorf("stats: got %v, want listen waits", st)
+	}
+}
@@ -0,0 +1,230 @@
+// Package group fills a cache spread across peers the way groupcache
+// does. Every key has an owning peer, picked by consistent hashing. Only
+// the owner calls the loader on a miss and keeps the value, other peers
+// fetch it from the owner and may keep a short lived hot copy, so a key
+// is loaded once for the whole cluster rather than once per node
+package group
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	_ "github.com/nagamocha3000/go-memcached/pkg/cache/lru_cache" // hot copies
+	"github.com/nagamocha3000/go-memcached/pkg/client"
+	"github.com/nagamocha3000/go-memcached/pkg/loader"
+	"github.com/nagamocha3000/go-memcached/pkg/singleflight"
+)
+
+// ErrNotFound is returned by loaders when the key has no value, and by
+// Get when the owner's loader returned it
+var ErrNotFound = loader.ErrNotFound
+
+// Loader loads the value of a key missing from the cache, e.g. a
+// loader.HTTP or the Load method of a loader.ReadThrough
+type Loader = loader.Func
+
+// Options configures a Group
+type Options struct {
+	// HotCapacity is the number of values owned by other peers kept
+	// locally, zero to always fetch them from their owner
+	HotCapacity int
+	// HotTTL is how long hot copies are kept, in seconds. Defaults to 60
+	HotTTL int
+	// Client configures the connections to peers
+	Client client.Options
+}
+
+// Group fills misses on the keys owned by this peer with a loader, and
+// forwards those owned by other peers to them
+type Group struct {
+	self   string
+	cache  *cache.Adapter
+	hot    *cache.Adapter
+	hotTTL string
+	load   Loader
+	ring   *client.Ring
+	peers  *peers
+	// concurrent gets of a key share one load or fetch from the owner
+	flights singleflight.Group
+	// loads served to peers have their own flights, so that two peers
+	// whose rings disagree on the owner don't wait on each other's gets
+	peerFlights singleflight.Group
+	stats       groupStats
+}
+
+// groupStats are updated atomically
+type groupStats struct {
+	gets       int64
+	localHits  int64
+	hotHits    int64
+	loads      int64
+	loadErrors int64
+	peerGets   int64
+	peerErrors int64
+	peerServed int64
+}
+
+// New returns a group for the peer reachable at self, which must be the
+// address other peers know it by. Keys it owns are kept in c. Other
+// peers are added with SetPeers
+func New(self string, c *cache.Adapter, load Loader, opts Options) (*Group, error) {
+	g := &Group{
+		self:  self,
+		cache: c,
+		load:  load,
+		ring:  client.NewRing(false, client.Node{Addr: self}),
+		peers: newPeers(opts.Client),
+	}
+	if opts.HotCapacity > 0 {
+		hot, err := cache.NewCache("lru", opts.HotCapacity, nil)
+		if err != nil {
+			return nil, err
+		}
+		hotTTL := opts.HotTTL
+		if hotTTL < 1 {
+			hotTTL = 60
+		}
+		g.hot = hot
+		g.hotTTL = strconv.Itoa(hotTTL)
+	}
+	c.AddStats(g.Stats)
+	return g, nil
+}
+
+// SetPeers replaces the peers keys are spread across. This peer is
+// always one of them
+func (g *Group) SetPeers(addrs ...string) {
+	want := map[string]bool{g.self: true}
+	for _, addr := range addrs {
+		want[addr] = true
+	}
+	for _, n := range g.ring.Nodes() {
+		if !want[n.Addr] {
+			g.ring.Remove(n.Addr)
+		}
+		delete(want, n.Addr)
+	}
+	for addr := range want {
+		g.ring.Add(client.Node{Addr: addr})
+	}
+}
+
+// Peers returns the peers keys are spread across, including this one
+func (g *Group) Peers() []string {
+	var addrs []string
+	for _, n := range g.ring.Nodes() {
+		addrs = append(addrs, n.Addr)
+	}
+	return addrs
+}
+
+// Get returns the value of key, from the local cache if this peer owns
+// it, else from the hot copies or the owner. If the owner can't be
+// reached the value is loaded locally, but not kept
+func (g *Group) Get(ctx context.Context, key string) (string, error) {
+	atomic.AddInt64(&g.stats.gets, 1)
+	v, err, _ := g.flights.Do(key, func() (interface{}, error) {
+		return g.get(ctx, key)
+	})
+	return v.(string), err
+}
+
+func (g *Group) get(ctx context.Context, key string) (string, error) {
+	owner, err := g.ring.Pick(key)
+	if err != nil || owner == g.self {
+		return g.getLocally(ctx, key)
+	}
+	if g.hot != nil {
+		if reply, val := g.hot.Get(key); reply == cache.ValueReply {
+			atomic.AddInt64(&g.stats.hotHits, 1)
+			return val, nil
+		}
+	}
+	atomic.AddInt64(&g.stats.peerGets, 1)
+	val, err := g.peers.get(ctx, owner, key)
+	if err == nil {
+		if g.hot != nil {
+			g.hot.Set(key, val, g.hotTTL, nil)
+		}
+		return val, nil
+	}
+	if errors.Is(err, ErrNotFound) {
+		return "", err
+	}
+	atomic.AddInt64(&g.stats.peerErrors, 1)
+	val, _, err = g.loadValue(ctx, key)
+	return val, err
+}
+
+// getLocally returns the value from the local cache, loading and
+// keeping it on a miss
+func (g *Group) getLocally(ctx context.Context, key string) (string, error) {
+	// a value past its TTL is refreshed by the one peer told to
+	if reply, val := g.cache.Get(key); reply == cache.ValueReply || reply == cache.StaleReply {
+		atomic.AddInt64(&g.stats.localHits, 1)
+		return val, nil
+	}
+	val, exptime, err := g.loadValue(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	g.cache.Fill(key, val, strconv.Itoa(exptime))
+	return val, nil
+}
+
+func (g *Group) loadValue(ctx context.Context, key string) (string, int, error) {
+	atomic.AddInt64(&g.stats.loads, 1)
+	val, exptime, err := g.load(ctx, key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		atomic.AddInt64(&g.stats.loadErrors, 1)
+	}
+	return val, exptime, err
+}
+
+type peerReply struct {
+	Reply string `json:"reply"`
+	Val   string `json:"val"`
+}
+
+// HandlePeerGet serves the value of the key query parameter to other
+// peers, loading it locally if needed. It's never forwarded, even if the
+// rings disagree on the owner as they may while peers come and go, so a
+// get crosses at most one hop
+func (g *Group) HandlePeerGet(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&g.stats.peerServed, 1)
+	key := r.URL.Query().Get("key")
+	v, err, _ := g.peerFlights.Do(key, func() (interface{}, error) {
+		return g.getLocally(r.Context(), key)
+	})
+	val := v.(string)
+	reply := peerReply{Reply: string(cache.ValueReply), Val: val}
+	if errors.Is(err, ErrNotFound) {
+		reply.Reply = string(cache.NotFoundReply)
+	} else if err != nil {
+		reply.Reply = string(cache.ServerError(err.Error()))
+	}
+	jsonString, _ := json.Marshal(reply)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonString)
+}
+
+// Stats returns the group's counters
+func (g *Group) Stats() map[string]string {
+	load := func(n *int64) string { return strconv.FormatInt(atomic.LoadInt64(n), 10) }
+	return map[string]string{
+		"group_peers":       strconv.Itoa(len(g.ring.Nodes())),
+		"group_gets":        load(&g.stats.gets),
+		"group_local_hits":  load(&g.stats.localHits),
+		"group_hot_hits":    load(&g.stats.hotHits),
+		"group_loads":       load(&g.stats.loads),
+		"group_load_errors": load(&g.stats.loadErrors),
+		"group_peer_gets":   load(&g.stats.peerGets),
+		"group_peer_errors": load(&g.stats.peerErrors),
+		"group_peer_served": load(&g.stats.peerServed),
+	}
+}
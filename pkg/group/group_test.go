@@ -0,0 +1,158 @@
+package group
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	"github.com/nagamocha3000/go-memcached/pkg/client"
+)
+
+type countingLoader struct {
+	mu    sync.Mutex
+	loads map[string]int
+}
+
+func (l *countingLoader) load(ctx context.Context, key string) (string, int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loads[key]++
+	if strings.HasPrefix(key, "missing") {
+		return "", 0, ErrNotFound
+	}
+	return "value of " + key, 0, nil
+}
+
+func newPeer(t *testing.T, loader *countingLoader, opts Options) (*Group, *httptest.Server) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	c, err := cache.NewCache("lru", 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := New(srv.URL, c, loader.load, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.HandleFunc(PeerGetPath, g.HandlePeerGet)
+	return g, srv
+}
+
+func TestGroupLoadsOnlyAtOwner(t *testing.T) {
+	loader := &countingLoader{loads: make(map[string]int)}
+	a, srvA := newPeer(t, loader, Options{HotCapacity: 10})
+	defer srvA.Close()
+	b, srvB := newPeer(t, loader, Options{})
+	defer srvB.Close()
+	a.SetPeers(srvB.URL)
+	b.SetPeers(srvA.URL)
+
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		for _, g := range []*Group{a, b, a, b} {
+			val, err := g.Get(ctx, key)
+			if err != nil || val != "value of "+key {
+				t.Fatalf("%s: got %q, %v", key, val, err)
+			}
+		}
+		if loader.loads[key] != 1 {
+			t.Errorf("expected %s to be loaded once, got %d", key, loader.loads[key])
+		}
+	}
+	if _, err := b.Get(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if a.Stats()["group_hot_hits"] == "0" {
+		t.Errorf("expected hot copies to be used, got %v", a.Stats())
+	}
+}
+
+func TestGroupLoadsLocallyWhenOwnerIsDown(t *testing.T) {
+	loader := &countingLoader{loads: make(map[string]int)}
+	a, srvA := newPeer(t, loader, Options{})
+	defer srvA.Close()
+	_, srvB := newPeer(t, loader, Options{})
+	a.SetPeers(srvB.URL)
+	srvB.Close()
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if val, err := a.Get(context.Background(), key); err != nil || val != "value of "+key {
+			t.Fatalf("%s: got %q, %v", key, val, err)
+		}
+	}
+	if a.Stats()["group_peer_errors"] == "0" {
+		t.Errorf("expected some keys to be owned by the down peer, got %v", a.Stats())
+	}
+}
+
+func TestGroupServesPeersWhoseRingsDisagree(t *testing.T) {
+	loader := &countingLoader{loads: make(map[string]int)}
+	opts := Options{Client: client.Options{Timeout: 2 * time.Second}}
+	newSlowPeer := func() (*Group, *httptest.Server) {
+		mux := http.NewServeMux()
+		srv := httptest.NewServer(mux)
+		c, err := cache.NewCache("lru", 100, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := New(srv.URL, c, loader.load, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// let both gets start before either peer serves the other
+		mux.HandleFunc(PeerGetPath, func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+			g.HandlePeerGet(w, r)
+		})
+		return g, srv
+	}
+	a, srvA := newSlowPeer()
+	defer srvA.Close()
+	b, srvB := newSlowPeer()
+	defer srvB.Close()
+	// b knows a by another name, so the rings hash them differently
+	a.SetPeers(srvB.URL)
+	b.SetPeers(strings.Replace(srvA.URL, "127.0.0.1", "localhost", 1))
+
+	key := ""
+	for i := 0; i < 1000 && key == ""; i++ {
+		k := fmt.Sprintf("key%d", i)
+		ownerA, _ := a.ring.Pick(k)
+		ownerB, _ := b.ring.Pick(k)
+		if ownerA == b.self && ownerB != b.self {
+			key = k
+		}
+	}
+	if key == "" {
+		t.Fatal("found no key the rings disagree on")
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, g := range []*Group{a, b} {
+		wg.Add(1)
+		go func(g *Group) {
+			defer wg.Done()
+			if val, err := g.Get(context.Background(), key); err != nil || val != "value of "+key {
+				t.Errorf("%s: got %q, %v", key, val, err)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gets took %v, the peers waited on each other", elapsed)
+	}
+	for _, g := range []*Group{a, b} {
+		if st := g.Stats(); st["group_peer_errors"] != "0" || st["group_peer_served"] != "1" {
+			t.Errorf("%s: got stats %v", g.self, st)
+		}
+	}
+}
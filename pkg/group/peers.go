@@ -0,0 +1,75 @@
+package group
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nagamocha3000/go-memcached/pkg/auth"
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	"github.com/nagamocha3000/go-memcached/pkg/client"
+)
+
+// PeerGetPath is where peers serve HandlePeerGet
+const PeerGetPath = "/_peer/get"
+
+// peers fetches values from their owners over HTTP
+type peers struct {
+	httpClient *http.Client
+	timeout    time.Duration
+}
+
+func newPeers(opts client.Options) *peers {
+	transport := opts.Transport
+	if transport == nil {
+		transport = &http.Transport{
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     90 * time.Second,
+		}
+	}
+	if authorization := opts.Authorization(); authorization != "" {
+		transport = &auth.Transport{Authorization: authorization, Base: transport}
+	}
+	return &peers{
+		httpClient: &http.Client{Transport: transport},
+		timeout:    opts.Timeout,
+	}
+}
+
+func (p *peers) get(ctx context.Context, addr, key string) (string, error) {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	req, err := http.NewRequest(http.MethodGet, addr+PeerGetPath+"?key="+url.QueryEscape(key), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("group: peer %s: %s", addr, resp.Status)
+	}
+	var reply peerReply
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return "", fmt.Errorf("group: decoding reply of peer %s: %w", addr, err)
+	}
+	switch reply.Reply {
+	case string(cache.ValueReply):
+		return reply.Val, nil
+	case string(cache.NotFoundReply):
+		return "", ErrNotFound
+	}
+	return "", fmt.Errorf("group: peer %s: %s", addr, reply.Reply)
+}
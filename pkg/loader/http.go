@@ -0,0 +1,56 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTP returns a loader that GETs the base URL followed by the key. A
+// 404 means the key has no value, and a max-age in the response's
+// Cache-Control sets the TTL. A nil httpClient uses one with a 10 second
+// timeout
+func HTTP(base string, httpClient *http.Client) Func {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return func(ctx context.Context, key string) (string, int, error) {
+		req, err := http.NewRequest(http.MethodGet, base+url.PathEscape(key), nil)
+		if err != nil {
+			return "", 0, err
+		}
+		resp, err := httpClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return "", 0, err
+		}
+		defer resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return "", 0, ErrNotFound
+		case resp.StatusCode != http.StatusOK:
+			return "", 0, fmt.Errorf("loader: GET %s: %s", req.URL, resp.Status)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return "", 0, err
+		}
+		return string(body), maxAge(resp.Header.Get("Cache-Control")), nil
+	}
+}
+
+func maxAge(cacheControl string) int {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if strings.HasPrefix(directive, "max-age=") {
+			if age, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil {
+				return age
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,170 @@
+// Package loader reads through a cache.Adapter: misses are filled by the
+// loader registered for the key's prefix, such as an HTTP upstream, and
+// concurrent misses on the same key share a single load
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	"github.com/nagamocha3000/go-memcached/pkg/singleflight"
+)
+
+var (
+	// ErrNotFound is returned by loaders when the key has no value
+	ErrNotFound = errors.New("loader: not found")
+	// ErrNoLoader is returned for keys no loader is registered for. It
+	// counts as not found
+	ErrNoLoader = fmt.Errorf("loader: no loader for key: %w", ErrNotFound)
+)
+
+// Func loads the value of a key missing from the cache, along with its
+// TTL in seconds, zero for none
+type Func func(ctx context.Context, key string) (value string, exptime int, err error)
+
+type route struct {
+	prefix string
+	load   Func
+}
+
+// ReadThrough fills misses of a cache with loaders
+type ReadThrough struct {
+	cache   *cache.Adapter
+	flights singleflight.Group
+
+	mu     sync.RWMutex
+	routes []route // longest prefix first
+
+	stats readThroughStats
+}
+
+// readThroughStats are updated atomically
+type readThroughStats struct {
+	hits       int64
+	misses     int64
+	loads      int64
+	coalesced  int64
+	loadErrors int64
+}
+
+// New returns a read-through cache on top of c with no loaders
+func New(c *cache.Adapter) *ReadThrough {
+	rt := &ReadThrough{cache: c}
+	c.AddStats(rt.Stats)
+	return rt
+}
+
+// Register sets the loader for keys starting with prefix, replacing any
+// registered for the same prefix. The longest matching prefix wins, an
+// empty prefix matches every key
+func (rt *ReadThrough) Register(prefix string, load Func) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	for i := range rt.routes {
+		if rt.routes[i].prefix == prefix {
+			rt.routes[i].load = load
+			return
+		}
+	}
+	rt.routes = append(rt.routes, route{prefix, load})
+	sort.SliceStable(rt.routes, func(i, j int) bool {
+		return len(rt.routes[i].prefix) > len(rt.routes[j].prefix)
+	})
+}
+
+func (rt *ReadThrough) loaderFor(key string) (Func, bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	for _, r := range rt.routes {
+		if strings.HasPrefix(key, r.prefix) {
+			return r.load, true
+		}
+	}
+	return nil, false
+}
+
+// Get returns the value of key from the cache, loading and storing it
+// on a miss. Concurrent misses on a key wait for the first caller's
+// load, and hence share its ctx. Values past their TTL but within the
+// cache's grace period are returned as is, and refreshed in the
+// background when the cache asks for it
+func (rt *ReadThrough) Get(ctx context.Context, key string) (string, error) {
+	switch reply, val := rt.cache.Get(key); reply {
+	case cache.ValueReply, cache.StaleReply:
+		atomic.AddInt64(&rt.stats.hits, 1)
+		return val, nil
+	case cache.RefreshReply:
+		atomic.AddInt64(&rt.stats.hits, 1)
+		go rt.fill(context.Background(), key)
+		return val, nil
+	}
+	atomic.AddInt64(&rt.stats.misses, 1)
+	return rt.fill(ctx, key)
+}
+
+// fill loads and stores the value of key
+func (rt *ReadThrough) fill(ctx context.Context, key string) (string, error) {
+	v, err, shared := rt.flights.Do(key, func() (interface{}, error) {
+		val, exptime, err := rt.load(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		rt.cache.Fill(key, val, strconv.Itoa(exptime))
+		return val, nil
+	})
+	if shared {
+		atomic.AddInt64(&rt.stats.coalesced, 1)
+	}
+	return v.(string), err
+}
+
+// Load calls the loader for key without going through the cache, still
+// coalescing concurrent loads of the key. It can serve as the loader of
+// another layer, e.g. a group
+func (rt *ReadThrough) Load(ctx context.Context, key string) (string, int, error) {
+	type loaded struct {
+		val     string
+		exptime int
+	}
+	v, err, shared := rt.flights.Do("load\x00"+key, func() (interface{}, error) {
+		val, exptime, err := rt.load(ctx, key)
+		return loaded{val, exptime}, err
+	})
+	if shared {
+		atomic.AddInt64(&rt.stats.coalesced, 1)
+	}
+	l := v.(loaded)
+	return l.val, l.exptime, err
+}
+
+func (rt *ReadThrough) load(ctx context.Context, key string) (string, int, error) {
+	load, ok := rt.loaderFor(key)
+	if !ok {
+		return "", 0, ErrNoLoader
+	}
+	atomic.AddInt64(&rt.stats.loads, 1)
+	val, exptime, err := load(ctx, key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		atomic.AddInt64(&rt.stats.loadErrors, 1)
+	}
+	return val, exptime, err
+}
+
+// Stats returns the read-through counters
+func (rt *ReadThrough) Stats() map[string]string {
+	load := func(n *int64) string { return strconv.FormatInt(atomic.LoadInt64(n), 10) }
+	return map[string]string{
+		"read_through_hits":        load(&rt.stats.hits),
+		"read_through_misses":      load(&rt.stats.misses),
+		"read_through_loads":       load(&rt.stats.loads),
+		"read_through_coalesced":   load(&rt.stats.coalesced),
+		"read_through_load_errors": load(&rt.stats.loadErrors),
+	}
+}
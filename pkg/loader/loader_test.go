@@ -0,0 +1,91 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	_ "github.com/nagamocha3000/go-memcached/pkg/cache/policies"
+)
+
+func newReadThrough(t *testing.T) *ReadThrough {
+	c, err := cache.NewCache("lru", 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(c)
+}
+
+func TestReadThroughCoalescesMisses(t *testing.T) {
+	rt := newReadThrough(t)
+	var loads int64
+	release := make(chan struct{})
+	rt.Register("", func(ctx context.Context, key string) (string, int, error) {
+		atomic.AddInt64(&loads, 1)
+		<-release
+		return "value of " + key, 0, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if val, err := rt.Get(context.Background(), "hot"); err != nil || val != "value of hot" {
+				t.Errorf("got %q, %v", val, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if loads != 1 {
+		t.Errorf("expected a single load, got %d", loads)
+	}
+	// now cached
+	rt.Get(context.Background(), "hot")
+	if loads != 1 {
+		t.Errorf("expected the loaded value to be cached, got %d loads", loads)
+	}
+}
+
+func TestReadThroughPrefixes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/user:missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=30")
+		w.Write([]byte("user " + r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	rt := newReadThrough(t)
+	rt.Register("user:", HTTP(upstream.URL+"/users/", nil))
+	rt.Register("user:admin:", func(ctx context.Context, key string) (string, int, error) {
+		return "admin", 0, nil
+	})
+
+	ctx := context.Background()
+	if val, err := rt.Get(ctx, "user:admin:1"); err != nil || val != "admin" {
+		t.Errorf("expected the longest prefix to win, got %q, %v", val, err)
+	}
+	if val, err := rt.Get(ctx, "user:1"); err != nil || val != "user /users/user:1" {
+		t.Errorf("got %q, %v", val, err)
+	}
+	if _, exptime, _ := rt.Load(ctx, "user:1"); exptime != 30 {
+		t.Errorf("expected the max-age as TTL, got %d", exptime)
+	}
+	if _, err := rt.Get(ctx, "user:missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a 404 to be not found, got %v", err)
+	}
+	if _, err := rt.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected keys without a loader to be not found, got %v", err)
+	}
+}
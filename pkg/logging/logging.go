@@ -0,0 +1,298 @@
+// Package logging writes leveled, structured log entries as logfmt or
+// JSON lines, and identifies requests so that their entries can be
+// told apart
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// Level is how severe an entry is
+type Level int32
+
+// Levels, least severe first
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	if level < Debug || level > Error {
+		return "level(" + strconv.Itoa(int(level)) + ")"
+	}
+	return levelNames[level]
+}
+
+// ParseLevel returns the level named s
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, want one of %s", s, strings.Join(levelNames, ", "))
+}
+
+// Format is how entries are written
+type Format string
+
+// Formats
+const (
+	Logfmt Format = "logfmt"
+	JSON   Format = "json"
+)
+
+// ParseFormat returns the format named s
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case Logfmt, JSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown log format %q, want logfmt or json", s)
+}
+
+// KeyMode is how cache keys are logged, as keys may be private
+type KeyMode int32
+
+// Key modes
+const (
+	// KeysPlain logs keys as they are
+	KeysPlain KeyMode = iota
+	// KeysHash logs a hash of each key, so that entries of a key can
+	// still be found together
+	KeysHash
+	// KeysRedact leaves keys out
+	KeysRedact
+)
+
+var keyModeNames = []string{"plain", "hash", "redact"}
+
+func (mode KeyMode) String() string {
+	if mode < KeysPlain || mode > KeysRedact {
+		return "keymode(" + strconv.Itoa(int(mode)) + ")"
+	}
+	return keyModeNames[mode]
+}
+
+// ParseKeyMode returns the key mode named s
+func ParseKeyMode(s string) (KeyMode, error) {
+	for i, name := range keyModeNames {
+		if strings.EqualFold(s, name) {
+			return KeyMode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown key mode %q, want one of %s", s, strings.Join(keyModeNames, ", "))
+}
+
+// Logger writes entries at or above its level. Its level and key mode
+// may be changed while it's in use. It's safe for concurrent use
+type Logger struct {
+	format Format
+	now    func() time.Time
+	level  int32
+	keys   int32
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// New returns a Logger writing entries at or above level to out
+func New(out io.Writer, format Format, level Level) *Logger {
+	return &Logger{out: out, format: format, level: int32(level), now: time.Now}
+}
+
+// Level returns the least severe level logged
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.level))
+}
+
+// SetLevel sets the least severe level logged
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+// Enabled tells whether entries of the level are logged
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+// KeyMode returns how keys are logged
+func (l *Logger) KeyMode() KeyMode {
+	return KeyMode(atomic.LoadInt32(&l.keys))
+}
+
+// SetKeyMode sets how keys are logged
+func (l *Logger) SetKeyMode(mode KeyMode) {
+	atomic.StoreInt32(&l.keys, int32(mode))
+}
+
+// Key returns the key as it should be logged, empty if it shouldn't be
+func (l *Logger) Key(key string) string {
+	switch l.KeyMode() {
+	case KeysHash:
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:8])
+	case KeysRedact:
+		return ""
+	}
+	return key
+}
+
+// KeyFields returns the fields logging the key: key if keys are logged
+// as they are, key_hash if they're hashed and none if they're left out,
+// so that readers of the log can tell which they got
+func (l *Logger) KeyFields(key string) []interface{} {
+	switch l.KeyMode() {
+	case KeysHash:
+		return []interface{}{"key_hash", l.Key(key)}
+	case KeysRedact:
+		return nil
+	}
+	return []interface{}{"key", key}
+}
+
+// Log writes an entry of the message and the fields, given as
+// alternating names and values, if the level is logged
+func (l *Logger) Log(level Level, msg string, fields ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	if len(fields)%2 != 0 {
+		fields = append(fields, "")
+	}
+	all := make([]interface{}, 0, 6+len(fields))
+	all = append(all, "ts", l.now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	all = append(all, fields...)
+	var buf bytes.Buffer
+	if l.format == JSON {
+		writeJSON(&buf, all)
+	} else {
+		writeLogfmt(&buf, all)
+	}
+	buf.WriteByte('\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+// Debug logs at the Debug level
+func (l *Logger) Debug(msg string, fields ...interface{}) { l.Log(Debug, msg, fields...) }
+
+// Info logs at the Info level
+func (l *Logger) Info(msg string, fields ...interface{}) { l.Log(Info, msg, fields...) }
+
+// Warn logs at the Warn level
+func (l *Logger) Warn(msg string, fields ...interface{}) { l.Log(Warn, msg, fields...) }
+
+// Error logs at the Error level
+func (l *Logger) Error(msg string, fields ...interface{}) { l.Log(Error, msg, fields...) }
+
+// StdLogger returns a log.Logger whose lines are logged at the level as
+// messages, for code taking one. flag is the log.Logger's, e.g.
+// log.Lshortfile, its date and time flags being redundant
+func (l *Logger) StdLogger(level Level, flag int) *log.Logger {
+	return log.New(stdWriter{l, level}, "", flag)
+}
+
+type stdWriter struct {
+	l     *Logger
+	level Level
+}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	w.l.Log(w.level, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// value returns the field's value as logged
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Duration:
+		return v.String()
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+func writeJSON(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(fmt.Sprint(fields[i]))
+		buf.Write(name)
+		buf.WriteByte(':')
+		val, err := json.Marshal(value(fields[i+1]))
+		if err != nil {
+			val, _ = json.Marshal(fmt.Sprint(fields[i+1]))
+		}
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+}
+
+func writeLogfmt(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(fmt.Sprint(value(fields[i+1]))))
+	}
+}
+
+// logfmtValue quotes the value if it can't be written as is
+func logfmtValue(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r == '=' || r == '"' || r == '\\' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random request ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID ctx carries, if any
+func RequestID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestLogger(format Format, level Level) (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	l := New(&buf, format, level)
+	l.now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return l, &buf
+}
+
+func TestLogfmt(t *testing.T) {
+	l, buf := newTestLogger(Logfmt, Info)
+	l.Debug("hidden")
+	l.Info("request", "cmd", "get", "key", "a b", "status", 200, "duration", 1500*time.Microsecond, "err", errors.New(`bad "x"`))
+	want := `ts=2020-01-02T03:04:05Z level=info msg=request cmd=get key="a b" status=200 duration=1.5ms err="bad \"x\""` + "\n"
+	if buf.String() != want {
+		t.Errorf("got %s, want %s", buf, want)
+	}
+
+	buf.Reset()
+	l.SetLevel(Debug)
+	l.Debug("shown", "empty", "")
+	if want := `ts=2020-01-02T03:04:05Z level=debug msg=shown empty=""` + "\n"; buf.String() != want {
+		t.Errorf("after SetLevel: got %s, want %s", buf, want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	l, buf := newTestLogger(JSON, Info)
+	l.StdLogger(Warn, 0).Printf("falling behind by %d", 3)
+	l.Info("request", "id", "abc", "status", 429, "odd")
+	want := `{"ts":"2020-01-02T03:04:05Z","level":"warn","msg":"falling behind by 3"}` + "\n" +
+		`{"ts":"2020-01-02T03:04:05Z","level":"info","msg":"request","id":"abc","status":429,"odd":""}` + "\n"
+	if buf.String() != want {
+		t.Errorf("got %s, want %s", buf, want)
+	}
+}
+
+func TestKeyModes(t *testing.T) {
+	l, _ := newTestLogger(Logfmt, Info)
+	if got := l.Key("user:1"); got != "user:1" {
+		t.Errorf("plain: got %q", got)
+	}
+	l.SetKeyMode(KeysHash)
+	if got := l.Key("user:1"); len(got) != 16 || got == l.Key("user:2") {
+		t.Errorf("hash: got %q", got)
+	}
+	if got := l.KeyFields("user:1"); len(got) != 2 || got[0] != "key_hash" {
+		t.Errorf("hash: got fields %v", got)
+	}
+	l.SetKeyMode(KeysRedact)
+	if got := l.Key("user:1"); got != "" {
+		t.Errorf("redact: got %q", got)
+	}
+	if got := l.KeyFields("user:1"); len(got) != 0 {
+		t.Errorf("redact: got fields %v", got)
+	}
+	if _, err := ParseKeyMode("scramble"); err == nil {
+		t.Error("parsed an unknown key mode")
+	}
+	if level, err := ParseLevel("WARN"); err != nil || level != Warn {
+		t.Errorf("ParseLevel: got %s, %v", level, err)
+	}
+}
@@ -0,0 +1,68 @@
+// Package membership discovers the nodes of a cluster and detects their
+// failures with a SWIM-style gossip protocol over UDP.
+//
+// Every probe interval a node pings one other member. If it doesn't ack
+// in time, a few other members are asked to ping it on the node's
+// behalf, and if none of them gets an ack either the member is
+// suspected. Suspects that don't refute the suspicion within the
+// suspicion timeout are declared dead. Changes to the member list are
+// piggybacked on the pings and acks, so they spread through the cluster
+// without extra messages. Nodes join by exchanging their full member
+// list with a seed, which is also repeated periodically with a random
+// member so that partitions heal. Dead members are forgotten after a
+// while.
+//
+// Packets are signed with a key shared by the nodes, if given, and
+// those that aren't signed with it dropped. They aren't encrypted
+package membership
+
+// State is the state of a member as seen by the local node
+type State string
+
+// Member states
+const (
+	StateAlive   State = "alive"
+	StateSuspect State = "suspect"
+	StateDead    State = "dead"
+	StateLeft    State = "left"
+)
+
+// Member is a node of the cluster. Members only ever change through
+// updates with a higher incarnation, or a worse state at the same
+// incarnation, so every node ends up with the same view
+type Member struct {
+	// Name identifies the node, it defaults to its gossip address
+	Name string `json:"name"`
+	// Addr is the UDP address the node gossips on
+	Addr string `json:"addr"`
+	// APIAddr is the address clients reach the node's cache on
+	APIAddr     string `json:"apiAddr,omitempty"`
+	State       State  `json:"state"`
+	Incarnation uint64 `json:"incarnation"`
+}
+
+// IsLive reports whether the member should be sent requests. Suspects
+// count as live until they're declared dead
+func (m Member) IsLive() bool {
+	return m.State == StateAlive || m.State == StateSuspect
+}
+
+// rank orders states at the same incarnation
+func (s State) rank() int {
+	switch s {
+	case StateAlive:
+		return 0
+	case StateSuspect:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// overrides reports whether update u replaces what's known about cur
+func (u Member) overrides(cur Member) bool {
+	if u.Incarnation != cur.Incarnation {
+		return u.Incarnation > cur.Incarnation
+	}
+	return u.State.rank() > cur.State.rank()
+}
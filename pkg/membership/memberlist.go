@@ -0,0 +1,744 @@
+package membership
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Config configures a Memberlist. Zero values get defaults suitable for
+// a LAN
+type Config struct {
+	// Name identifies the node, it defaults to AdvertiseAddr
+	Name string
+	// BindAddr is the UDP address to gossip on, e.g. ":7946"
+	BindAddr string
+	// AdvertiseAddr is the address other nodes reach this one on. It
+	// defaults to BindAddr, with the hostname if BindAddr has no host
+	AdvertiseAddr string
+	// APIAddr is advertised to clients, e.g. the node's HTTP address
+	APIAddr string
+
+	// ProbeInterval is how often a member is probed. Defaults to 1s
+	ProbeInterval time.Duration
+	// ProbeTimeout is how long to wait for an ack before asking other
+	// members to probe. Defaults to 500ms
+	ProbeTimeout time.Duration
+	// IndirectChecks is the number of members asked to probe. Defaults to 3
+	IndirectChecks int
+	// SuspicionTimeout is how long a suspect has to refute the suspicion
+	// before it's declared dead. Defaults to 5s
+	SuspicionTimeout time.Duration
+	// SyncInterval is how often the full member list is exchanged with a
+	// random member. Defaults to 30s
+	SyncInterval time.Duration
+	// RetransmitMult scales the number of times each update is
+	// piggybacked, which is RetransmitMult * log10(members+1). Defaults to 4
+	RetransmitMult int
+	// DeadTimeout is how long dead and departed members are remembered
+	// before they're forgotten. Defaults to 1m
+	DeadTimeout time.Duration
+
+	// SecretKey, if set, signs the packets sent, and those received that
+	// aren't signed with it are dropped, so that only nodes sharing the
+	// key can take part. Packets are signed, not encrypted. Without a
+	// key, anyone who can reach BindAddr can change the member list
+	SecretKey []byte
+	// MaxMessageAge is how far the time a signed packet was sent at may
+	// be from the local clock before it's dropped, so that captured
+	// packets can't be replayed later. The nodes' clocks must agree
+	// within it. Defaults to 30s
+	MaxMessageAge time.Duration
+
+	ErrorLog *log.Logger
+}
+
+func (cfg *Config) setDefaults() error {
+	if cfg.BindAddr == "" {
+		return errors.New("membership: no bind address")
+	}
+	if cfg.AdvertiseAddr == "" {
+		cfg.AdvertiseAddr = cfg.BindAddr
+	}
+	host, port, err := net.SplitHostPort(cfg.AdvertiseAddr)
+	if err != nil {
+		return fmt.Errorf("membership: %w", err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if host, err = os.Hostname(); err != nil {
+			return fmt.Errorf("membership: %w", err)
+		}
+		cfg.AdvertiseAddr = net.JoinHostPort(host, port)
+	}
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = time.Second
+	}
+	if cfg.ProbeTimeout <= 0 {
+		cfg.ProbeTimeout = 500 * time.Millisecond
+	}
+	if cfg.ProbeTimeout > cfg.ProbeInterval {
+		cfg.ProbeTimeout = cfg.ProbeInterval
+	}
+	if cfg.IndirectChecks <= 0 {
+		cfg.IndirectChecks = 3
+	}
+	if cfg.SuspicionTimeout <= 0 {
+		cfg.SuspicionTimeout = 5 * time.Second
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = 30 * time.Second
+	}
+	if cfg.RetransmitMult <= 0 {
+		cfg.RetransmitMult = 4
+	}
+	if cfg.DeadTimeout <= 0 {
+		cfg.DeadTimeout = time.Minute
+	}
+	if cfg.MaxMessageAge <= 0 {
+		cfg.MaxMessageAge = 30 * time.Second
+	}
+	if cfg.ErrorLog == nil {
+		cfg.ErrorLog = log.New(ioutil.Discard, "", 0)
+	}
+	return nil
+}
+
+type msgType string
+
+const (
+	pingMsg    msgType = "ping"
+	ackMsg     msgType = "ack"
+	pingReqMsg msgType = "ping-req"
+	syncMsg    msgType = "sync"
+	syncAckMsg msgType = "sync-ack"
+)
+
+type message struct {
+	Type msgType `json:"type"`
+	Seq  uint64  `json:"seq,omitempty"`
+	// Target is the address to probe for ping-req
+	Target  string   `json:"target,omitempty"`
+	Updates []Member `json:"updates,omitempty"`
+	// Part numbers the packets of a member list split across several
+	Part int `json:"part,omitempty"`
+	// Time is when the message was sent, in Unix nanoseconds
+	Time int64 `json:"time"`
+}
+
+// maxPiggyback is the number of updates sent along with each message
+const maxPiggyback = 16
+
+// maxPacketSize bounds the packets sent, signature included, keeping
+// them under the usual MTU so they aren't fragmented. Member lists that
+// don't fit are split across packets
+const maxPacketSize = 1400
+
+// tombstone is what's remembered of a forgotten member, so that a
+// replayed update can't bring it back
+type tombstone struct {
+	incarnation uint64
+	until       time.Time
+}
+
+// broadcast is an update waiting to be piggybacked
+type broadcast struct {
+	update    Member
+	transmits int
+}
+
+// Memberlist is the local node's view of the cluster
+type Memberlist struct {
+	cfg  Config
+	conn net.PacketConn
+
+	mu         sync.Mutex
+	self       Member
+	members    map[string]Member
+	suspicions map[string]time.Time
+	deadSince  map[string]time.Time
+	tombstones map[string]tombstone
+	broadcasts map[string]*broadcast
+	pending    map[uint64]func()
+	seq        uint64
+	probeOrder []string
+	closed     bool
+	rejected   int64
+}
+
+// New starts listening for gossip on cfg.BindAddr. Call Run to take part
+// in the cluster
+func New(cfg Config) (*Memberlist, error) {
+	if err := cfg.setDefaults(); err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp", cfg.BindAddr)
+	if err != nil {
+		return nil, fmt.Errorf("membership: %w", err)
+	}
+	// advertise the port actually bound if any was asked for
+	if host, port, _ := net.SplitHostPort(cfg.AdvertiseAddr); port == "0" {
+		_, port, _ = net.SplitHostPort(conn.LocalAddr().String())
+		cfg.AdvertiseAddr = net.JoinHostPort(host, port)
+	}
+	if cfg.Name == "" {
+		cfg.Name = cfg.AdvertiseAddr
+	}
+	self := Member{
+		Name:    cfg.Name,
+		Addr:    cfg.AdvertiseAddr,
+		APIAddr: cfg.APIAddr,
+		State:   StateAlive,
+		// starting from the clock means a restarted node overrides what
+		// the cluster remembers about its previous run
+		Incarnation: uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+	}
+	m := &Memberlist{
+		cfg:        cfg,
+		conn:       conn,
+		self:       self,
+		members:    map[string]Member{self.Name: self},
+		suspicions: make(map[string]time.Time),
+		deadSince:  make(map[string]time.Time),
+		tombstones: make(map[string]tombstone),
+		broadcasts: make(map[string]*broadcast),
+		pending:    make(map[uint64]func()),
+	}
+	m.queue(self)
+	return m, nil
+}
+
+// LocalNode returns the local node
+func (m *Memberlist) LocalNode() Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.self
+}
+
+// Members returns every known member, including dead ones not yet
+// forgotten, sorted by name
+func (m *Memberlist) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := make([]Member, 0, len(m.members))
+	for _, mem := range m.members {
+		members = append(members, mem)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+// Join exchanges member lists with the seeds, given as gossip
+// addresses. It returns an error only if no seed could be sent to, the
+// replies arrive asynchronously
+func (m *Memberlist) Join(seeds []string) error {
+	var lastErr error
+	sent := 0
+	for _, seed := range seeds {
+		if seed == m.cfg.AdvertiseAddr || seed == m.cfg.BindAddr {
+			continue
+		}
+		if err := m.sendMembers(seed, syncMsg); err != nil {
+			lastErr = err
+			continue
+		}
+		sent++
+	}
+	if sent == 0 && lastErr != nil {
+		return lastErr
+	}
+	return nil
+}
+
+// Leave tells the cluster the node is leaving, so it isn't suspected,
+// and stops gossiping
+func (m *Memberlist) Leave() {
+	m.mu.Lock()
+	m.self.Incarnation++
+	m.self.State = StateLeft
+	m.members[m.self.Name] = m.self
+	left := m.self
+	var addrs []string
+	for _, mem := range m.members {
+		if mem.Name != m.self.Name && mem.IsLive() {
+			addrs = append(addrs, mem.Addr)
+		}
+	}
+	m.mu.Unlock()
+	for _, addr := range addrs {
+		m.send(addr, message{Type: syncMsg, Updates: []Member{left}})
+	}
+	m.Close()
+}
+
+// Close stops gossiping without telling the cluster, which will
+// eventually declare the node dead
+func (m *Memberlist) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	return m.conn.Close()
+}
+
+func (m *Memberlist) isClosed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
+
+// Run handles incoming gossip and probes members until Close is called
+func (m *Memberlist) Run() {
+	go m.receive()
+	probe := time.NewTicker(m.cfg.ProbeInterval)
+	defer probe.Stop()
+	syncTicker := time.NewTicker(m.cfg.SyncInterval)
+	defer syncTicker.Stop()
+	for !m.isClosed() {
+		select {
+		case <-probe.C:
+			m.probe()
+			m.expireSuspicions()
+			m.forgetDead()
+		case <-syncTicker.C:
+			if addr, ok := m.randomMember(); ok {
+				m.sendMembers(addr, syncMsg)
+			}
+		}
+	}
+}
+
+func (m *Memberlist) receive() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := m.conn.ReadFrom(buf)
+		if err != nil {
+			if m.isClosed() {
+				return
+			}
+			m.cfg.ErrorLog.Printf("membership: %s", err)
+			continue
+		}
+		msg, err := m.decode(buf[:n])
+		if err != nil {
+			m.mu.Lock()
+			m.rejected++
+			m.mu.Unlock()
+			m.cfg.ErrorLog.Printf("membership: invalid message from %s: %s", from, err)
+			continue
+		}
+		m.handle(msg, from.String())
+	}
+}
+
+func (m *Memberlist) handle(msg message, from string) {
+	for _, u := range msg.Updates {
+		m.merge(u)
+	}
+	switch msg.Type {
+	case pingMsg:
+		m.send(from, message{Type: ackMsg, Seq: msg.Seq})
+	case ackMsg:
+		m.mu.Lock()
+		fn, ok := m.pending[msg.Seq]
+		delete(m.pending, msg.Seq)
+		m.mu.Unlock()
+		if ok {
+			fn()
+		}
+	case pingReqMsg:
+		// probe the target on the requester's behalf, relaying the ack.
+		// Only members are probed, so that the node can't be made to
+		// send packets anywhere
+		if !m.isMember(msg.Target) {
+			return
+		}
+		seq := m.expectAck(func() {
+			m.send(from, message{Type: ackMsg, Seq: msg.Seq})
+		}, m.cfg.ProbeInterval)
+		m.send(msg.Target, message{Type: pingMsg, Seq: seq})
+	case syncMsg:
+		// a list split across packets is answered once
+		if msg.Part == 0 {
+			m.sendMembers(from, syncAckMsg)
+		}
+	}
+}
+
+// isMember reports whether addr is the gossip address of a live member
+func (m *Memberlist) isMember(addr string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, mem := range m.members {
+		if name != m.self.Name && mem.Addr == addr && mem.IsLive() {
+			return true
+		}
+	}
+	return false
+}
+
+// merge applies an update to the member list, queueing it to be gossiped
+// on if it's news. Suspicions of the local node are refuted
+func (m *Memberlist) merge(u Member) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u.Name == m.self.Name {
+		if u.State != StateAlive && m.self.State == StateAlive && u.Incarnation >= m.self.Incarnation {
+			m.self.Incarnation = u.Incarnation + 1
+			m.members[m.self.Name] = m.self
+			m.queue(m.self)
+		}
+		return
+	}
+	cur, known := m.members[u.Name]
+	if known && !u.overrides(cur) {
+		return
+	}
+	if !known && !u.IsLive() {
+		// nothing to forget
+		return
+	}
+	if t, ok := m.tombstones[u.Name]; ok && !known {
+		if u.Incarnation <= t.incarnation {
+			// news from before it was forgotten
+			return
+		}
+		delete(m.tombstones, u.Name)
+	}
+	m.members[u.Name] = u
+	if u.IsLive() {
+		delete(m.deadSince, u.Name)
+	} else if _, ok := m.deadSince[u.Name]; !ok {
+		m.deadSince[u.Name] = time.Now()
+	}
+	if u.State == StateSuspect {
+		if _, ok := m.suspicions[u.Name]; !ok {
+			m.suspicions[u.Name] = time.Now()
+		}
+	} else {
+		delete(m.suspicions, u.Name)
+	}
+	if known && cur.State != u.State {
+		m.cfg.ErrorLog.Printf("membership: %s is %s", u.Name, u.State)
+	}
+	m.queue(u)
+}
+
+// queue schedules an update to be piggybacked. Callers hold mu
+func (m *Memberlist) queue(u Member) {
+	m.broadcasts[u.Name] = &broadcast{update: u}
+}
+
+// piggyback returns the updates to send with the next message, those
+// sent the fewest times first, that fit in room bytes. Callers hold mu
+func (m *Memberlist) piggyback(room int) []Member {
+	if len(m.broadcasts) == 0 {
+		return nil
+	}
+	limit := m.cfg.RetransmitMult * int(math.Ceil(math.Log10(float64(len(m.members)+1))))
+	queued := make([]*broadcast, 0, len(m.broadcasts))
+	for _, b := range m.broadcasts {
+		queued = append(queued, b)
+	}
+	sort.Slice(queued, func(i, j int) bool { return queued[i].transmits < queued[j].transmits })
+	var updates []Member
+	for _, b := range queued {
+		if len(updates) == maxPiggyback {
+			break
+		}
+		// the update and the comma separating it from the others
+		size := encodedSize(b.update) + 1
+		if size > room {
+			continue
+		}
+		room -= size
+		updates = append(updates, b.update)
+		b.transmits++
+		if b.transmits >= limit {
+			delete(m.broadcasts, b.update.Name)
+		}
+	}
+	return updates
+}
+
+func encodedSize(u Member) int {
+	b, _ := json.Marshal(u)
+	return len(b)
+}
+
+// send sends the message along with as many piggybacked updates as fit
+// in a packet
+func (m *Memberlist) send(addr string, msg message) error {
+	b, err := m.encode(msg)
+	if err != nil {
+		return err
+	}
+	// the updates field is added if the message had none
+	room := maxPacketSize - len(b) - len(`,"updates":[]`)
+	m.mu.Lock()
+	updates := m.piggyback(room)
+	m.mu.Unlock()
+	if len(updates) > 0 {
+		msg.Updates = append(msg.Updates, updates...)
+		if b, err = m.encode(msg); err != nil {
+			return err
+		}
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	_, err = m.conn.WriteTo(b, udpAddr)
+	return err
+}
+
+// sendMembers sends the member list as messages of the type, split
+// across packets so that each stays under maxPacketSize with some room
+// left for piggybacked updates
+func (m *Memberlist) sendMembers(addr string, typ msgType) error {
+	empty, err := m.encode(message{Type: typ, Part: 1})
+	if err != nil {
+		return err
+	}
+	room := (maxPacketSize - len(empty) - len(`,"updates":[]`)) * 3 / 4
+	msg := message{Type: typ}
+	size := 0
+	for _, mem := range m.Members() {
+		memSize := encodedSize(mem) + 1
+		if len(msg.Updates) > 0 && size+memSize > room {
+			if err := m.send(addr, msg); err != nil {
+				return err
+			}
+			msg = message{Type: typ, Part: msg.Part + 1}
+			size = 0
+		}
+		msg.Updates = append(msg.Updates, mem)
+		size += memSize
+	}
+	return m.send(addr, msg)
+}
+
+// encode returns the packet of the message, stamped with the time and
+// signed if there's a key
+func (m *Memberlist) encode(msg message) ([]byte, error) {
+	msg.Time = time.Now().UnixNano()
+	b, err := json.Marshal(msg)
+	if err != nil || m.cfg.SecretKey == nil {
+		return b, err
+	}
+	return append(m.sign(b), b...), nil
+}
+
+// decode returns the message of the packet, failing if there's a key
+// and the packet isn't signed with it or wasn't sent within
+// MaxMessageAge, as it may be replayed
+func (m *Memberlist) decode(p []byte) (message, error) {
+	signed := m.cfg.SecretKey != nil
+	if signed {
+		if len(p) < sha256.Size || !hmac.Equal(p[:sha256.Size], m.sign(p[sha256.Size:])) {
+			return message{}, errors.New("bad signature")
+		}
+		p = p[sha256.Size:]
+	}
+	var msg message
+	if err := json.Unmarshal(p, &msg); err != nil {
+		return message{}, err
+	}
+	if age := time.Since(time.Unix(0, msg.Time)); signed && (age > m.cfg.MaxMessageAge || age < -m.cfg.MaxMessageAge) {
+		return message{}, fmt.Errorf("sent %s ago, past the max age of %s", age.Round(time.Millisecond), m.cfg.MaxMessageAge)
+	}
+	return msg, nil
+}
+
+func (m *Memberlist) sign(b []byte) []byte {
+	mac := hmac.New(sha256.New, m.cfg.SecretKey)
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+// expectAck registers fn to be called when the ack with the returned
+// sequence number arrives within timeout
+func (m *Memberlist) expectAck(fn func(), timeout time.Duration) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	seq := m.seq
+	m.pending[seq] = fn
+	time.AfterFunc(timeout, func() {
+		m.mu.Lock()
+		delete(m.pending, seq)
+		m.mu.Unlock()
+	})
+	return seq
+}
+
+// nextTarget returns the next member to probe. Members are probed
+// round-robin in a random order that's reshuffled every round, so each
+// is probed within a bounded time
+func (m *Memberlist) nextTarget() (Member, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		if len(m.probeOrder) == 0 {
+			for name, mem := range m.members {
+				if name != m.self.Name && mem.IsLive() {
+					m.probeOrder = append(m.probeOrder, name)
+				}
+			}
+			if len(m.probeOrder) == 0 {
+				return Member{}, false
+			}
+			rand.Shuffle(len(m.probeOrder), func(i, j int) {
+				m.probeOrder[i], m.probeOrder[j] = m.probeOrder[j], m.probeOrder[i]
+			})
+		}
+		name := m.probeOrder[0]
+		m.probeOrder = m.probeOrder[1:]
+		if mem, ok := m.members[name]; ok && mem.IsLive() {
+			return mem, true
+		}
+	}
+}
+
+// randomMembers returns up to n live members other than the local node
+// and the excluded one
+func (m *Memberlist) randomMembers(n int, exclude string) []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var candidates []Member
+	for name, mem := range m.members {
+		if name != m.self.Name && name != exclude && mem.IsLive() {
+			candidates = append(candidates, mem)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+func (m *Memberlist) randomMember() (string, bool) {
+	members := m.randomMembers(1, "")
+	if len(members) == 0 {
+		return "", false
+	}
+	return members[0].Addr, true
+}
+
+// probe pings the next member, then asks others to ping it if it doesn't
+// ack in time, and suspects it if no ack arrives by the end of the
+// probe interval
+func (m *Memberlist) probe() {
+	target, ok := m.nextTarget()
+	if !ok {
+		return
+	}
+	acked := make(chan struct{})
+	var once sync.Once
+	seq := m.expectAck(func() { once.Do(func() { close(acked) }) }, m.cfg.ProbeInterval)
+	if err := m.send(target.Addr, message{Type: pingMsg, Seq: seq}); err != nil {
+		m.cfg.ErrorLog.Printf("membership: pinging %s: %s", target.Name, err)
+	}
+	select {
+	case <-acked:
+		return
+	case <-time.After(m.cfg.ProbeTimeout):
+	}
+	for _, mem := range m.randomMembers(m.cfg.IndirectChecks, target.Name) {
+		m.send(mem.Addr, message{Type: pingReqMsg, Seq: seq, Target: target.Addr})
+	}
+	select {
+	case <-acked:
+		return
+	case <-time.After(m.cfg.ProbeInterval - m.cfg.ProbeTimeout):
+	}
+	if target.State == StateAlive {
+		target.State = StateSuspect
+		m.merge(target)
+	}
+}
+
+// expireSuspicions declares the suspects that didn't refute in time dead
+func (m *Memberlist) expireSuspicions() {
+	m.mu.Lock()
+	var expired []Member
+	for name, since := range m.suspicions {
+		if time.Since(since) >= m.cfg.SuspicionTimeout {
+			if mem := m.members[name]; mem.State == StateSuspect {
+				mem.State = StateDead
+				expired = append(expired, mem)
+			}
+		}
+	}
+	m.mu.Unlock()
+	for _, mem := range expired {
+		m.merge(mem)
+	}
+}
+
+// forgetDead forgets the members dead or departed for DeadTimeout. Their
+// incarnation is remembered for as long as updates from before they
+// were forgotten may still be received
+func (m *Memberlist) forgetDead() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for name, t := range m.tombstones {
+		if now.After(t.until) {
+			delete(m.tombstones, name)
+		}
+	}
+	for name, since := range m.deadSince {
+		if now.Sub(since) >= m.cfg.DeadTimeout {
+			m.tombstones[name] = tombstone{m.members[name].Incarnation, now.Add(m.cfg.MaxMessageAge)}
+			delete(m.members, name)
+			delete(m.deadSince, name)
+			delete(m.broadcasts, name)
+		}
+	}
+}
+
+// Stats returns the number of members in each state, and of packets
+// dropped for being invalid or not signed with the key
+func (m *Memberlist) Stats() map[string]string {
+	counts := make(map[State]int)
+	for _, mem := range m.Members() {
+		counts[mem.State]++
+	}
+	m.mu.Lock()
+	rejected := m.rejected
+	m.mu.Unlock()
+	return map[string]string{
+		"cluster_members_alive":    strconv.Itoa(counts[StateAlive]),
+		"cluster_members_suspect":  strconv.Itoa(counts[StateSuspect]),
+		"cluster_members_dead":     strconv.Itoa(counts[StateDead] + counts[StateLeft]),
+		"cluster_packets_rejected": strconv.FormatInt(rejected, 10),
+	}
+}
+
+type membersReply struct {
+	Reply   string   `json:"reply"`
+	Members []Member `json:"members"`
+}
+
+// HandleMembers serves the member list as JSON, in the same shape as the
+// server's other replies
+func (m *Memberlist) HandleMembers(w http.ResponseWriter, r *http.Request) {
+	jsonString, _ := json.Marshal(membersReply{"OK", m.Members()})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonString)
+}
@@ -0,0 +1,197 @@
+package membership
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestNode(t *testing.T, name string) *Memberlist {
+	return newTestNodeWith(t, Config{Name: name})
+}
+
+func newTestNodeWith(t *testing.T, cfg Config) *Memberlist {
+	cfg.BindAddr = "127.0.0.1:0"
+	cfg.ProbeInterval = 50 * time.Millisecond
+	cfg.ProbeTimeout = 20 * time.Millisecond
+	cfg.SuspicionTimeout = 200 * time.Millisecond
+	cfg.SyncInterval = time.Second
+	m, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go m.Run()
+	return m
+}
+
+func stateOf(m *Memberlist, name string) State {
+	for _, mem := range m.Members() {
+		if mem.Name == name {
+			return mem.State
+		}
+	}
+	return ""
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMembership(t *testing.T) {
+	a := newTestNode(t, "a")
+	defer a.Close()
+	b := newTestNode(t, "b")
+	defer b.Close()
+	c := newTestNode(t, "c")
+
+	// b and c only know about a, they learn of each other through gossip
+	b.Join([]string{a.LocalNode().Addr})
+	c.Join([]string{a.LocalNode().Addr})
+	waitFor(t, "every node to see every other", func() bool {
+		for _, m := range []*Memberlist{a, b, c} {
+			for _, name := range []string{"a", "b", "c"} {
+				if stateOf(m, name) != StateAlive {
+					return false
+				}
+			}
+		}
+		return true
+	})
+
+	c.Close()
+	waitFor(t, "c to be declared dead", func() bool {
+		return stateOf(a, "c") == StateDead && stateOf(b, "c") == StateDead
+	})
+	if stateOf(a, "b") != StateAlive || stateOf(b, "a") != StateAlive {
+		t.Errorf("expected a and b to stay alive, got %v and %v", a.Members(), b.Members())
+	}
+
+	b.Leave()
+	waitFor(t, "b to have left", func() bool {
+		return stateOf(a, "b") == StateLeft
+	})
+}
+
+func TestForgetDead(t *testing.T) {
+	a := newTestNodeWith(t, Config{Name: "a", DeadTimeout: 300 * time.Millisecond})
+	defer a.Close()
+	b := newTestNode(t, "b")
+	b.Join([]string{a.LocalNode().Addr})
+	waitFor(t, "a to see b", func() bool { return stateOf(a, "b") == StateAlive })
+	b.Close()
+	waitFor(t, "b to be declared dead", func() bool { return stateOf(a, "b") == StateDead })
+	waitFor(t, "b to be forgotten", func() bool { return stateOf(a, "b") == "" })
+}
+
+func TestSecretKey(t *testing.T) {
+	a := newTestNodeWith(t, Config{Name: "a", SecretKey: []byte("one")})
+	defer a.Close()
+	b := newTestNodeWith(t, Config{Name: "b", SecretKey: []byte("one")})
+	defer b.Close()
+	intruder := newTestNodeWith(t, Config{Name: "intruder", SecretKey: []byte("two")})
+	defer intruder.Close()
+	open := newTestNode(t, "open")
+	defer open.Close()
+
+	b.Join([]string{a.LocalNode().Addr})
+	intruder.Join([]string{a.LocalNode().Addr})
+	open.Join([]string{a.LocalNode().Addr})
+	waitFor(t, "a and b to see each other", func() bool {
+		return stateOf(a, "b") == StateAlive && stateOf(b, "a") == StateAlive
+	})
+	waitFor(t, "a to reject the unsigned packets", func() bool {
+		return a.Stats()["cluster_packets_rejected"] == "2"
+	})
+	if stateOf(a, "intruder") != "" || stateOf(a, "open") != "" || stateOf(intruder, "a") != "" {
+		t.Errorf("nodes without the key joined: %v, %v", a.Members(), intruder.Members())
+	}
+}
+
+func TestStaleMessagesAreRejected(t *testing.T) {
+	key := []byte("one")
+	a := newTestNodeWith(t, Config{Name: "a", SecretKey: key, MaxMessageAge: 200 * time.Millisecond})
+	defer a.Close()
+	b, err := New(Config{Name: "b", BindAddr: "127.0.0.1:0", SecretKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// a signed packet captured and replayed past the max age
+	p, err := b.encode(message{Type: ackMsg, Updates: []Member{{Name: "ghost", Addr: "127.0.0.1:1", State: StateAlive, Incarnation: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if _, err := b.conn.WriteTo(p, a.conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a to reject the replayed packet", func() bool {
+		return a.Stats()["cluster_packets_rejected"] == "1"
+	})
+	if stateOf(a, "ghost") != "" {
+		t.Errorf("replayed packet was applied: %v", a.Members())
+	}
+}
+
+func TestForgottenMembersStayForgotten(t *testing.T) {
+	a := newTestNodeWith(t, Config{Name: "a", DeadTimeout: 100 * time.Millisecond})
+	defer a.Close()
+	ghost := Member{Name: "ghost", Addr: "127.0.0.1:1", State: StateAlive, Incarnation: 3}
+	a.merge(ghost)
+	dead := ghost
+	dead.State = StateDead
+	a.merge(dead)
+	waitFor(t, "ghost to be forgotten", func() bool { return stateOf(a, "ghost") == "" })
+
+	// news from before it died can't bring it back, only a newer incarnation
+	a.merge(ghost)
+	if stateOf(a, "ghost") != "" {
+		t.Errorf("an update from before ghost was forgotten brought it back: %v", a.Members())
+	}
+	ghost.Incarnation++
+	a.merge(ghost)
+	if stateOf(a, "ghost") != StateAlive {
+		t.Errorf("a newer incarnation of ghost wasn't applied: %v", a.Members())
+	}
+}
+
+func TestPingReqOnlyProbesMembers(t *testing.T) {
+	a := newTestNode(t, "a")
+	defer a.Close()
+	victim, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer victim.Close()
+	a.handle(message{Type: pingReqMsg, Seq: 1, Target: victim.LocalAddr().String()}, "127.0.0.1:1")
+	victim.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := victim.ReadFrom(make([]byte, 1024)); err == nil {
+		t.Error("probed an address that isn't a member's")
+	}
+}
+
+func TestLargeSyncIsSplit(t *testing.T) {
+	a := newTestNode(t, "a")
+	defer a.Close()
+	for i := 0; i < 100; i++ {
+		a.merge(Member{
+			Name:        fmt.Sprintf("node-with-a-long-name-%03d", i),
+			Addr:        fmt.Sprintf("127.0.0.1:%d", 1+i),
+			APIAddr:     fmt.Sprintf("http://127.0.0.1:%d", 1+i),
+			State:       StateAlive,
+			Incarnation: 1,
+		})
+	}
+	b := newTestNode(t, "b")
+	defer b.Close()
+	b.Join([]string{a.LocalNode().Addr})
+	waitFor(t, "b to learn every member", func() bool { return len(b.Members()) >= 102 })
+}
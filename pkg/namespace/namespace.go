@@ -0,0 +1,231 @@
+// Package namespace keeps several independent caches in one process,
+// each with its own policy, capacity, default TTL and stats
+package namespace
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+)
+
+// Config describes a namespace
+type Config struct {
+	Name       string
+	CacheType  string
+	Capacity   int
+	DefaultTTL time.Duration
+	// Reserved is the bytes of keys and values the namespace may hold
+	// whatever the others hold. Max is the most it may hold, zero for no
+	// limit but the budget's, see New
+	Reserved int
+	Max      int
+	// Options are the policy's, see cache.ParseOptions
+	Options cache.Options
+}
+
+// Parse parses a namespace given as its name followed by comma separated
+// name=value settings: type, capacity, ttl, reserved and max, the others
+// being policy options, e.g. "sessions,type=lru,capacity=1000,ttl=30m"
+func Parse(s string) (Config, error) {
+	parts := strings.SplitN(s, ",", 2)
+	cfg := Config{Name: strings.TrimSpace(parts[0]), CacheType: "lfu"}
+	if err := validName(cfg.Name); err != nil {
+		return Config{}, err
+	}
+	if len(parts) == 1 {
+		return cfg, nil
+	}
+	opts, err := cache.ParseOptions(parts[1])
+	if err != nil {
+		return Config{}, fmt.Errorf("namespace %s: %w", cfg.Name, err)
+	}
+	if t, ok := opts["type"]; ok {
+		cfg.CacheType = t
+		delete(opts, "type")
+	}
+	if cfg.Capacity, err = opts.Int("capacity", 0); err != nil {
+		return Config{}, fmt.Errorf("namespace %s: %w", cfg.Name, err)
+	}
+	delete(opts, "capacity")
+	if cfg.Reserved, err = opts.Int("reserved", 0); err != nil {
+		return Config{}, fmt.Errorf("namespace %s: %w", cfg.Name, err)
+	}
+	delete(opts, "reserved")
+	if cfg.Max, err = opts.Int("max", 0); err != nil {
+		return Config{}, fmt.Errorf("namespace %s: %w", cfg.Name, err)
+	}
+	delete(opts, "max")
+	if ttl, ok := opts["ttl"]; ok {
+		if cfg.DefaultTTL, err = time.ParseDuration(ttl); err != nil {
+			return Config{}, fmt.Errorf("namespace %s: invalid ttl %q", cfg.Name, ttl)
+		}
+		delete(opts, "ttl")
+	}
+	cfg.Options = opts
+	return cfg, nil
+}
+
+// validName checks the name can be used as a path segment
+func validName(name string) error {
+	if name == "" {
+		return errors.New("namespace without a name")
+	}
+	if strings.ContainsAny(name, "/?#%= ") {
+		return fmt.Errorf("invalid namespace name %q", name)
+	}
+	return nil
+}
+
+// Namespaces are the caches by name, fixed by New. It's safe for
+// concurrent use
+type Namespaces struct {
+	caches  map[string]*cache.Adapter
+	configs map[string]Config
+	budget  int
+
+	// mu serializes Reclaim, which is all that changes evictions
+	mu        sync.Mutex
+	evictions map[string]int64
+}
+
+// New creates the cache of every namespace. The namespaces together may
+// hold at most budget bytes of keys and values, zero for no limit. It
+// must cover their reservations
+func New(configs []Config, budget int) (*Namespaces, error) {
+	n := &Namespaces{
+		caches:    make(map[string]*cache.Adapter),
+		configs:   make(map[string]Config),
+		budget:    budget,
+		evictions: make(map[string]int64),
+	}
+	reserved := 0
+	for _, cfg := range configs {
+		if err := validName(cfg.Name); err != nil {
+			return nil, err
+		}
+		if _, dup := n.caches[cfg.Name]; dup {
+			return nil, fmt.Errorf("namespace %s given twice", cfg.Name)
+		}
+		if cfg.Max > 0 && cfg.Reserved > cfg.Max {
+			return nil, fmt.Errorf("namespace %s: reserved is over max", cfg.Name)
+		}
+		c, err := cache.NewCache(cfg.CacheType, cfg.Capacity, cfg.Options)
+		if err != nil {
+			return nil, fmt.Errorf("namespace %s: %w", cfg.Name, err)
+		}
+		if _, ok := c.Bytes(); !ok && (cfg.Max > 0 || budget > 0) {
+			return nil, fmt.Errorf("namespace %s: cache type %s can't tell its size for quotas", cfg.Name, cfg.CacheType)
+		}
+		c.SetDefaultTTL(cfg.DefaultTTL)
+		n.caches[cfg.Name] = c
+		n.configs[cfg.Name] = cfg
+		reserved += cfg.Reserved
+	}
+	if budget > 0 && reserved > budget {
+		return nil, fmt.Errorf("namespaces reserve %d bytes, over the budget of %d", reserved, budget)
+	}
+	return n, nil
+}
+
+// Get returns the cache of the namespace
+func (n *Namespaces) Get(name string) (*cache.Adapter, bool) {
+	c, ok := n.caches[name]
+	return c, ok
+}
+
+// Names returns the namespaces' names, sorted
+func (n *Namespaces) Names() []string {
+	names := make([]string, 0, len(n.caches))
+	for name := range n.caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reclaim evicts entries from namespaces over their max, then, while the
+// namespaces are over the budget, from the one furthest over its
+// reservation, down to it at most, so that a namespace filling up evicts
+// its own entries before those of namespaces within their reservation.
+// It's called after writes, so quotas may be exceeded by the size of a
+// write in the meantime
+func (n *Namespaces) Reclaim() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for name, cfg := range n.configs {
+		if cfg.Max <= 0 {
+			continue
+		}
+		if bytes, _ := n.caches[name].Bytes(); bytes > cfg.Max {
+			_, evicted := n.caches[name].Evict(bytes - cfg.Max)
+			n.evictions[name] += int64(evicted)
+		}
+	}
+	if n.budget <= 0 {
+		return
+	}
+	// sizes are kept up to date from what each eviction frees, rather
+	// than asked of every namespace again
+	sizes := make(map[string]int, len(n.caches))
+	total := 0
+	for name, c := range n.caches {
+		sizes[name], _ = c.Bytes()
+		total += sizes[name]
+	}
+	for total > n.budget {
+		victim, furthest := "", 0
+		for name, bytes := range sizes {
+			if over := bytes - n.configs[name].Reserved; over > furthest {
+				victim, furthest = name, over
+			}
+		}
+		if victim == "" {
+			return
+		}
+		need := total - n.budget
+		if need > furthest {
+			need = furthest
+		}
+		freed, evicted := n.caches[victim].Evict(need)
+		n.evictions[victim] += int64(evicted)
+		total -= freed
+		sizes[victim] -= freed
+		if freed < need {
+			// nothing more can be evicted from it
+			delete(sizes, victim)
+		}
+	}
+}
+
+// Stats reports the namespaces, the size of each and their quotas. Their
+// full stats are each cache's own
+func (n *Namespaces) Stats() map[string]string {
+	stats := map[string]string{
+		"namespaces": strings.Join(n.Names(), ","),
+	}
+	total := 0
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, name := range n.Names() {
+		_, st := n.caches[name].Stats()
+		for _, stat := range []string{"cache_type", "capacity", "curr_items", "get_hits", "get_misses", "bytes"} {
+			if val, ok := st[stat]; ok {
+				stats["ns_"+name+"_"+stat] = val
+			}
+		}
+		bytes, _ := n.caches[name].Bytes()
+		total += bytes
+		stats["ns_"+name+"_reserved"] = strconv.Itoa(n.configs[name].Reserved)
+		stats["ns_"+name+"_max"] = strconv.Itoa(n.configs[name].Max)
+		stats["ns_"+name+"_quota_evictions"] = strconv.FormatInt(n.evictions[name], 10)
+	}
+	stats["namespace_bytes"] = strconv.Itoa(total)
+	stats["namespace_budget"] = strconv.Itoa(n.budget)
+	return stats
+}
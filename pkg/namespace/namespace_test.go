@@ -0,0 +1,205 @@
+package namespace
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	_ "github.com/nagamocha3000/go-memcached/pkg/cache/policies"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Config
+		err  bool
+	}{
+		{"plain", Config{Name: "plain", CacheType: "lfu"}, false},
+		{"sessions,type=lru,capacity=1000,ttl=30m", Config{
+			Name: "sessions", CacheType: "lru", Capacity: 1000, DefaultTTL: 30 * time.Minute, Options: cache.Options{},
+		}, false},
+		{"quota,reserved=100,max=200", Config{
+			Name: "quota", CacheType: "lfu", Reserved: 100, Max: 200, Options: cache.Options{},
+		}, false},
+		{"hot,capacity=10,maxFrequency=16", Config{
+			Name: "hot", CacheType: "lfu", Capacity: 10, Options: cache.Options{"maxFrequency": "16"},
+		}, false},
+		{"", Config{}, true},
+		{"a/b", Config{}, true},
+		{"x,capacity=many", Config{}, true},
+		{"x,ttl=soon", Config{}, true},
+		{"x,nonsense", Config{}, true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: got error %v", tt.in, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNamespacesAreIndependent(t *testing.T) {
+	n, err := New([]Config{
+		{Name: "a", CacheType: "lru", Capacity: 1},
+		{Name: "b", CacheType: "lfu", Capacity: 10, DefaultTTL: time.Hour},
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := n.Get("a")
+	b, _ := n.Get("b")
+	a.Set("k", "in a", "-1", nil)
+	b.Set("k", "in b", "-1", nil)
+	a.Set("k2", "evicts k", "-1", nil)
+	if reply, _ := a.Get("k"); reply != cache.NotFoundReply {
+		t.Errorf("a: expected k evicted, got %s", reply)
+	}
+	if _, val := b.Get("k"); val != "in b" {
+		t.Errorf("b: got %q", val)
+	}
+	if _, ok := n.Get("c"); ok {
+		t.Error("got a namespace that wasn't configured")
+	}
+	st := n.Stats()
+	if st["namespaces"] != "a,b" || st["ns_a_cache_type"] != "lru" || st["ns_b_get_hits"] != "1" {
+		t.Errorf("stats: got %v", st)
+	}
+	if _, bst := b.Stats(); bst["default_ttl"] != "3600" {
+		t.Errorf("b's default ttl: got %q", bst["default_ttl"])
+	}
+
+	if _, err := New([]Config{{Name: "a", CacheType: "lru"}, {Name: "a", CacheType: "lru"}}, 0); err == nil {
+		t.Error("duplicate namespace: got no error")
+	}
+	if _, err := New([]Config{{Name: "a", CacheType: "nope"}}, 0); err == nil {
+		t.Error("unknown cache type: got no error")
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	if _, err := New([]Config{{Name: "a", CacheType: "lru", Reserved: 20}, {Name: "b", CacheType: "lru", Reserved: 20}}, 30); err == nil {
+		t.Error("reservations over the budget: got no error")
+	}
+	// every entry is a 1 byte key and a 9 byte value
+	n, err := New([]Config{
+		{Name: "quiet", CacheType: "lru", Reserved: 30},
+		{Name: "noisy", CacheType: "lfu", Reserved: 20},
+		{Name: "capped", CacheType: "lru", Max: 20},
+	}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quiet, _ := n.Get("quiet")
+	noisy, _ := n.Get("noisy")
+	capped, _ := n.Get("capped")
+	for _, k := range "abc" {
+		quiet.Set(string(k), "123456789", "-1", nil)
+	}
+	for _, k := range "abcdefghij" {
+		noisy.Set(string(k), "123456789", "-1", nil)
+		n.Reclaim()
+	}
+	for _, k := range "abc" {
+		capped.Set(string(k), "123456789", "-1", nil)
+		n.Reclaim()
+	}
+	bytes := func(c *cache.Adapter) int {
+		b, _ := c.Bytes()
+		return b
+	}
+	if got := bytes(capped); got != 20 {
+		t.Errorf("capped holds %d bytes, want its max of 20", got)
+	}
+	if got := bytes(quiet); got != 30 {
+		t.Errorf("quiet holds %d bytes, want its reservation of 30 kept", got)
+	}
+	if got := bytes(noisy); got != 50 {
+		t.Errorf("noisy holds %d bytes, want the 50 left of the budget", got)
+	}
+	if reply, _ := noisy.Get("j"); reply != cache.ValueReply {
+		t.Errorf("noisy's latest entry was evicted rather than its oldest")
+	}
+	st := n.Stats()
+	if st["ns_noisy_quota_evictions"] != "5" || st["ns_capped_quota_evictions"] != "1" || st["namespace_bytes"] != "100" {
+		t.Errorf("stats: got %v", st)
+	}
+}
+
+func TestReclaimLargeWrite(t *testing.T) {
+	n, err := New([]Config{{Name: "a", CacheType: "lru"}, {Name: "b", CacheType: "lru", Reserved: 20}}, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := n.Get("a")
+	b, _ := n.Get("b")
+	var mutations []cache.Mutation
+	a.OnMutation(func(m cache.Mutation) { mutations = append(mutations, m) })
+	a.SetWriteHook(func(m cache.Mutation) error {
+		if m.Op == cache.DeleteMutation {
+			return errors.New("store down")
+		}
+		return nil
+	})
+	for _, k := range "abcd" {
+		a.Set(string(k), "123456789", "-1", nil)
+	}
+	b.Set("a", "123456789", "-1", nil)
+	b.Set("b", "123456789", "-1", nil)
+	mutations = nil
+	// 3 + 27 bytes, evicting three of a's entries in one go, and none of
+	// b's as it's within its reservation
+	a.Set("big", "123456789012345678901234567", "-1", nil)
+	n.Reclaim()
+	if got, _ := a.Bytes(); got != 40 {
+		t.Errorf("a holds %d bytes, want 40", got)
+	}
+	if got, _ := b.Bytes(); got != 20 {
+		t.Errorf("b holds %d bytes, want its reservation of 20", got)
+	}
+	if st := n.Stats(); st["ns_a_quota_evictions"] != "3" || st["ns_b_quota_evictions"] != "0" {
+		t.Errorf("stats: got %v", st)
+	}
+	// evictions aren't deletes, so the store isn't asked to delete the
+	// keys and its failing doesn't bring them back
+	if len(mutations) != 1 || mutations[0].Op != cache.SetMutation {
+		t.Errorf("got mutations %+v, want only the set", mutations)
+	}
+}
+
+func TestQuotasSkipExpiredEntries(t *testing.T) {
+	n, err := New([]Config{{Name: "capped", CacheType: "lru", Max: 20}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	capped, _ := n.Get("capped")
+	for _, k := range "ab" {
+		capped.Set(string(k), "123456789", "1", nil)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if got, _ := capped.Bytes(); got != 0 {
+		t.Errorf("holds %d bytes once all expired, want 0", got)
+	}
+	// expired entries, only removed as they're come across, go first
+	for _, k := range "ab" {
+		capped.Set(string(k), "123456789", "1", nil)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	for _, k := range "cd" {
+		capped.Set(string(k), "123456789", "-1", nil)
+		n.Reclaim()
+	}
+	for _, k := range "cd" {
+		if reply, _ := capped.Get(string(k)); reply != cache.ValueReply {
+			t.Errorf("%c was evicted while expired entries were kept", k)
+		}
+	}
+	if st := n.Stats(); st["ns_capped_quota_evictions"] != "0" || st["ns_capped_bytes"] != "20" {
+		t.Errorf("stats: got %v", st)
+	}
+}
@@ -0,0 +1,128 @@
+// Package ratelimit limits how often each client may make requests, with
+// a token bucket per client
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepEvery is the number of calls to Allow after which the buckets of
+// clients that have been idle long enough to be full again are dropped
+const sweepEvery = 1024
+
+// Limit is how many requests a client may make
+type Limit struct {
+	// Rate is the sustained number of requests per second
+	Rate float64
+	// Burst is how many requests may be made at once after being idle,
+	// at least 1
+	Burst int
+}
+
+// ParseLimit parses a limit given as rate or rate:burst, the burst
+// defaulting to the rate rounded up
+func ParseLimit(s string) (Limit, error) {
+	rateStr, burstStr := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		rateStr, burstStr = s[:i], s[i+1:]
+	}
+	rate, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil || rate <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate %q, want requests per second", rateStr)
+	}
+	burst := int(rate)
+	if float64(burst) < rate {
+		burst++
+	}
+	if burstStr != "" {
+		if burst, err = strconv.Atoi(burstStr); err != nil || burst < 1 {
+			return Limit{}, fmt.Errorf("invalid burst %q", burstStr)
+		}
+	}
+	return Limit{Rate: rate, Burst: burst}, nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter holds a token bucket per client. It's safe for concurrent use
+type Limiter struct {
+	limit Limit
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	calls     int
+	throttled int64
+}
+
+// New returns a Limiter allowing each client the limit
+func New(limit Limit) *Limiter {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &Limiter{
+		limit:   limit,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the client's bucket, returning false if
+// there's none left, along with how long until there will be
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if l.calls++; l.calls >= sweepEvery {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	l.throttled++
+	wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// refill returns the tokens of the bucket as of now
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.limit.Rate
+	if burst := float64(l.limit.Burst); tokens > burst {
+		return burst
+	}
+	return tokens
+}
+
+// sweep drops full buckets, which are the same as none, the caller
+// holding mu
+func (l *Limiter) sweep(now time.Time) {
+	l.calls = 0
+	for client, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.limit.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// Stats returns the number of clients being limited and of requests
+// throttled
+func (l *Limiter) Stats() (clients int, throttled int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets), l.throttled
+}
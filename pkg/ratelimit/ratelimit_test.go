@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Limit
+		err  bool
+	}{
+		{"100", Limit{100, 100}, false},
+		{"0.5", Limit{0.5, 1}, false},
+		{"10:50", Limit{10, 50}, false},
+		{"", Limit{}, true},
+		{"-1", Limit{}, true},
+		{"10:0", Limit{}, true},
+		{"fast", Limit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("%q: got %+v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := New(Limit{Rate: 2, Burst: 3})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d of the burst throttled", i)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("past the burst: got %v, %s, want throttled for 500ms", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("another client was throttled")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("not allowed once a token was refilled")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("allowed more than the rate")
+	}
+
+	now = now.Add(time.Hour)
+	l.sweep(now)
+	if clients, throttled := l.Stats(); clients != 0 || throttled != 2 {
+		t.Errorf("stats after sweeping idle clients: got %d clients, %d throttled", clients, throttled)
+	}
+}
@@ -0,0 +1,192 @@
+// Package replication streams the mutations applied by a cache.Adapter
+// on a primary to replicas over HTTP. Replicas bootstrap from a full
+// snapshot, then tail the stream of mutations following it. Replication
+// is asynchronous, the primary doesn't wait for replicas to apply a
+// mutation before replying to the client
+package replication
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+)
+
+// Record is one message of the stream. Heartbeats have no mutation and
+// carry the primary's latest sequence number
+type Record struct {
+	Seq      uint64          `json:"seq"`
+	Time     int64           `json:"time"` // Unix nanoseconds on the primary
+	Mutation *cache.Mutation `json:"mutation,omitempty"`
+}
+
+// Snapshot is the full state of the primary's cache at sequence Seq
+type Snapshot struct {
+	Seq     uint64        `json:"seq"`
+	Entries []cache.Entry `json:"entries"`
+}
+
+// heartbeatInterval is how often an idle stream sends a heartbeat
+const heartbeatInterval = time.Second
+
+// Primary keeps a log of the latest mutations of an adapter and serves
+// it to replicas. Replicas that fall further behind than the log's size
+// have to bootstrap again
+type Primary struct {
+	adapter *cache.Adapter
+	logSize int
+
+	mu       sync.Mutex
+	records  []Record
+	seq      uint64
+	changed  chan struct{} // closed and replaced on every new record
+	replicas map[string]uint64
+}
+
+// NewPrimary starts logging the adapter's mutations, keeping the latest
+// logSize of them
+func NewPrimary(adapter *cache.Adapter, logSize int) *Primary {
+	if logSize < 1 {
+		logSize = 1
+	}
+	p := &Primary{
+		adapter:  adapter,
+		logSize:  logSize,
+		changed:  make(chan struct{}),
+		replicas: make(map[string]uint64),
+	}
+	adapter.OnMutation(p.append)
+	adapter.AddStats(p.stats)
+	return p
+}
+
+// append is called by the adapter, in order, with it locked
+func (p *Primary) append(m cache.Mutation) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seq++
+	p.records = append(p.records, Record{Seq: p.seq, Time: time.Now().UnixNano(), Mutation: &m})
+	if len(p.records) >= 2*p.logSize {
+		p.records = append([]Record(nil), p.records[len(p.records)-p.logSize:]...)
+	}
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// since returns the records after seq, or false if they're no longer
+// in the log
+func (p *Primary) since(seq uint64) ([]Record, uint64, <-chan struct{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if seq > p.seq {
+		return nil, p.seq, p.changed, false
+	}
+	first := p.seq + 1 - uint64(len(p.records))
+	if seq+1 < first {
+		return nil, p.seq, p.changed, false
+	}
+	records := append([]Record(nil), p.records[seq+1-first:]...)
+	return records, p.seq, p.changed, true
+}
+
+func (p *Primary) stats() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := map[string]string{
+		"repl_role":     "primary",
+		"repl_seq":      strconv.FormatUint(p.seq, 10),
+		"repl_replicas": strconv.Itoa(len(p.replicas)),
+	}
+	for id, sent := range p.replicas {
+		st["repl_replica_"+id+"_lag_mutations"] = strconv.FormatUint(p.seq-sent, 10)
+	}
+	return st
+}
+
+// HandleSnapshot serves the full contents of the cache along with the
+// sequence number the stream should be tailed from
+func (p *Primary) HandleSnapshot(w http.ResponseWriter, r *http.Request) {
+	var snap Snapshot
+	entries, ok := p.adapter.Snapshot(func() {
+		p.mu.Lock()
+		snap.Seq = p.seq
+		p.mu.Unlock()
+	})
+	if !ok {
+		http.Error(w, "cache type doesn't support snapshots", http.StatusNotImplemented)
+		return
+	}
+	snap.Entries = entries
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snap)
+}
+
+// HandleStream serves the records after the from query parameter as
+// newline delimited JSON, for as long as the replica stays connected.
+// It replies 410 Gone if those records are no longer in the log
+func (p *Primary) HandleStream(w http.ResponseWriter, r *http.Request) {
+	from, err := strconv.ParseUint(r.URL.Query().Get("from"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		id = r.RemoteAddr
+	}
+	records, seq, changed, ok := p.since(from)
+	if !ok {
+		http.Error(w, "replica too far behind, bootstrap again", http.StatusGone)
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+
+	p.mu.Lock()
+	p.replicas[id] = from
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.replicas, id)
+		p.mu.Unlock()
+	}()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		for _, rec := range records {
+			if err := enc.Encode(rec); err != nil {
+				return
+			}
+			from = rec.Seq
+		}
+		if len(records) > 0 {
+			if flusher != nil {
+				flusher.Flush()
+			}
+			p.mu.Lock()
+			p.replicas[id] = from
+			p.mu.Unlock()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if err := enc.Encode(Record{Seq: seq, Time: time.Now().UnixNano()}); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-changed:
+		}
+		if records, seq, changed, ok = p.since(from); !ok {
+			// fell out of the log, the replica reconnects and bootstraps
+			return
+		}
+	}
+}
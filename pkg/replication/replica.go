@@ -0,0 +1,210 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+)
+
+// retryInterval is how long a replica waits before reconnecting
+const retryInterval = time.Second
+
+var errGone = errors.New("replication: primary no longer has the mutations needed")
+
+// Replica applies the mutations of a primary to an adapter
+type Replica struct {
+	adapter    *cache.Adapter
+	primary    string
+	id         string
+	httpClient *http.Client
+	errorLog   *log.Logger
+
+	mu         sync.Mutex
+	applied    uint64
+	primarySeq uint64
+	lastSeen   time.Time     // when the primary was last heard from
+	lag        time.Duration // how old the latest record was when applied
+	connected  bool
+	bootstraps int
+}
+
+// NewReplica returns a replica of the primary at the given address, e.g.
+// "http://primary:4000". id names the replica in the primary's stats
+func NewReplica(adapter *cache.Adapter, primary, id string, errorLog *log.Logger) *Replica {
+	if !strings.Contains(primary, "://") {
+		primary = "http://" + primary
+	}
+	r := &Replica{
+		adapter:    adapter,
+		primary:    strings.TrimSuffix(primary, "/"),
+		id:         id,
+		httpClient: &http.Client{},
+		errorLog:   errorLog,
+	}
+	adapter.AddStats(r.stats)
+	return r
+}
+
+// SetTransport sets how requests to the primary are sent, e.g. with
+// credentials. Must be called before Run
+func (r *Replica) SetTransport(t http.RoundTripper) {
+	r.httpClient.Transport = t
+}
+
+// Run replicates until ctx is done, bootstrapping from a snapshot
+// whenever the stream can't be resumed
+func (r *Replica) Run(ctx context.Context) {
+	needsBootstrap := true
+	for ctx.Err() == nil {
+		var err error
+		if needsBootstrap {
+			err = r.bootstrap(ctx)
+		}
+		if err == nil {
+			needsBootstrap = false
+			err = r.tail(ctx)
+		}
+		r.mu.Lock()
+		r.connected = false
+		r.mu.Unlock()
+		if errors.Is(err, errGone) {
+			needsBootstrap = true
+		}
+		if err != nil && ctx.Err() == nil {
+			r.errorLog.Printf("replication from %s: %s", r.primary, err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (r *Replica) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, r.primary+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusGone {
+		resp.Body.Close()
+		return nil, errGone
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return resp, nil
+}
+
+// bootstrap replaces the contents of the cache with a snapshot
+func (r *Replica) bootstrap(ctx context.Context) error {
+	resp, err := r.get(ctx, "/repl/snapshot")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var snap Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		return fmt.Errorf("decoding snapshot: %w", err)
+	}
+	r.adapter.Clear()
+	for _, e := range snap.Entries {
+		r.adapter.Apply(cache.Mutation{Op: cache.SetMutation, Entry: e})
+	}
+	r.mu.Lock()
+	r.applied = snap.Seq
+	r.primarySeq = snap.Seq
+	r.lastSeen = time.Now()
+	r.lag = 0
+	r.bootstraps++
+	r.mu.Unlock()
+	return nil
+}
+
+// tail applies the stream of mutations after the last applied one
+func (r *Replica) tail(ctx context.Context) error {
+	r.mu.Lock()
+	from := r.applied
+	r.mu.Unlock()
+	resp, err := r.get(ctx, "/repl/stream?from="+strconv.FormatUint(from, 10)+"&id="+r.id)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	r.mu.Lock()
+	r.connected = true
+	r.mu.Unlock()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("decoding record: %w", err)
+		}
+		if rec.Mutation != nil {
+			if rec.Seq != from+1 {
+				return fmt.Errorf("%w: got mutation %d after %d", errGone, rec.Seq, from)
+			}
+			r.adapter.Apply(*rec.Mutation)
+			from = rec.Seq
+		}
+		r.mu.Lock()
+		r.applied = from
+		if rec.Seq > r.primarySeq {
+			r.primarySeq = rec.Seq
+		}
+		r.lastSeen = time.Now()
+		// a mutation is as old as it was waiting to be applied, and a
+		// heartbeat, sent once every mutation before it was, as old as
+		// any mutation made when it was sent would be
+		r.lag = r.lastSeen.Sub(time.Unix(0, rec.Time))
+		if r.lag < 0 {
+			r.lag = 0
+		}
+		r.mu.Unlock()
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("stream closed by primary")
+}
+
+// stats reports how far behind the primary the replica is. The lag in
+// seconds is the age of the latest record applied, so that of a mutation
+// made just then once caught up, and assumes the clocks are in sync. The
+// time since the primary was last heard from is reported apart, and is
+// kept under a second or so by heartbeats while connected
+func (r *Replica) stats() map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lastContact := 0.0
+	if !r.lastSeen.IsZero() {
+		lastContact = time.Since(r.lastSeen).Seconds()
+	}
+	return map[string]string{
+		"repl_role":                 "replica",
+		"repl_primary":              r.primary,
+		"repl_connected":            strconv.FormatBool(r.connected),
+		"repl_applied_seq":          strconv.FormatUint(r.applied, 10),
+		"repl_primary_seq":          strconv.FormatUint(r.primarySeq, 10),
+		"repl_lag_mutations":        strconv.FormatUint(r.primarySeq-r.applied, 10),
+		"repl_lag_seconds":          strconv.FormatFloat(r.lag.Seconds(), 'f', 3, 64),
+		"repl_last_contact_seconds": strconv.FormatFloat(lastContact, 'f', 3, 64),
+		"repl_bootstraps":           strconv.Itoa(r.bootstraps),
+	}
+}
@@ -0,0 +1,123 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	_ "github.com/nagamocha3000/go-memcached/pkg/cache/policies"
+)
+
+func newAdapter(t *testing.T) *cache.Adapter {
+	c, err := cache.NewCache("lru", 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the replica")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	primaryCache := newAdapter(t)
+	primary := NewPrimary(primaryCache, 100)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repl/snapshot", primary.HandleSnapshot)
+	mux.HandleFunc("/repl/stream", primary.HandleStream)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// written before the replica connects, so it comes from the snapshot
+	priority := true
+	primaryCache.Set("a", "1", "100", nil)
+	primaryCache.Set("b", "2", "0", &cache.Hints{Priority: &priority})
+
+	replicaCache := newAdapter(t)
+	replicaCache.Set("stale", "x", "0", nil)
+	replica := NewReplica(replicaCache, srv.URL, "test", log.New(ioutil.Discard, "", 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go replica.Run(ctx)
+
+	waitFor(t, func() bool {
+		_, val := replicaCache.Get("b")
+		return val == "2"
+	})
+	if reply, _ := replicaCache.Get("stale"); reply != cache.NotFoundReply {
+		t.Errorf("expected entries not on the primary to be dropped, got %s", reply)
+	}
+
+	// streamed
+	primaryCache.Append("a", "1", "0")
+	primaryCache.Delete("b")
+	primaryCache.Set("c", "3", "0", nil)
+	waitFor(t, func() bool {
+		_, val := replicaCache.Get("c")
+		return val == "3"
+	})
+	if _, val := replicaCache.Get("a"); val != "11" {
+		t.Errorf("expected a to be 11, got %q", val)
+	}
+	if reply, _ := replicaCache.Get("b"); reply != cache.NotFoundReply {
+		t.Errorf("expected b to be deleted, got %s", reply)
+	}
+	_, st := replicaCache.Stats()
+	if st["repl_applied_seq"] != "5" || st["repl_lag_mutations"] != "0" {
+		t.Errorf("unexpected replication stats %v", st)
+	}
+}
+
+func TestReplicaLag(t *testing.T) {
+	// a primary whose stream is a mutation made 5s ago, followed by
+	// nothing for a while
+	sent := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repl/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Snapshot{Seq: 1})
+	})
+	mux.HandleFunc("/repl/stream", func(w http.ResponseWriter, r *http.Request) {
+		m := cache.Mutation{Op: cache.SetMutation, Entry: cache.Entry{Key: "a", Value: "1"}}
+		json.NewEncoder(w).Encode(Record{Seq: 2, Time: time.Now().Add(-5 * time.Second).UnixNano(), Mutation: &m})
+		w.(http.Flusher).Flush()
+		close(sent)
+		<-r.Context().Done()
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	replicaCache := newAdapter(t)
+	replica := NewReplica(replicaCache, srv.URL, "test", log.New(ioutil.Discard, "", 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go replica.Run(ctx)
+	<-sent
+	waitFor(t, func() bool {
+		_, val := replicaCache.Get("a")
+		return val == "1"
+	})
+	time.Sleep(200 * time.Millisecond)
+	_, st := replicaCache.Stats()
+	lag, _ := strconv.ParseFloat(st["repl_lag_seconds"], 64)
+	lastContact, _ := strconv.ParseFloat(st["repl_last_contact_seconds"], 64)
+	if lag < 5 || lag > 6 {
+		t.Errorf("got a lag of %s, want the mutation's 5s", st["repl_lag_seconds"])
+	}
+	if lastContact < 0.2 || lastContact > 1 {
+		t.Errorf("got the last contact %ss ago, want 0.2s", st["repl_last_contact_seconds"])
+	}
+}
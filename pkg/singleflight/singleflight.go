@@ -0,0 +1,54 @@
+// Package singleflight coalesces concurrent calls for the same key into
+// one, so that a burst of misses on a hot key loads it only once
+package singleflight
+
+import "sync"
+
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+	dup int
+}
+
+// Group runs at most one function per key at a time. The zero value is
+// ready to use
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// Do calls fn and returns its results, unless a call for the key is
+// already in flight, in which case it waits for that call and returns
+// its results instead. shared reports whether the results were given to
+// more than one caller
+func (g *Group) Do(key string, fn func() (interface{}, error)) (val interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		c.dup++
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	// the call is forgotten even if fn panics, so later calls don't hang
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.val, c.err = fn()
+
+	g.mu.Lock()
+	shared = c.dup > 0
+	g.mu.Unlock()
+	return c.val, c.err, shared
+}
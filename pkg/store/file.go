@@ -0,0 +1,180 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+)
+
+// FileStore is an embedded store keeping every entry in memory and
+// persisting the mutations to an append-only log file, which is replayed
+// on open and compacted once it holds mostly overwritten entries. It's
+// meant as a stand-in for a database in tests and small deployments
+type FileStore struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries map[string]cache.Entry
+	logLen  int // mutations in the log
+}
+
+// compactMin is the log length below which it's never compacted
+const compactMin = 1024
+
+// OpenFile opens the store at path, creating it if needed
+func OpenFile(path string) (*FileStore, error) {
+	s := &FileStore{path: path, entries: make(map[string]cache.Entry)}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	s.file = file
+	return s, nil
+}
+
+func (s *FileStore) replay() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var m cache.Mutation
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			// a torn write at the end of the log from a crash
+			break
+		}
+		s.apply(m)
+		s.logLen++
+	}
+	return scanner.Err()
+}
+
+// apply updates the in memory entries. Callers hold mu
+func (s *FileStore) apply(m cache.Mutation) {
+	switch m.Op {
+	case cache.SetMutation:
+		s.entries[m.Key] = m.Entry
+	case cache.DeleteMutation:
+		delete(s.entries, m.Key)
+	}
+}
+
+// Write appends the batch to the log and syncs it
+func (s *FileStore) Write(ctx context.Context, batch []cache.Mutation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return fmt.Errorf("store: closed")
+	}
+	var buf []byte
+	for _, m := range batch {
+		if m.Op != cache.SetMutation && m.Op != cache.DeleteMutation {
+			continue
+		}
+		line, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	if _, err := s.file.Write(buf); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	for _, m := range batch {
+		s.apply(m)
+		s.logLen++
+	}
+	if s.logLen > compactMin && s.logLen > 2*len(s.entries) {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact rewrites the log with only the live entries. Callers hold mu
+func (s *FileStore) compact() error {
+	tmpPath := s.path + ".compact"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("store: compacting: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	now := time.Now().Unix()
+	n := 0
+	for _, e := range s.entries {
+		if e.Expire != 0 && e.Expire <= now {
+			continue
+		}
+		if err := enc.Encode(cache.Mutation{Op: cache.SetMutation, Entry: e}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("store: compacting: %w", err)
+		}
+		n++
+	}
+	if err = w.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("store: compacting: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("store: compacting: %w", err)
+	}
+	s.file.Close()
+	if s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return fmt.Errorf("store: compacting: %w", err)
+	}
+	s.logLen = n
+	return nil
+}
+
+// Load returns the stored entry, or ErrNotFound if it isn't stored or
+// has expired
+func (s *FileStore) Load(ctx context.Context, key string) (cache.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || (e.Expire != 0 && e.Expire <= time.Now().Unix()) {
+		return cache.Entry{}, ErrNotFound
+	}
+	return e, nil
+}
+
+// Len returns the number of stored entries, including expired ones
+func (s *FileStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Close closes the log file
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
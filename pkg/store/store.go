@@ -0,0 +1,43 @@
+// Package store persists the writes made through a cache.Adapter to a
+// backing store, either synchronously (write-through) or queued and
+// batched in the background (write-behind)
+package store
+
+import (
+	"context"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	"github.com/nagamocha3000/go-memcached/pkg/loader"
+)
+
+// ErrNotFound is returned by Load for keys that aren't stored
+var ErrNotFound = loader.ErrNotFound
+
+// Store is a backing store for a cache
+type Store interface {
+	// Write persists a batch of set and delete mutations, in order.
+	// Mutations carry the state they result in, so a batch that failed
+	// part way can be written again whole
+	Write(ctx context.Context, batch []cache.Mutation) error
+	// Load returns the stored entry, or ErrNotFound
+	Load(ctx context.Context, key string) (cache.Entry, error)
+}
+
+// Loader returns a loader of the entries in s, so that misses are read
+// through from the store
+func Loader(s Store) loader.Func {
+	return func(ctx context.Context, key string) (string, int, error) {
+		e, err := s.Load(ctx, key)
+		if err != nil {
+			return "", 0, err
+		}
+		exptime := 0
+		if e.Expire != 0 {
+			if exptime = int(e.Expire - time.Now().Unix()); exptime < 1 {
+				return "", 0, ErrNotFound
+			}
+		}
+		return e.Value, exptime, nil
+	}
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+	_ "github.com/nagamocha3000/go-memcached/pkg/cache/policies"
+	"github.com/nagamocha3000/go-memcached/pkg/loader"
+)
+
+// flakyStore wraps a store, failing writes while down
+type flakyStore struct {
+	Store
+	mu      sync.Mutex
+	down    bool
+	batches [][]cache.Mutation
+}
+
+func (s *flakyStore) Write(ctx context.Context, batch []cache.Mutation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errors.New("store is down")
+	}
+	s.batches = append(s.batches, batch)
+	return s.Store.Write(ctx, batch)
+}
+
+func (s *flakyStore) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func openTestStore(t *testing.T) (*FileStore, string) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "store.log")
+	fs, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs, path
+}
+
+func newAdapter(t *testing.T) *cache.Adapter {
+	c, err := cache.NewCache("lfu", 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestWriteThrough(t *testing.T) {
+	fs, path := openTestStore(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	s := &flakyStore{Store: fs}
+	c := newAdapter(t)
+	NewWriteThrough(c, s, time.Second)
+
+	c.Set("a", "1", "0", nil)
+	c.Append("a", "2", "0")
+	c.Set("b", "1", "0", nil)
+	c.Delete("b")
+
+	s.setDown(true)
+	if reply := c.Set("a", "3", "0", nil); !strings.HasPrefix(string(reply), cache.ServerErrorReply) {
+		t.Errorf("expected a failed write to reply SERVER_ERROR, got %s", reply)
+	}
+	if reply := c.Add("c", "3", "0", nil); !strings.HasPrefix(string(reply), cache.ServerErrorReply) {
+		t.Errorf("expected a failed write to reply SERVER_ERROR, got %s", reply)
+	}
+	if _, val := c.Get("a"); val != "12" {
+		t.Errorf("expected the failed write to be undone, got %q", val)
+	}
+	if reply, _ := c.Get("c"); reply != cache.NotFoundReply {
+		t.Errorf("expected the failed add to be undone, got %s", reply)
+	}
+	fs.Close()
+
+	// the log is replayed on open
+	fs, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if e, err := fs.Load(context.Background(), "a"); err != nil || e.Value != "12" {
+		t.Errorf("expected a to be stored as 12, got %v, %v", e, err)
+	}
+	if _, err := fs.Load(context.Background(), "b"); err != ErrNotFound {
+		t.Errorf("expected b to be deleted, got %v", err)
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	fs, path := openTestStore(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer fs.Close()
+	s := &flakyStore{Store: fs, down: true}
+	c := newAdapter(t)
+	wb := NewWriteBehind(c, s, WriteBehindOptions{
+		QueueSize:     5,
+		BatchSize:     10,
+		FlushInterval: 10 * time.Millisecond,
+		MaxBackoff:    20 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go wb.Run(ctx)
+
+	for _, val := range []string{"1", "2", "3", "4"} {
+		if reply := c.Set("a", val, "0", nil); reply != cache.StoredReply {
+			t.Fatalf("expected writes to be queued, got %s", reply)
+		}
+	}
+	c.Set("b", "1", "0", nil)
+	// the store is down, so the queue fills up and pushes back
+	if reply := c.Set("c", "1", "0", nil); !strings.HasPrefix(string(reply), cache.ServerErrorReply) {
+		t.Errorf("expected a full queue to reply SERVER_ERROR, got %s", reply)
+	}
+	if _, st := c.Stats(); st["store_queue_depth"] != "5" {
+		t.Errorf("expected a queue depth of 5, got %s", st["store_queue_depth"])
+	}
+
+	s.setDown(false)
+	flushCtx, flushCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer flushCancel()
+	if err := wb.Flush(flushCtx); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.batches) != 1 || len(s.batches[0]) != 2 {
+		t.Errorf("expected one batch with the writes to a coalesced, got %v", s.batches)
+	}
+	if e, err := fs.Load(ctx, "a"); err != nil || e.Value != "4" {
+		t.Errorf("expected the last write to a to be stored, got %v, %v", e, err)
+	}
+}
+
+func TestReadThroughFillsAreNotWritten(t *testing.T) {
+	fs, path := openTestStore(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer fs.Close()
+	ctx := context.Background()
+	if err := fs.Write(ctx, []cache.Mutation{{Op: cache.SetMutation, Entry: cache.Entry{Key: "a", Value: "1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	s := &flakyStore{Store: fs, down: true}
+	c := newAdapter(t)
+	NewWriteThrough(c, s, time.Second)
+	rt := loader.New(c)
+	rt.Register("", Loader(s))
+
+	if val, err := rt.Get(ctx, "a"); err != nil || val != "1" {
+		t.Fatalf("read through: got %q, %v", val, err)
+	}
+	if reply, val := c.Get("a"); reply != cache.ValueReply || val != "1" {
+		t.Errorf("expected the loaded value to be kept, got %s %q", reply, val)
+	}
+	if len(s.batches) != 0 {
+		t.Errorf("expected no writes for a read, got %v", s.batches)
+	}
+}
@@ -0,0 +1,242 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+)
+
+// ErrQueueFull is returned for writes while the write-behind queue is
+// full, which pushes back on clients when the store can't keep up
+var ErrQueueFull = errors.New("store: write-behind queue full")
+
+// WriteBehindOptions configures a WriteBehind. Zero values get defaults
+type WriteBehindOptions struct {
+	// QueueSize is the number of writes that may wait to be persisted
+	// before new ones are refused. Defaults to 10000
+	QueueSize int
+	// BatchSize is the most writes persisted at once. Defaults to 100
+	BatchSize int
+	// FlushInterval is how long writes may wait for a batch to fill.
+	// Defaults to 100ms
+	FlushInterval time.Duration
+	// MaxBackoff caps the wait between retries of a failed batch, which
+	// doubles from FlushInterval. Defaults to 30s
+	MaxBackoff time.Duration
+	// Timeout limits each write of a batch, zero for none
+	Timeout time.Duration
+
+	ErrorLog *log.Logger
+}
+
+// WriteBehind queues the writes made through an adapter and persists
+// them to the store in batches in the background. Failed batches are
+// retried until they succeed, in order, so the store only ever falls
+// behind. Writes are acknowledged once queued, so those still queued are
+// lost if the process dies
+type WriteBehind struct {
+	store Store
+	opts  WriteBehindOptions
+
+	mu      sync.Mutex
+	queue   []cache.Mutation
+	flushed chan struct{} // closed and replaced when the queue empties
+	wake    chan struct{}
+
+	batches  int64
+	written  int64
+	retries  int64
+	rejected int64
+	failing  bool
+}
+
+// NewWriteBehind queues the writes made through c to be persisted to s
+// by Run
+func NewWriteBehind(c *cache.Adapter, s Store, opts WriteBehindOptions) *WriteBehind {
+	if opts.QueueSize < 1 {
+		opts.QueueSize = 10000
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 30 * time.Second
+	}
+	wb := &WriteBehind{
+		store:   s,
+		opts:    opts,
+		flushed: make(chan struct{}),
+		wake:    make(chan struct{}, 1),
+	}
+	c.SetWriteHook(wb.enqueue)
+	c.AddStats(wb.Stats)
+	return wb
+}
+
+func (wb *WriteBehind) enqueue(m cache.Mutation) error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if len(wb.queue) >= wb.opts.QueueSize {
+		wb.rejected++
+		return ErrQueueFull
+	}
+	wb.queue = append(wb.queue, m)
+	if len(wb.queue) >= wb.opts.BatchSize {
+		select {
+		case wb.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Run persists the queued writes until ctx is done, then makes a last
+// attempt at persisting whatever is still queued
+func (wb *WriteBehind) Run(ctx context.Context) {
+	ticker := time.NewTicker(wb.opts.FlushInterval)
+	defer ticker.Stop()
+	backoff := wb.opts.FlushInterval
+	for {
+		select {
+		case <-ctx.Done():
+			// a last attempt, without retries
+			for wb.Depth() > 0 {
+				if err := wb.flushBatch(context.Background()); err != nil {
+					wb.logf("write-behind: %s, %d writes lost", err, wb.Depth())
+					return
+				}
+			}
+			return
+		case <-ticker.C:
+		case <-wb.wake:
+		}
+		for wb.Depth() > 0 {
+			if err := wb.flushBatch(ctx); err != nil {
+				wb.logf("write-behind: %s, retrying in %s", err, backoff)
+				select {
+				case <-ctx.Done():
+				case <-time.After(backoff):
+				}
+				if backoff *= 2; backoff > wb.opts.MaxBackoff {
+					backoff = wb.opts.MaxBackoff
+				}
+				break
+			}
+			backoff = wb.opts.FlushInterval
+		}
+	}
+}
+
+func (wb *WriteBehind) logf(format string, args ...interface{}) {
+	if wb.opts.ErrorLog != nil {
+		wb.opts.ErrorLog.Printf(format, args...)
+	}
+}
+
+// flushBatch persists the oldest queued writes, coalescing writes to the
+// same key, and takes them off the queue if they were persisted
+func (wb *WriteBehind) flushBatch(ctx context.Context) error {
+	wb.mu.Lock()
+	n := len(wb.queue)
+	if n == 0 {
+		wb.mu.Unlock()
+		return nil
+	}
+	if n > wb.opts.BatchSize {
+		n = wb.opts.BatchSize
+	}
+	batch := coalesce(wb.queue[:n])
+	wb.mu.Unlock()
+
+	if wb.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wb.opts.Timeout)
+		defer cancel()
+	}
+	err := wb.store.Write(ctx, batch)
+
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if err != nil {
+		wb.retries++
+		wb.failing = true
+		return err
+	}
+	wb.failing = false
+	wb.batches++
+	wb.written += int64(len(batch))
+	wb.queue = append(wb.queue[:0], wb.queue[n:]...)
+	if len(wb.queue) == 0 {
+		close(wb.flushed)
+		wb.flushed = make(chan struct{})
+	}
+	return nil
+}
+
+// coalesce keeps only the last write to each key, in the order of those
+// last writes
+func coalesce(queued []cache.Mutation) []cache.Mutation {
+	last := make(map[string]int, len(queued))
+	for i, m := range queued {
+		last[m.Key] = i
+	}
+	batch := make([]cache.Mutation, 0, len(last))
+	for i, m := range queued {
+		if last[m.Key] == i {
+			batch = append(batch, m)
+		}
+	}
+	return batch
+}
+
+// Depth returns the number of writes waiting to be persisted
+func (wb *WriteBehind) Depth() int {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	return len(wb.queue)
+}
+
+// Flush waits until the queue is empty, or ctx is done
+func (wb *WriteBehind) Flush(ctx context.Context) error {
+	wb.mu.Lock()
+	if len(wb.queue) == 0 {
+		wb.mu.Unlock()
+		return nil
+	}
+	flushed := wb.flushed
+	wb.mu.Unlock()
+	select {
+	case wb.wake <- struct{}{}:
+	default:
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the queue depth and write-behind counters
+func (wb *WriteBehind) Stats() map[string]string {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	return map[string]string{
+		"store_mode":           "write-behind",
+		"store_queue_depth":    strconv.Itoa(len(wb.queue)),
+		"store_queue_capacity": strconv.Itoa(wb.opts.QueueSize),
+		"store_batches":        strconv.FormatInt(wb.batches, 10),
+		"store_writes":         strconv.FormatInt(wb.written, 10),
+		"store_retries":        strconv.FormatInt(wb.retries, 10),
+		"store_rejected":       strconv.FormatInt(wb.rejected, 10),
+		"store_failing":        strconv.FormatBool(wb.failing),
+	}
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	cache "github.com/nagamocha3000/go-memcached/pkg/cache"
+)
+
+// WriteThrough persists every write to the store before it's
+// acknowledged. Writes the store fails are undone and reply SERVER_ERROR
+type WriteThrough struct {
+	store   Store
+	timeout time.Duration
+
+	writes      int64
+	writeErrors int64
+}
+
+// NewWriteThrough persists the writes made through c to s, giving each
+// write at most timeout, zero for no limit. Every write waits for the
+// store with the adapter locked, so a slow store slows every command
+func NewWriteThrough(c *cache.Adapter, s Store, timeout time.Duration) *WriteThrough {
+	wt := &WriteThrough{store: s, timeout: timeout}
+	c.SetWriteHook(wt.write)
+	c.AddStats(wt.Stats)
+	return wt
+}
+
+func (wt *WriteThrough) write(m cache.Mutation) error {
+	ctx := context.Background()
+	if wt.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wt.timeout)
+		defer cancel()
+	}
+	atomic.AddInt64(&wt.writes, 1)
+	if err := wt.store.Write(ctx, []cache.Mutation{m}); err != nil {
+		atomic.AddInt64(&wt.writeErrors, 1)
+		return err
+	}
+	return nil
+}
+
+// Stats returns the write-through counters
+func (wt *WriteThrough) Stats() map[string]string {
+	return map[string]string{
+		"store_mode":         "write-through",
+		"store_writes":       strconv.FormatInt(atomic.LoadInt64(&wt.writes), 10),
+		"store_write_errors": strconv.FormatInt(atomic.LoadInt64(&wt.writeErrors), 10),
+	}
+}